@@ -32,6 +32,7 @@ const (
 	flagSimplify  flagName = "simplify"
 	flagPackage   flagName = "package"
 	flagInject    flagName = "inject"
+	flagLint      flagName = "lint"
 
 	flagExpression  flagName = "expression"
 	flagSchema      flagName = "schema"
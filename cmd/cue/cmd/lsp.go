@@ -15,13 +15,26 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/prometheus/common/log"
 	"github.com/spf13/cobra"
 
 	"cuelang.org/go/cue/lsp"
 )
 
-var configFile string
+// lspConfigFile is the default configuration file of the language server.
+const lspConfigFile = "cue-lsp.yml"
+
+var (
+	configFile  string
+	listen      string
+	idleTimeout time.Duration
+	debugAddr   string
+)
 
 // newDefCmd creates a new eval command
 func newLspCmd(c *Command) *cobra.Command {
@@ -30,16 +43,158 @@ func newLspCmd(c *Command) *cobra.Command {
 		Short: "start language server",
 		Long: `lsp starts the CUE language server.
 
-At the moment, communication is limited to stdio.
+Usage:
+
+	cue lsp                    serve one editor over stdio
+	cue lsp --listen tcp:ADDR  serve editors on a TCP address, or a Unix
+	                           socket with unix:PATH; they share one
+	                           cache, and --idle-timeout stops the server
+	                           once none is connected
+	cue lsp --debug ADDR       also serve pages for inspecting the server
+	cue lsp check [paths]      report the diagnostics of the server, as
+	                           text, json or sarif
+	cue lsp replay <trace>     replay a session recorded with
+	                           rpc_trace: json
+
+The configuration file, cue-lsp.yml unless --config-file is given,
+selects the REST API, metrics and tracing, and holds the default cue
+settings of editors.
 `,
 		RunE: mkRunE(c, runLsp),
 	}
 
-	cmd.Flags().StringVar(&configFile, "config-file", "cue-lsp.yml", "Path to yml config file.")
+	cmd.PersistentFlags().StringVar(&configFile, "config-file", lspConfigFile, "Path to yml config file.")
+	cmd.Flags().StringVar(&listen, "listen", "", "Address to accept clients on, as tcp:ADDR or unix:PATH, instead of stdio.")
+	cmd.Flags().DurationVar(&idleTimeout, "idle-timeout", 0, "Exit after no client was connected for this duration. Requires --listen.")
+	cmd.Flags().StringVar(&debugAddr, "debug", "", "Address to serve debug pages on, like localhost:6060.")
 	// TODO: Option to include comments in output.
+
+	cmd.AddCommand(newLspCheckCmd(c))
+	cmd.AddCommand(newLspReplayCmd(c))
+
+	return cmd
+}
+
+var checkFormat string
+
+func newLspCheckCmd(c *Command) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check [files or directories]",
+		Short: "report the diagnostics of the language server",
+		Long: `check reports the diagnostics the language server publishes
+for all matching CUE files, so CI reports the same problems as editors.
+This includes the checks that are only done by the language server,
+like unresolved references and clashing import aliases.
+
+Arguments are files or directories. A directory followed by /...
+includes all directories below it, except cue.mod and directories
+starting with . or _. Without arguments, the current directory is
+checked.
+
+The --format flag selects the output:
+
+	text   one line per diagnostic (default)
+	json   one JSON object per diagnostic and line
+	sarif  a SARIF 2.1.0 log, for code scanning tools
+
+The configuration file is only used if it exists or is given
+explicitly. check exits with a non-zero status if there are errors.
+
+Example:
+
+	cue lsp check --format sarif ./... > cue.sarif
+`,
+		RunE: mkRunE(c, runLspCheck),
+	}
+
+	cmd.Flags().StringVar(&checkFormat, "format", "text", "Output format: text, json or sarif.")
+
+	return cmd
+}
+
+// optionalConfigFile returns the configuration file, or an empty path if it is the default and does not exist.
+func optionalConfigFile(cmd *Command) string {
+	config := configFile
+	if f := cmd.Flag("config-file"); f == nil || !f.Changed {
+		if _, err := os.Stat(config); err != nil {
+			config = ""
+		}
+	}
+
+	return config
+}
+
+func runLspCheck(cmd *Command, args []string) error {
+	errs, err := lsp.Check(cmd.OutOrStdout(), optionalConfigFile(cmd), checkFormat, args)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case errs == 1:
+		fmt.Fprintln(cmd.Stderr(), "found 1 error")
+	case errs > 1:
+		fmt.Fprintf(cmd.Stderr(), "found %d errors\n", errs)
+	}
+
+	return nil
+}
+
+var replayRoot string
+
+func newLspReplayCmd(c *Command) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay <trace>",
+		Short: "replay a recorded session against the language server",
+		Long: `replay feeds the messages an editor sent in a recorded session to a
+new language server, and reports where its responses differ from the
+recorded ones, as unified diffs. The diagnostics last published for the
+documents that are still open are compared as well.
+
+A session is recorded by setting rpc_trace: json in the configuration
+file, which logs every message to stderr. Attach such a trace to a bug
+report, so the problem can be reproduced without an editor.
+
+The files of the workspace are read from disk. With --root, the
+workspace of the recording is moved to another directory, like a
+checkout of the files of the bug report.
+
+The configuration file is only used if it exists or is given
+explicitly. replay exits with a non-zero status if there are
+differences.
+
+Example:
+
+	cue lsp replay --root ./repro trace.log
+`,
+		RunE: mkRunE(c, runLspReplay),
+	}
+
+	cmd.Flags().StringVar(&replayRoot, "root", "", "Directory to move the recorded workspace to.")
+
 	return cmd
 }
 
+func runLspReplay(cmd *Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("replay requires exactly one trace file")
+	}
+
+	differences, err := lsp.Replay(cmd.OutOrStdout(), optionalConfigFile(cmd), args[0], replayRoot)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case differences == 1:
+		fmt.Fprintln(cmd.Stderr(), "found 1 difference")
+	case differences > 1:
+		fmt.Fprintf(cmd.Stderr(), "found %d differences\n", differences)
+	}
+
+	return nil
+}
+
 func runLsp(cmd *Command, args []string) error {
 	err := cmd.ParseFlags(args)
 	if err != nil {
@@ -48,7 +203,15 @@ func runLsp(cmd *Command, args []string) error {
 	//log.Infof("Starting lsp server with config: %s", configFile)
 	//fmt.Fprintf(cmd.OutOrStdout(), "Starting lsp server with config: %s", configFile)
 
-	lsp.StartLSPServer(configFile)
+	if idleTimeout != 0 && listen == "" {
+		return errors.New("--idle-timeout requires --listen")
+	}
+
+	if listen != "" {
+		return lsp.ListenLSPServer(configFile, listen, idleTimeout, debugAddr)
+	}
+
+	lsp.StartLSPServer(configFile, debugAddr)
 
 	return nil
 }
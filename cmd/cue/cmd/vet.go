@@ -15,11 +15,16 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
 	"golang.org/x/text/message"
 
 	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
 	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/lsp"
+	"cuelang.org/go/cue/token"
 )
 
 const vetDoc = `vet validates CUE and other data files
@@ -59,6 +64,35 @@ Examples:
   cue vet translations/*.yaml foo.cue -e Translation
 
 If more than one expression is given, all must match all values.
+
+
+Linting
+
+The --lint flag also reports code that is valid, but likely a mistake,
+using the lint rules of the language server:
+
+  unused-hidden      hidden fields and definitions that are not used
+  unused-definition  definitions that are not used in their package
+  unused-import      imports that are not used
+  shadow             fields hiding a field of an outer struct from a
+                     reference
+  non-concrete       references that make a regular field non-concrete
+  duplicate-element  lists of literals containing an element twice
+
+The severity of the rules is set in the lint section of the cue
+section of cue-lsp.yml, if it exists in the current directory:
+
+  cue:
+    lint:
+      unused-definition: off
+      shadow: error
+
+Problems are ignored on the line following a comment like
+
+  // cue-lint:ignore shadow
+
+or on the line of such a comment. vet fails if there are problems with
+the error severity.
 `
 
 func newVetCmd(c *Command) *cobra.Command {
@@ -77,6 +111,9 @@ func newVetCmd(c *Command) *cobra.Command {
 	cmd.Flags().StringArrayP(string(flagInject), "t", nil,
 		"set the value of a tagged field")
 
+	cmd.Flags().Bool(string(flagLint), false,
+		"report the problems of the lint rules of the language server")
+
 	return cmd
 }
 
@@ -137,9 +174,30 @@ func doVet(cmd *Command, args []string) error {
 		exitOnErr(cmd, err, false)
 	}
 	exitOnErr(cmd, iter.err(), true)
+
+	if flagLint.Bool(cmd) {
+		lintInstances(cmd, b.insts)
+	}
 	return nil
 }
 
+// lintInstances reports the problems the lint rules find in the given
+// instances.
+func lintInstances(cmd *Command, insts []*build.Instance) {
+	config := lspConfigFile
+	if _, err := os.Stat(config); err != nil {
+		config = ""
+	}
+
+	// Problems are not errors unless a rule says so, so they must not
+	// cause a non-zero exit code on their own.
+	errs, err := lsp.Lint(cmd.OutOrStderr(), config, insts)
+	exitOnErr(cmd, err, true)
+	if errs > 0 {
+		exitOnErr(cmd, errors.Newf(token.NoPos, "lint found %d errors", errs), true)
+	}
+}
+
 func vetFiles(cmd *Command, b *buildPlan) {
 	// Use -r type root, instead of -e
 
@@ -14,6 +14,8 @@
 
 package adt
 
+import "cuelang.org/go/cue/ast"
+
 // An Environment links the parent scopes for identifier lookup to a composite
 // node. Each conjunct that make up node in the tree can be associated with
 // a different environment (although some conjuncts may share an Environment).
@@ -12,6 +12,7 @@ import (
 	"go/token"
 
 	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/internal/lsp/tool"
 )
 
 // Create a markdown cue code expression.
@@ -186,6 +187,12 @@ func initBuiltinPkgs() map[string]*Package {
 			b.Comment += "\n" + docComment
 			p.Builtins = append(p.Builtins, b)
 		}
+		// The tasks of the tool packages are declared in CUE, not natively.
+		for _, t := range tool.Tasks() {
+			if t.Package == id {
+				p.Builtins = append(p.Builtins, &Builtin{Name: t.Name, Comment: t.Markdown()})
+			}
+		}
 		ret[id] = p
 	}
 	return ret
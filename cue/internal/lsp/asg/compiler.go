@@ -2,6 +2,7 @@ package asg
 
 import (
 	"fmt"
+	"io/ioutil"
 	"path"
 	"strconv"
 
@@ -11,10 +12,14 @@ import (
 	"cuelang.org/go/cue/errors"
 	"cuelang.org/go/cue/load"
 	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
 )
 
 type Compiler struct {
 	LoadConfig *load.Config
+	// ReadFile reads the tool files of the compiled package if LoadConfig.Tools is set,
+	// since the loader does not parse them. If it is nil, they are read from disk.
+	ReadFile func(filename string) ([]byte, error)
 }
 
 func NewCompiler(config *load.Config) *Compiler {
@@ -45,6 +50,10 @@ func (i *index) complete(p *Package) {
 }
 
 func (i *index) addErr(err error) {
+	if err == nil {
+		return
+	}
+
 	i.err = errors.Append(i.err, errors.Promote(err, ""))
 }
 
@@ -74,11 +83,58 @@ func (c *Compiler) CompileFile(filename string) (*Package, error) {
 		return nil, errors.New("failed to load any instance for path: " + filename)
 	}
 
+	// Errors with a position, like missing imports, are reported while compiling, but
+	// errors of the package as a whole, like files of several packages, are only known to the loader.
+	for _, err := range errors.Errors(inst.Err) {
+		if err.Position() == token.NoPos && len(errors.Positions(err)) == 0 {
+			idx.addErr(err)
+		}
+	}
+
+	pkg := c.compileInstance(idx, inst, c.toolFiles(idx, inst)...)
+	return pkg, idx.err
+}
+
+// CompileInstance compiles a package that has already been loaded, along with the packages it imports.
+func (c *Compiler) CompileInstance(inst *build.Instance) (*Package, error) {
+	idx := c.newIndex()
+
 	pkg := c.compileInstance(idx, inst)
 	return pkg, idx.err
 }
 
-func (c *Compiler) compileInstance(idx *index, inst *build.Instance) *Package {
+// toolFiles parses the tool files of an instance, if tools are loaded.
+func (c *Compiler) toolFiles(idx *index, inst *build.Instance) []*ast.File {
+	if !c.LoadConfig.Tools {
+		return nil
+	}
+
+	read := c.ReadFile
+	if read == nil {
+		read = ioutil.ReadFile
+	}
+
+	var ret []*ast.File
+
+	for _, name := range inst.ToolCUEFiles {
+		filename := inst.Abs(name)
+
+		src, err := read(filename)
+		if err != nil {
+			idx.addErr(errors.Promote(err, "reading tool file"))
+			continue
+		}
+
+		if file, _ := c.LoadConfig.ParseFile(filename, src); file != nil {
+			ret = append(ret, file)
+		}
+	}
+
+	return ret
+}
+
+// compileInstance compiles a loaded package, including the given files that the loader did not parse.
+func (c *Compiler) compileInstance(idx *index, inst *build.Instance, extra ...*ast.File) *Package {
 	path := inst.Dir
 	if existing, ok := idx.packages[path]; ok {
 		if existing.complete {
@@ -104,7 +160,7 @@ func (c *Compiler) compileInstance(idx *index, inst *build.Instance) *Package {
 
 	// Next compile all files in package
 	// TODO: unify stuff across file boundaries
-	for _, file := range inst.Files {
+	for _, file := range append(inst.Files[:len(inst.Files):len(inst.Files)], extra...) {
 		astutil.Resolve(file, nil)
 		f := c.compileFile(idx, inst, pkg, file)
 		pkg.Files = append(pkg.Files, f)
@@ -188,13 +244,19 @@ func (c *Compiler) compileDecl(idx *index, parent DeclStore, decl ast.Decl) {
 		d.Labels = []Node{c.compileValue(idx, d, n.Label)}
 		d.LabelName = label
 		d.Values = c.compileExpr(idx, d, n.Value)
+	case *ast.EmbedDecl:
+		// Embeddings have no label, so they are never merged with other declarations.
+		d.Values = c.compileExpr(idx, d, n.Expr)
+		decls := parent.Declarations()
+		*decls = append(*decls, d)
+		return
 	}
 
 	decls := parent.Declarations()
 	found := false
 	for _, existing := range *decls {
 		// Preexisting declaration!
-		if existing.LabelName == d.LabelName {
+		if existing.LabelName == d.LabelName && !existing.IsEmbedding() {
 			existing.Labels = append(existing.Labels, d.Labels...)
 			existing.Values = append(existing.Values, d.Values...)
 			found = true
@@ -1,5 +1,12 @@
 package asg
 
+import (
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/token"
+)
+
 // Since both Files and Structs may store declarations, this interface was created.
 // In some scenarios we don't really care whether it's a file or a struct.
 type DeclStore interface {
@@ -17,3 +24,21 @@ func (f *File) Declarations() *[]*Decl {
 func (s *Struct) Declarations() *[]*Decl {
 	return &s.Decls
 }
+
+// IsDefinition reports whether d declares a definition,
+// i.e. either `Name :: value` or `#Name: value`.
+func (d *Decl) IsDefinition() bool {
+	if strings.HasPrefix(d.LabelName, "#") {
+		return true
+	}
+	if f, ok := d.Decl.(*ast.Field); ok {
+		return f.Token == token.ISA
+	}
+	return false
+}
+
+// IsEmbedding reports whether d is an expression embedded in a struct or file, rather than a field.
+func (d *Decl) IsEmbedding() bool {
+	_, ok := d.Decl.(*ast.EmbedDecl)
+	return ok
+}
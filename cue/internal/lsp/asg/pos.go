@@ -13,10 +13,45 @@ func BeforeEqual(a token.Pos, b token.Pos) bool {
 	if a.File() == nil || b.File() == nil {
 		return false
 	}
-	return (a.Offset() <= b.Offset() && a.File().Name() == b.File().Name())
+	return (offset(a) <= offset(b) && a.File().Name() == b.File().Name())
+}
+
+// offset returns the offset of a position in its file, or 0 for positions without a file.
+//
+// Unterminated nodes, like a struct missing its closing brace, end one past the end of the file.
+// token.Pos.Offset panics for such positions, so they are checked for first.
+func offset(p token.Pos) int {
+	f := p.File()
+	if !p.IsValid() || f == nil {
+		return 0
+	}
+
+	if p.WithRel(token.NoRelPos).Add(-1) == f.Pos(f.Size(), token.NoRelPos) {
+		return f.Size() + 1
+	}
+
+	return p.Offset()
 }
 
 // Convenience function for using BeforeEqual with PosRange
 func Contains(r PosRange, pos token.Pos) bool {
 	return BeforeEqual(r.Pos(), pos) && BeforeEqual(pos, r.End())
 }
+
+// SamePos reports whether a and b point to the same offset in the same file.
+// In contrast to comparing token.Pos directly, this also works for positions from different compilations of a file.
+func SamePos(a token.Pos, b token.Pos) bool {
+	if a.File() == nil || b.File() == nil {
+		return false
+	}
+	return offset(a) == offset(b) && a.Filename() == b.Filename()
+}
+
+// ClampToFile returns the position itself, or the end of its file if the position lies behind it, like the end of an unterminated node.
+func ClampToFile(p token.Pos) token.Pos {
+	if f := p.File(); f != nil && offset(p) > f.Size() {
+		return f.Pos(f.Size(), token.NoRelPos)
+	}
+
+	return p
+}
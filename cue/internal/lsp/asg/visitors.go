@@ -33,3 +33,116 @@ func ParentDecl(n Node) *Decl {
 	Walk(&p, n)
 	return p.decl
 }
+
+// TypeDecls returns the definitions d is unified with.
+// These are either part of a conjunction, e.g. `foo: Deployment & {...}`,
+// or embedded in the struct value of d, e.g. `foo: { Deployment }`.
+func TypeDecls(d *Decl) []*Decl {
+	ret := []*Decl{}
+	for _, val := range d.Values {
+		switch v := val.(type) {
+		case *Reference:
+			if ref, ok := v.Referenced.(*Decl); ok && ref.IsDefinition() {
+				ret = append(ret, ref)
+			}
+		case *Struct:
+			for _, embed := range v.Decls {
+				if embed.IsEmbedding() {
+					ret = append(ret, TypeDecls(embed)...)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// Visitor for Implementations function below.
+// Will go down the graph and collect every Decl unified with one of the labels of def.
+type implementations struct {
+	def  *Decl
+	impl []*Decl
+}
+
+func (v *implementations) Direction() VisitDirection {
+	return DownDirection
+}
+
+func (v *implementations) Node(node Node) (down bool, up bool) {
+	down = true
+	return
+}
+
+func (v *implementations) Reference(ref *Reference) (down bool, up bool) {
+	// Do not follow references, they would lead us outside of the graph we were asked to search.
+	return
+}
+
+func (v *implementations) Decl(d *Decl) (down bool, up bool) {
+	down = true
+	if d.IsEmbedding() {
+		return
+	}
+	for _, typ := range TypeDecls(d) {
+		if sameDecl(typ, v.def) {
+			v.impl = append(v.impl, d)
+			return
+		}
+	}
+	return
+}
+
+// sameDecl reports whether a and b are the same declaration, even if they come from different compilations.
+func sameDecl(a *Decl, b *Decl) bool {
+	if a == b {
+		return true
+	}
+	for _, la := range a.Labels {
+		for _, lb := range b.Labels {
+			if SamePos(la.Pos(), lb.Pos()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Implementations returns every Decl below start, that is unified with or embeds def.
+func Implementations(start Node, def *Decl) []*Decl {
+	v := implementations{
+		def: def,
+	}
+	Walk(&v, start)
+	return v.impl
+}
+
+// Visitor for References function below.
+// Will go down the graph and collect every Reference to def.
+type references struct {
+	def  *Decl
+	refs []*Reference
+}
+
+func (v *references) Direction() VisitDirection {
+	return DownDirection
+}
+
+func (v *references) Node(node Node) (down bool, up bool) {
+	down = true
+	return
+}
+
+func (v *references) Reference(ref *Reference) (down bool, up bool) {
+	if d, ok := ref.Referenced.(*Decl); ok && sameDecl(d, v.def) {
+		v.refs = append(v.refs, ref)
+	}
+	return
+}
+
+// References returns every Reference below start, that resolves to def.
+func References(start Node, def *Decl) []*Reference {
+	v := references{
+		def: def,
+	}
+	Walk(&v, start)
+	return v.refs
+}
@@ -0,0 +1,301 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/internal/lsp/attribute"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// attributeAt returns the attribute at the position of a location, or nil.
+func attributeAt(location *cache.Location) *ast.Attribute {
+	file := fileAt(location)
+	if file == nil {
+		return nil
+	}
+
+	var ret *ast.Attribute
+
+	ast.Walk(file, func(n ast.Node) bool {
+		if ret != nil {
+			return false
+		}
+
+		if a, ok := n.(*ast.Attribute); ok && a.Pos().Offset() <= location.Pos.Offset() && location.Pos.Offset() <= a.End().Offset() {
+			ret = a
+		}
+
+		return true
+	}, nil)
+
+	return ret
+}
+
+// attributeHover shows the documentation of an attribute with a known key.
+func (s *server) attributeHover(location *cache.Location, attr *ast.Attribute) (*protocol.Hover, error) {
+	key, _, _, ok := attribute.Split(attr.Text)
+	if !ok {
+		return nil, nil
+	}
+
+	spec := location.Doc.Attributes().Lookup(key)
+	if spec == nil {
+		return nil, nil
+	}
+
+	start, err := location.Doc.PosToProtocolPosition(attr.Pos())
+	if err != nil {
+		return nil, nil
+	}
+
+	end, err := location.Doc.PosToProtocolPosition(attr.End())
+	if err != nil {
+		return nil, nil
+	}
+
+	return &protocol.Hover{
+		Contents: protocol.MarkupContent{
+			Kind:  protocol.Markdown,
+			Value: spec.Markdown(),
+		},
+		Range: protocol.Range{Start: start, End: end},
+	}, nil
+}
+
+// attributeCompletion is the part of an attribute in front of the position of a completion.
+type attributeCompletion struct {
+	// keyStart is the offset of the key, behind the @.
+	keyStart int
+	key      string
+	// inBody is set if the position is behind the opening parenthesis.
+	inBody bool
+	// entries are the entries of the body up to the position. The last one is completed.
+	entries []attribute.Entry
+}
+
+// attributeBefore finds the attribute the position of a completion is in.
+//
+// It works on the text of the document, since attributes that are typed are usually not complete yet.
+func attributeBefore(content string, offset int) (*attributeCompletion, bool) {
+	if offset > len(content) {
+		return nil, false
+	}
+
+	line := content[:offset]
+	if i := strings.LastIndexByte(line, '\n'); i >= 0 {
+		line = line[i+1:]
+	}
+
+	lineStart := offset - len(line)
+
+	at := strings.LastIndexByte(line, '@')
+	if at < 0 || strings.ContainsRune(line[at:], ')') || strings.Contains(line[:at], "//") {
+		return nil, false
+	}
+
+	c := &attributeCompletion{keyStart: lineStart + at + 1}
+
+	rest := line[at+1:]
+
+	open := strings.IndexByte(rest, '(')
+	if open < 0 {
+		c.key = rest
+
+		return c, isAttributeKey(rest, true)
+	}
+
+	c.key, c.inBody = rest[:open], true
+	if !isAttributeKey(c.key, false) {
+		return nil, false
+	}
+
+	entries, err := attribute.Entries("@" + rest + ")")
+	if err != nil {
+		return nil, false
+	}
+
+	// The offsets of the entries are relative to the @.
+	for i := range entries {
+		entries[i].Start += lineStart + at
+		entries[i].End += lineStart + at
+	}
+
+	c.entries = entries
+
+	return c, true
+}
+
+// isAttributeKey reports whether s is an identifier, or a prefix of one if partial is set.
+func isAttributeKey(s string, partial bool) bool {
+	if s == "" {
+		return partial
+	}
+
+	return ast.IsValidIdent(s)
+}
+
+// completeAttribute offers the keys, arguments and options of known attributes.
+func (s *server) completeAttribute(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, content string, c *attributeCompletion) {
+	registry := location.Doc.Attributes()
+	offset := location.Pos.Offset()
+
+	if !c.inBody {
+		// Without an opening parenthesis, it is added by the snippet.
+		replace, err := offsetRange(location, c.keyStart, offset)
+		if err != nil {
+			return
+		}
+
+		for _, spec := range registry.Specs() {
+			spec := spec
+
+			item := protocol.CompletionItem{
+				Label:    "@" + spec.Key,
+				Kind:     protocol.KeywordCompletion,
+				TextEdit: &protocol.TextEdit{Range: replace, NewText: spec.Key},
+				// The @ is part of the label, but not of the replaced text.
+				FilterText: spec.Key,
+			}
+
+			*completions = append(*completions, lazyEdits(item, func(ctx context.Context, item *protocol.CompletionItem) {
+				item.Documentation = protocol.MarkupContent{Kind: protocol.Markdown, Value: spec.Markdown()}
+			}, func(ctx context.Context, item *protocol.CompletionItem) {
+				if !strings.HasPrefix(content[offset:], "(") {
+					item.TextEdit = &protocol.TextEdit{Range: replace, NewText: spec.Key + "($1)"}
+					item.InsertTextFormat = protocol.SnippetTextFormat
+				}
+			}))
+		}
+
+		return
+	}
+
+	spec := registry.Lookup(c.key)
+	if spec == nil || len(c.entries) == 0 {
+		return
+	}
+
+	current := c.entries[len(c.entries)-1]
+	index := len(c.entries) - 1
+
+	start := current.Start
+	for start < offset && content[start] == ' ' {
+		start++
+	}
+
+	// The value of an option.
+	if current.Key != "" {
+		o := spec.Option(current.Key)
+		if o == nil {
+			return
+		}
+
+		if eq := strings.IndexByte(content[start:offset], '='); eq >= 0 {
+			s.completeAttributeValues(completions, location, *o, start+eq+1, offset)
+		}
+
+		return
+	}
+
+	// Arguments come before all options.
+	args := true
+	for _, e := range c.entries[:index] {
+		args = args && e.Key == ""
+	}
+
+	if args && index < len(spec.Args) {
+		s.completeAttributeValues(completions, location, spec.Args[index], start, offset)
+
+		if spec.Args[index].Required {
+			return
+		}
+	}
+
+	replace, err := offsetRange(location, start, offset)
+	if err != nil {
+		return
+	}
+
+	used := map[string]bool{}
+	for _, e := range c.entries[:index] {
+		used[e.Key] = true
+		used[e.Value] = true
+	}
+
+	for _, o := range spec.Options {
+		if used[o.Name] {
+			continue
+		}
+
+		o := o
+
+		insert := o.Name
+		if !o.Flag {
+			insert += "="
+		}
+
+		item := protocol.CompletionItem{
+			Label:    insert,
+			Kind:     protocol.PropertyCompletion,
+			TextEdit: &protocol.TextEdit{Range: replace, NewText: insert},
+		}
+
+		*completions = append(*completions, lazy(item, func(ctx context.Context, item *protocol.CompletionItem) {
+			item.Documentation = protocol.MarkupContent{Kind: protocol.Markdown, Value: o.Doc}
+		}))
+	}
+}
+
+// completeAttributeValues offers the allowed values of an argument or option, replacing the text between two offsets.
+func (s *server) completeAttributeValues(completions *[]protocol.CompletionItem, location *cache.Location, p attribute.Param, start int, end int) {
+	if len(p.Values) == 0 {
+		return
+	}
+
+	replace, err := offsetRange(location, start, end)
+	if err != nil {
+		return
+	}
+
+	for _, v := range p.Values {
+		*completions = append(*completions, protocol.CompletionItem{
+			Label:    v,
+			Kind:     protocol.ValueCompletion,
+			Detail:   p.Name,
+			TextEdit: &protocol.TextEdit{Range: replace, NewText: v},
+		})
+	}
+}
+
+// offsetRange returns the range between two offsets of the document of a location.
+func offsetRange(location *cache.Location, start int, end int) (protocol.Range, error) {
+	offset := location.Pos.Offset()
+
+	startPos, err := location.Doc.PosToProtocolPosition(location.Pos.Add(start - offset))
+	if err != nil {
+		return protocol.Range{}, err
+	}
+
+	endPos, err := location.Doc.PosToProtocolPosition(location.Pos.Add(end - offset))
+	if err != nil {
+		return protocol.Range{}, err
+	}
+
+	return protocol.Range{Start: startPos, End: endPos}, nil
+}
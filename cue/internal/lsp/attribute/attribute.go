@@ -0,0 +1,290 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package attribute describes the attributes that tools give a meaning to, like @go(Name) or @tag(env),
+// so the language server can document, complete and validate them.
+//
+// CUE itself ignores attributes, so attributes with unknown keys are only checked for their syntax.
+// Tools that interpret their own attributes can add them to the Default registry with Register,
+// or users can declare them in the settings of the server.
+package attribute
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue/ast"
+)
+
+// Spec describes an attribute key, like go in @go(Name).
+type Spec struct {
+	// Key is the name of the attribute, without the @.
+	Key string
+	// Source is the tool or package that interprets the attribute, like encoding/gocode.
+	Source string
+	// Doc is the markdown documentation of the attribute.
+	Doc string
+	// Args are the positional arguments, which come before the options.
+	Args []Param
+	// Options are the entries following the arguments, either key=value pairs or flags.
+	Options []Param
+	// AnyOptions allows options that are not listed, e.g. because they are passed through to another format.
+	AnyOptions bool
+}
+
+// Param is an argument or an option of an attribute.
+type Param struct {
+	Name string
+	Doc  string
+	// Required is set for arguments that must not be empty.
+	Required bool
+	// Flag is set for options without a value, like func in @go(Name,func).
+	Flag bool
+	// Values are the allowed values. Any value is allowed if it is empty.
+	Values []string
+	// Check validates a value, in addition to Values. It may be nil.
+	Check func(value string) error
+}
+
+// Usage returns the form of an attribute, like @tag(<name>[,type=<type>][,short=<short>]).
+func (s *Spec) Usage() string {
+	var b strings.Builder
+
+	b.WriteString("@" + s.Key + "(")
+
+	for i, arg := range s.Args {
+		if i > 0 {
+			b.WriteString(",")
+		}
+
+		if arg.Required {
+			b.WriteString("<" + arg.Name + ">")
+		} else {
+			b.WriteString("[<" + arg.Name + ">]")
+		}
+	}
+
+	for i, o := range s.Options {
+		if i > 0 || len(s.Args) > 0 {
+			b.WriteString(",")
+		}
+
+		if o.Flag {
+			b.WriteString("[" + o.Name + "]")
+		} else {
+			b.WriteString("[" + o.Name + "=<" + o.Name + ">]")
+		}
+	}
+
+	b.WriteString(")")
+
+	return b.String()
+}
+
+// Markdown returns the documentation of an attribute, including its arguments and options.
+func (s *Spec) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "```cue\n%s\n```\n", s.Usage())
+
+	if s.Doc != "" {
+		b.WriteString(s.Doc + "\n")
+	}
+
+	if len(s.Args) > 0 || len(s.Options) > 0 {
+		b.WriteString("\n")
+	}
+
+	for _, params := range [][]Param{s.Args, s.Options} {
+		for _, p := range params {
+			fmt.Fprintf(&b, "* `%s`", p.Name)
+
+			if p.Doc != "" {
+				b.WriteString(": " + p.Doc)
+			}
+
+			if len(p.Values) > 0 {
+				fmt.Fprintf(&b, " One of `%s`.", strings.Join(p.Values, "`, `"))
+			}
+
+			b.WriteString("\n")
+		}
+	}
+
+	if s.Source != "" {
+		fmt.Fprintf(&b, "\nInterpreted by `%s`.\n", s.Source)
+	}
+
+	return b.String()
+}
+
+// Option returns the option with the given name, or nil.
+func (s *Spec) Option(name string) *Param {
+	for i := range s.Options {
+		if s.Options[i].Name == name {
+			return &s.Options[i]
+		}
+	}
+
+	return nil
+}
+
+// Registry holds the specs of the known attributes. It is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	specs map[string]*Spec
+}
+
+// NewRegistry returns a registry of the given specs.
+func NewRegistry(specs ...*Spec) *Registry {
+	r := &Registry{specs: make(map[string]*Spec)}
+
+	for _, s := range specs {
+		r.Register(s)
+	}
+
+	return r
+}
+
+// Register adds a spec to the registry, replacing the spec of the same key.
+func (r *Registry) Register(spec *Spec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.specs[spec.Key] = spec
+}
+
+// Lookup returns the spec of a key, or nil if it is unknown.
+func (r *Registry) Lookup(key string) *Spec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.specs[key]
+}
+
+// Specs returns the specs of the registry, sorted by their key.
+func (r *Registry) Specs() []*Spec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ret := make([]*Spec, 0, len(r.specs))
+	for _, s := range r.specs {
+		ret = append(ret, s)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+
+	return ret
+}
+
+// Extend returns a new registry with the specs of r and the given specs, which take precedence.
+func (r *Registry) Extend(specs ...*Spec) *Registry {
+	return NewRegistry(append(r.Specs(), specs...)...)
+}
+
+// Default is the registry of the attributes known to the tools of the cue repository.
+var Default = NewRegistry(builtinSpecs()...)
+
+// Register adds a spec to the Default registry.
+func Register(spec *Spec) {
+	Default.Register(spec)
+}
+
+func builtinSpecs() []*Spec {
+	return []*Spec{
+		{
+			Key:    "go",
+			Source: "encoding/gocode",
+			Doc:    "Controls the Go code generated for a top-level declaration. Declarations without it are only generated if they are exported.",
+			Args: []Param{
+				{Name: "name", Doc: "The Go name of the declaration, or `-` to skip it."},
+			},
+			Options: []Param{
+				{Name: "type", Doc: "The Go type as which the value is interpreted."},
+				{Name: "validate", Doc: "Alternative name for the validation function or method. Empty disables it."},
+				{Name: "complete", Doc: "Alternative name for the completion function or method. Empty disables it."},
+				{Name: "func", Doc: "Generate a function instead of a method.", Flag: true},
+			},
+		},
+		{
+			Key:    "protobuf",
+			Source: "encoding/protobuf",
+			Doc:    "Records the protobuf field a CUE field was converted from, or is converted to.",
+			Args: []Param{
+				{Name: "sequence", Doc: "The field number.", Required: true, Check: checkInt},
+			},
+			Options: []Param{
+				{Name: "type", Doc: "The protobuf type, if it differs from the CUE type."},
+				{Name: "name", Doc: "The protobuf name, if it differs from the CUE name."},
+				{Name: "deprecated", Doc: "The field is deprecated.", Flag: true},
+			},
+			// Other protobuf options are passed through.
+			AnyOptions: true,
+		},
+		{
+			Key:    "openapi",
+			Source: "encoding/openapi",
+			Doc:    "Controls the OpenAPI schema generated for a field.",
+			Options: []Param{
+				{Name: "readOnly", Doc: "Sets the readOnly flag of the property. Only one of readOnly and writeOnly may be set.", Flag: true},
+				{Name: "writeOnly", Doc: "Sets the writeOnly flag of the property. Only one of readOnly and writeOnly may be set.", Flag: true},
+				{Name: "discriminator", Doc: "Sets the field as the discriminator field.", Flag: true},
+			},
+			// The first entry may rename the field, like - or info.
+			AnyOptions: true,
+		},
+		{
+			Key:    "tag",
+			Source: "cmd/cue",
+			Doc:    "Sets the value of a field from the command line, with `-t name=value` or a shorthand like `-t value`.",
+			Args: []Param{
+				{Name: "name", Doc: "The name of the tag.", Required: true, Check: checkIdent},
+			},
+			Options: []Param{
+				{Name: "type", Doc: "The type the value is parsed as, string by default.", Values: []string{"string", "int", "number", "bool"}},
+				{Name: "short", Doc: "Shorthands for values of the tag, separated by `|`.", Check: checkShorthands},
+			},
+		},
+	}
+}
+
+func checkInt(value string) error {
+	if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+		return fmt.Errorf("%q is not an integer", value)
+	}
+
+	return nil
+}
+
+func checkIdent(value string) error {
+	if !ast.IsValidIdent(value) {
+		return fmt.Errorf("%q is not a valid identifier", value)
+	}
+
+	return nil
+}
+
+func checkShorthands(value string) error {
+	for _, s := range strings.Split(value, "|") {
+		if err := checkIdent(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEntries(t *testing.T) {
+	entries, err := Entries(`@tag(env, short="a,b",type=int)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Entry{
+		{Start: 5, End: 8, Value: "env"},
+		{Start: 9, End: 21, Key: "short", Value: "a,b"},
+		{Start: 22, End: 30, Key: "type", Value: "int"},
+	}
+
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+
+	if _, err := Entries(`@go("unterminated)`); err == nil {
+		t.Errorf("expected an error for a malformed attribute")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{`@go(Name,type=int,func)`, nil},
+		{`@go(-)`, nil},
+		{`@go(Name,unknown)`, []string{`warning 9-16: unknown option "unknown" of @go attribute`}},
+		{`@go(Name,func=true)`, []string{`error 9-18: option func of @go attribute does not take a value`}},
+		{`@go(Name,type)`, []string{`error 9-13: option type of @go attribute needs a value, like type=<type>`}},
+		{`@protobuf(1,name=foo,(gogoproto.nullable)=false)`, nil},
+		{`@protobuf(one,name=foo)`, []string{`error 10-13: invalid sequence: "one" is not an integer`}},
+		{`@protobuf(name=foo)`, []string{`error 0-19: missing sequence of @protobuf attribute`}},
+		{`@tag(env,type=int,short=dev|prod)`, nil},
+		{`@tag(env,type=float)`, []string{`error 9-19: invalid type "float", expected one of string, int, number, bool`}},
+		{`@tag(env,short=dev|1)`, []string{`error 9-20: invalid short: "1" is not a valid identifier`}},
+		{`@tag()`, []string{`error 0-6: missing name of @tag attribute`}},
+		{`@openapi(readOnly)`, nil},
+		{`@unknown(anything, goes=here)`, nil},
+		{`@unknown("unterminated)`, []string{`error 0-23: malformed @unknown attribute: attribute string not terminated`}},
+	}
+
+	for _, test := range tests {
+		var got []string
+
+		for _, p := range Default.Check(test.text) {
+			severity := "error"
+			if p.Warning {
+				severity = "warning"
+			}
+
+			got = append(got, fmt.Sprintf("%s %d-%d: %s", severity, p.Start, p.End, p.Message))
+		}
+
+		if len(got) != len(test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.text, test.expected, got)
+			continue
+		}
+
+		for i := range got {
+			if !strings.HasPrefix(got[i], test.expected[i]) {
+				t.Errorf("%s: expected %q, got %q", test.text, test.expected[i], got[i])
+			}
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	custom := &Spec{
+		Key:     "team",
+		Doc:     "Our own attribute.",
+		Args:    []Param{{Name: "owner", Required: true}},
+		Options: []Param{{Name: "oncall", Flag: true}},
+	}
+
+	r := Default.Extend(custom)
+
+	if r.Lookup("team") != custom || Default.Lookup("team") != nil {
+		t.Errorf("expected Extend to leave the Default registry unchanged")
+	}
+
+	if r.Lookup("go") == nil {
+		t.Errorf("expected Extend to keep the existing specs")
+	}
+
+	if problems := r.Check("@team(alice,pager)"); len(problems) != 1 || !problems[0].Warning {
+		t.Errorf("expected a warning for an unknown option, got %+v", problems)
+	}
+
+	if got, expected := custom.Usage(), "@team(<owner>,[oncall])"; got != expected {
+		t.Errorf("expected usage %s, got %s", expected, got)
+	}
+
+	if md := custom.Markdown(); !strings.Contains(md, "Our own attribute.") || !strings.Contains(md, "* `oncall`") {
+		t.Errorf("unexpected documentation %q", md)
+	}
+}
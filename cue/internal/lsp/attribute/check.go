@@ -0,0 +1,219 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute
+
+import (
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue/token"
+	"cuelang.org/go/internal"
+)
+
+// Entry is an argument or option of an attribute.
+type Entry struct {
+	// Start and End are the byte offsets of the entry in the text of the attribute.
+	Start, End int
+	// Key is the key of a key=value option. It is empty for arguments and flags.
+	Key   string
+	Value string
+}
+
+// Problem is an issue of an attribute.
+type Problem struct {
+	// Start and End are the byte offsets of the problem in the text of the attribute.
+	Start, End int
+	Message    string
+	// Warning is set for problems tools ignore, like unknown options.
+	Warning bool
+}
+
+// Split splits the text of an attribute, like @go(Name), into its key and body.
+//
+// offset is the byte offset of the body in the text. ok is false if the text is not an attribute.
+func Split(text string) (key string, body string, offset int, ok bool) {
+	open := strings.IndexByte(text, '(')
+	if !strings.HasPrefix(text, "@") || open < 0 || !strings.HasSuffix(text, ")") {
+		return "", "", 0, false
+	}
+
+	return text[1:open], text[open+1 : len(text)-1], open + 1, true
+}
+
+// Entries returns the entries of an attribute, or an error if its body is malformed.
+func Entries(text string) ([]Entry, error) {
+	_, body, offset, ok := Split(text)
+	if !ok {
+		return nil, fmt.Errorf("invalid attribute %q", text)
+	}
+
+	attr := internal.ParseAttrBody(token.NoPos, body)
+	if attr.Err != nil {
+		return nil, attr.Err
+	}
+
+	spans := entrySpans(body)
+	if len(spans) != len(attr.Fields) {
+		return nil, fmt.Errorf("invalid attribute %q", text)
+	}
+
+	entries := make([]Entry, 0, len(spans))
+
+	for i, kv := range attr.Fields {
+		e := Entry{
+			Start: offset + spans[i][0],
+			End:   offset + spans[i][1],
+			Key:   strings.TrimSpace(kv.Key()),
+			Value: strings.TrimSpace(kv.Text()),
+		}
+
+		if e.Key != "" {
+			e.Value = kv.Value()
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// entrySpans returns the start and end of the comma separated entries of an attribute body.
+//
+// Commas in quoted strings do not separate entries.
+func entrySpans(body string) [][2]int {
+	var ret [][2]int
+
+	start := 0
+
+	var quote byte
+
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			ret = append(ret, [2]int{start, i})
+			start = i + 1
+		}
+	}
+
+	return append(ret, [2]int{start, len(body)})
+}
+
+// Check validates an attribute, given by its text like @go(Name), against the spec of its key.
+//
+// Attributes with unknown keys are only checked for their syntax.
+func (r *Registry) Check(text string) []Problem {
+	key, _, _, ok := Split(text)
+	if !ok {
+		return []Problem{{Start: 0, End: len(text), Message: "malformed attribute"}}
+	}
+
+	entries, err := Entries(text)
+	if err != nil {
+		return []Problem{{Start: 0, End: len(text), Message: fmt.Sprintf("malformed @%s attribute: %v", key, err)}}
+	}
+
+	spec := r.Lookup(key)
+	if spec == nil {
+		return nil
+	}
+
+	var problems []Problem
+
+	// The arguments end with the first key=value option.
+	args := 0
+	for args < len(entries) && args < len(spec.Args) && entries[args].Key == "" {
+		args++
+	}
+
+	for i, arg := range spec.Args {
+		if i >= args || entries[i].Value == "" {
+			if arg.Required {
+				problems = append(problems, Problem{
+					Start:   0,
+					End:     len(text),
+					Message: fmt.Sprintf("missing %s of @%s attribute", arg.Name, key),
+				})
+			}
+
+			continue
+		}
+
+		if msg := checkValue(arg, entries[i].Value); msg != "" {
+			problems = append(problems, Problem{Start: entries[i].Start, End: entries[i].End, Message: msg})
+		}
+	}
+
+	for _, e := range entries[args:] {
+		name := e.Key
+		if name == "" {
+			name = e.Value
+		}
+
+		o := spec.Option(name)
+
+		switch {
+		case name == "":
+		case o == nil:
+			if !spec.AnyOptions {
+				problems = append(problems, Problem{
+					Start:   e.Start,
+					End:     e.End,
+					Message: fmt.Sprintf("unknown option %q of @%s attribute", name, key),
+					Warning: true,
+				})
+			}
+		case o.Flag && e.Key != "":
+			problems = append(problems, Problem{Start: e.Start, End: e.End, Message: fmt.Sprintf("option %s of @%s attribute does not take a value", name, key)})
+		case !o.Flag && e.Key == "":
+			problems = append(problems, Problem{Start: e.Start, End: e.End, Message: fmt.Sprintf("option %s of @%s attribute needs a value, like %s=<%s>", name, key, name, name)})
+		case !o.Flag:
+			if msg := checkValue(*o, e.Value); msg != "" {
+				problems = append(problems, Problem{Start: e.Start, End: e.End, Message: msg})
+			}
+		}
+	}
+
+	return problems
+}
+
+// checkValue returns a message if a value is not allowed for a parameter.
+func checkValue(p Param, value string) string {
+	if len(p.Values) > 0 {
+		found := false
+
+		for _, v := range p.Values {
+			found = found || v == value
+		}
+
+		if !found {
+			return fmt.Sprintf("invalid %s %q, expected one of %s", p.Name, value, strings.Join(p.Values, ", "))
+		}
+	}
+
+	if p.Check != nil {
+		if err := p.Check(value); err != nil {
+			return fmt.Sprintf("invalid %s: %v", p.Name, err)
+		}
+	}
+
+	return ""
+}
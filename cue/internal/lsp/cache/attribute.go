@@ -0,0 +1,74 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/attribute"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// Attributes returns the registry of the attributes known in the documents of the cache.
+func (d *DocumentHandle) Attributes() *attribute.Registry {
+	if r := d.doc.cache.getOptions().Attributes; r != nil {
+		return r
+	}
+
+	return attribute.Default
+}
+
+// addAttributeDiagnostics reports malformed attributes of the document and attributes
+// that do not match the spec of their key.
+func (d *DocumentHandle) addAttributeDiagnostics(pkg *asg.Package) error {
+	registry := d.Attributes()
+
+	for _, f := range pkg.Files {
+		if f.File.Filename != d.doc.path {
+			continue
+		}
+
+		var attrs []*ast.Attribute
+
+		ast.Walk(f.File, func(n ast.Node) bool {
+			if a, ok := n.(*ast.Attribute); ok {
+				attrs = append(attrs, a)
+			}
+
+			return true
+		}, nil)
+
+		for _, a := range attrs {
+			for _, p := range registry.Check(a.Text) {
+				start, end := a.Pos().Add(p.Start), a.Pos().Add(p.End)
+
+				diagnostic, err := d.cueErrToProtocolDiagnostic(errors.Newf(start, "%s", p.Message), start, end)
+				if err != nil {
+					return err
+				}
+
+				if p.Warning {
+					diagnostic.Severity = protocol.SeverityWarning
+				}
+
+				if err := d.addDiagnostic(diagnostic, d.doc.uri); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
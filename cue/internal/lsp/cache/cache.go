@@ -15,15 +15,19 @@ package cache
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"net/url"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"cuelang.org/go/cue/errors"
-	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/attribute"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/lint"
 	"cuelang.org/go/cue/load"
 	"cuelang.org/go/cue/token"
 )
@@ -45,15 +49,25 @@ func PathFromURI(uri protocol.DocumentURI) string {
 	return ""
 }
 
+// URIFromPath converts an absolute path into a file DocumentURI.
+func URIFromPath(path string) protocol.DocumentURI {
+	return protocol.DocumentURI(fmt.Sprintf("file://%s", path))
+}
+
 // DocumentCache caches the documents and compile Results associated with one server-client connection or one REST API instance.
 //
 // Before a cache instance can be used, Init must be called.
 type DocumentCache struct {
 	rootURI   protocol.DocumentURI
 	documents map[protocol.DocumentURI]*document
+	options   Options
 	mu        sync.RWMutex
 	// Channel to send log messages to.
 	Logging chan protocol.LogMessageParams
+	// Progress reports long running operations like loading packages, if set.
+	Progress ProgressFunc
+	// Scheduler runs the compiles of the documents. Init creates one if it is not set.
+	Scheduler *Scheduler
 }
 
 // Returns the root path as an absolute path
@@ -80,11 +94,40 @@ func (c *DocumentCache) absPath(uri protocol.DocumentURI) string {
 	return ""
 }
 
+// Options configure how the packages of a cache are loaded and checked.
+type Options struct {
+	// BuildTags are passed to load.Config.
+	BuildTags []string
+	// ModuleRoot overrides the module root, which is otherwise found by looking for a cue.mod directory.
+	// Relative paths are resolved relative to the root folder.
+	ModuleRoot string
+	// EvalDiagnostics enables diagnostics reported by the evaluator, in addition to the ones of the asg.
+	EvalDiagnostics bool
+	// CompileDelay is how long a package must stay unchanged before its documents are compiled.
+	CompileDelay time.Duration
+	// Schemas select the schemas JSON and YAML documents are validated against.
+	Schemas []SchemaMapping
+	// Attributes are the attributes that are documented, completed and validated.
+	// The attributes of attribute.Default are used if it is nil.
+	Attributes *attribute.Registry
+	// Lint changes the severity of lint rules.
+	Lint lint.Config
+}
+
 // Init initializes a Document cache.
 func (c *DocumentCache) Init() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.documents = make(map[protocol.DocumentURI]*document)
+
+	if c.Scheduler == nil {
+		c.Scheduler = NewScheduler()
+	}
+}
+
+// RootURI returns the URI of the root folder of the cache.
+func (c *DocumentCache) RootURI() protocol.DocumentURI {
+	return c.rootURI
 }
 
 // Loads any cue packages / modules found inside the root URI passed in.
@@ -93,18 +136,27 @@ func (c *DocumentCache) LoadRootFolder(uri protocol.DocumentURI) {
 	c.rootURI = uri
 }
 
-// Reloads all packages / modules inside the root folder
-func (c *DocumentCache) CreateCompiler() (*asg.Compiler, error) {
-	overlay, err := c.overlay()
-	if err != nil {
-		return nil, err
-	}
-	config := load.Config{
-		Dir:     c.root(),
-		Overlay: overlay,
+func (c *DocumentCache) getOptions() Options {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.options
+}
+
+// SetOptions changes the options of the cache and recompiles all documents with them.
+//
+// The returned handles belong to the new versions of the documents.
+func (c *DocumentCache) SetOptions(serverLifetime context.Context, options Options) []*DocumentHandle {
+	c.mu.Lock()
+	c.options = options
+	c.mu.Unlock()
+
+	docs := c.GetDocuments()
+	for i, d := range docs {
+		docs[i] = d.recompile(serverLifetime)
 	}
 
-	return asg.NewCompiler(&config), nil
+	return docs
 }
 
 func (c *DocumentCache) overlay() (map[string]load.Source, error) {
@@ -186,6 +238,37 @@ func (c *DocumentCache) GetDocument(uri protocol.DocumentURI) (*DocumentHandle,
 	return &DocumentHandle{ret, ret.versionCtx}, nil
 }
 
+// GetDocuments returns all documents currently in the cache.
+func (c *DocumentCache) GetDocuments() []*DocumentHandle {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := make([]*DocumentHandle, 0, len(c.documents))
+	for _, d := range c.documents {
+		d.mu.RLock()
+		ret = append(ret, &DocumentHandle{d, d.versionCtx})
+		d.mu.RUnlock()
+	}
+
+	return ret
+}
+
+// ReadFile returns the content of the file at the given path.
+//
+// If the file is open, the content of the document is returned, otherwise it is read from disk.
+func (c *DocumentCache) ReadFile(path string) (string, error) {
+	if d, err := c.GetDocument(URIFromPath(path)); err == nil {
+		return d.GetContent()
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
 // RemoveDocument removes a document from the cache.
 func (c *DocumentCache) RemoveDocument(uri protocol.DocumentURI) error {
 	d, err := c.GetDocument(uri)
@@ -197,6 +280,7 @@ func (c *DocumentCache) RemoveDocument(uri protocol.DocumentURI) error {
 	defer c.mu.Unlock()
 
 	d.doc.obsoleteVersion()
+	c.Scheduler.cancel(d.doc)
 
 	delete(c.documents, uri)
 
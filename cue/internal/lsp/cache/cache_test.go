@@ -15,7 +15,6 @@ package cache
 
 import (
 	"context"
-	"fmt"
 	"testing"
 
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
@@ -35,7 +34,7 @@ func TestCache(t *testing.T) { // nolint:funlen
 			Text:       "test_text",
 		})
 	if err != nil {
-		panic("Failed to AddDocument() to cache")
+		t.Fatal("Failed to AddDocument() to cache")
 	}
 
 	_, err = c.AddDocument(
@@ -48,26 +47,26 @@ func TestCache(t *testing.T) { // nolint:funlen
 			Text:       "test_text",
 		})
 	if err == nil {
-		panic("Should not be able to add same document twice")
+		t.Fatal("Should not be able to add same document twice")
 	}
 
 	doc1, err := c.GetDocument("test_file")
 	if err != nil {
-		panic("Failed to GetDocument() from cache")
+		t.Fatal("Failed to GetDocument() from cache")
 	}
 
 	if doc1.doc != doc.doc {
-		panic("Cache returned wrong document")
+		t.Fatal("Cache returned wrong document")
 	}
 
 	err = c.RemoveDocument("test_file")
 	if err != nil {
-		panic("Failed to RemoveDocument() from cache")
+		t.Fatal("Failed to RemoveDocument() from cache")
 	}
 
 	err = c.RemoveDocument("test_file")
 	if err == nil {
-		panic("should have failed to RemoveDocument() twice")
+		t.Fatal("should have failed to RemoveDocument() twice")
 	}
 
 	_, err = c.AddDocument(
@@ -80,7 +79,7 @@ func TestCache(t *testing.T) { // nolint:funlen
 			Text:       "test_text:",
 		})
 	if err != nil {
-		panic("Should be able to readd document after removing it")
+		t.Fatal("Should be able to readd document after removing it")
 	}
 
 	wrongYaml := "asdf["
@@ -95,7 +94,7 @@ func TestCache(t *testing.T) { // nolint:funlen
 			Text:       wrongYaml,
 		})
 	if err != nil {
-		panic("Should be able to handle yaml with syntax errors")
+		t.Fatal("Should be able to handle yaml with syntax errors")
 	}
 
 	rulesFile := `
@@ -130,22 +129,22 @@ groups:
 			Text:       rulesFile,
 		})
 	if err != nil {
-		panic("adding rules file failed")
+		t.Fatal("adding rules file failed")
 	}
 
 	doc, err = c.GetDocument("rules_file")
 	if err != nil {
-		panic("failed to get rules file")
+		t.Fatal("failed to get rules file")
 	}
 
 	diagnostics, err := doc.GetDiagnostics()
 	if err != nil {
-		panic("failed to get diagnostics for rules file")
+		t.Fatal("failed to get diagnostics for rules file")
 	}
 
-	if len(diagnostics) != 2 {
-		fmt.Println(diagnostics)
-		panic("expected exactly 2 error messages for rules file got " + fmt.Sprint(len(diagnostics)))
+	// Valid YAML is only checked further when a schema applies to it.
+	if len(diagnostics) != 0 {
+		t.Fatalf("expected no diagnostics for rules file, got %v", diagnostics)
 	}
 
 	// queries, err := doc.GetQueries()
@@ -168,7 +167,8 @@ groups:
 	// 	panic("failed to get query: " + err.Error())
 	// }
 
-	_, err = c.Find(&protocol.TextDocumentPositionParams{
+	// YAML documents are not compiled to CUE, so there is nothing to find.
+	_, err = c.Find(context.Background(), &protocol.TextDocumentPositionParams{
 		TextDocument: protocol.TextDocumentIdentifier{
 			URI: "rules_file",
 		},
@@ -177,11 +177,11 @@ groups:
 			Character: 25,
 		},
 	})
-	if err != nil {
-		panic("failed to find query: " + err.Error())
+	if err == nil {
+		t.Fatal("should have failed to find a node in a YAML document")
 	}
 
-	_, err = c.Find(&protocol.TextDocumentPositionParams{
+	_, err = c.Find(context.Background(), &protocol.TextDocumentPositionParams{
 		TextDocument: protocol.TextDocumentIdentifier{
 			URI: "rules_file",
 		},
@@ -191,10 +191,10 @@ groups:
 		},
 	})
 	if err == nil {
-		panic("should have failed to find query")
+		t.Fatal("should have failed to find query")
 	}
 
-	_, err = c.Find(&protocol.TextDocumentPositionParams{
+	_, err = c.Find(context.Background(), &protocol.TextDocumentPositionParams{
 		TextDocument: protocol.TextDocumentIdentifier{
 			URI: "rules_file_nonexistent",
 		},
@@ -204,7 +204,7 @@ groups:
 		},
 	})
 	if err == nil {
-		panic("should have failed to find query")
+		t.Fatal("should have failed to find query")
 	}
 
 	expectedNewContent := `
@@ -244,25 +244,25 @@ groups:
 	}, 2)
 
 	if err != nil {
-		panic("Failed to apply incremental changes: " + err.Error())
+		t.Fatal("Failed to apply incremental changes: " + err.Error())
 	}
 
 	if newContent != expectedNewContent {
-		panic("incremental update did not result in expected content")
+		t.Fatal("incremental update did not result in expected content")
 	}
 
 	_, err = doc.ApplyIncrementalChanges(nil, -1)
 	if err == nil {
-		panic("File update without version update should have failed")
+		t.Fatal("File update without version update should have failed")
 	}
 
 	err = doc.SetContent(context.Background(), "foo", 2, false)
 	if err != nil {
-		panic("file update failed")
+		t.Fatal("file update failed")
 	}
 
 	err = doc.SetContent(context.Background(), "foo", 2, false)
 	if err == nil {
-		panic("File update without version update should have failed")
+		t.Fatal("File update without version update should have failed")
 	}
 }
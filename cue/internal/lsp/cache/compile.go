@@ -14,14 +14,18 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"cuelang.org/go/cue/ast"
 	"cuelang.org/go/cue/build"
 	"cuelang.org/go/cue/errors"
 	"cuelang.org/go/cue/internal/lsp/asg"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/event"
+	"cuelang.org/go/cue/internal/lsp/metrics"
 	"cuelang.org/go/cue/parser"
 	"cuelang.org/go/cue/token"
 )
@@ -34,10 +38,18 @@ func (d *DocumentHandle) compile() error {
 	case "cue":
 		d.doc.compilers.Add(1)
 		err := d.compileCue(true, token.NoPos, token.NoPos, "")
-		if err != nil {
+		// Expired and cancelled compiles are not worth a log message.
+		if err != nil && err != context.Canceled {
 			d.Log(protocol.Error, "had error while trying to compile doc: %v", err)
 		}
 		return err
+	case "json", "yaml":
+		d.doc.compilers.Add(1)
+		err := d.compileData(dataEncodings[d.GetLanguageID()])
+		if err != nil && err != context.Canceled {
+			d.Log(protocol.Error, "had error while trying to validate doc: %v", err)
+		}
+		return err
 	default:
 	}
 
@@ -69,16 +81,24 @@ func (d *DocumentHandle) compileCue(fullFile bool, pos token.Pos, endPos token.P
 		return expired
 	}
 
-	compiler, err := d.doc.cache.CreateCompiler()
+	compiler, err := d.createCompiler()
 	if err != nil {
 		return err
 	}
 
-	relative, err := filepath.Rel(d.doc.cache.root(), filepath.Dir(d.doc.path))
+	// Loading a package with a large import graph can take a while, so the user can follow and cancel it.
+	// Loading itself cannot be interrupted, but nothing is done after it once the context expired.
+	ctx, progress := d.doc.cache.startProgress(d.ctx, fmt.Sprintf("Compiling %s", filepath.Base(d.doc.path)), true)
+	defer progress.End("")
+
+	progress.Report("Loading package", 0)
 
-	pkg, err := compiler.CompileFile("./" + relative)
+	defer recordCompile(ctx, filepath.Dir(d.doc.path), time.Now())
 
-	d.Log(protocol.Info, "Package: %v", pkg)
+	pkg, err := compiler.CompileFile(d.packagePath())
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
 	parseErr := errors.Errors(err)
 
@@ -116,7 +136,7 @@ func (d *DocumentHandle) compileCue(fullFile bool, pos token.Pos, endPos token.P
 		} else {
 			n := pkg.Find(start)
 			if n != nil {
-				end = n.End()
+				end = asg.ClampToFile(n.End())
 			}
 		}
 
@@ -125,8 +145,7 @@ func (d *DocumentHandle) compileCue(fullFile bool, pos token.Pos, endPos token.P
 			return err
 		}
 
-		path := start.Filename()
-		uri := protocol.DocumentURI(fmt.Sprintf("file://%s", path))
+		uri := URIFromPath(start.Filename())
 
 		err = d.addDiagnostic(diagnostic, uri)
 		if err != nil {
@@ -134,6 +153,84 @@ func (d *DocumentHandle) compileCue(fullFile bool, pos token.Pos, endPos token.P
 		}
 	}
 
+	if err := d.addDeprecationDiagnostics(content); err != nil {
+		return err
+	}
+
+	if pkg != nil {
+		if err := d.addAttributeDiagnostics(pkg); err != nil {
+			return err
+		}
+	}
+
+	// Lint rules would mostly report the consequences of errors.
+	if len(parseErr) == 0 && pkg != nil {
+		progress.Report("Linting", 0)
+
+		if err := d.addLintDiagnostics(ctx, pkg); err != nil {
+			return err
+		}
+	}
+
+	if len(parseErr) == 0 && pkg != nil && IsToolFile(d.doc.path) {
+		progress.Report("Checking commands", 0)
+
+		if err := d.addToolDiagnostics(ctx, pkg); err != nil {
+			return err
+		}
+	}
+
+	// Evaluating a package with errors would mostly repeat them.
+	if len(parseErr) == 0 && d.doc.cache.getOptions().EvalDiagnostics {
+		progress.Report("Evaluating", 0)
+		return d.addEvalDiagnostics(ctx, pkg)
+	}
+
+	return nil
+}
+
+// recordCompile records the duration of a compile that started at the given time.
+func recordCompile(ctx context.Context, dir string, start time.Time) {
+	duration := float64(time.Since(start)) / float64(time.Millisecond)
+	event.Record2(ctx, metrics.Package.Of(dir), metrics.CompileTime.Of(duration))
+}
+
+// addEvalDiagnostics adds the errors the evaluator reports for the package of the document.
+//
+// If the context expires, no diagnostics are added.
+func (d *DocumentHandle) addEvalDiagnostics(ctx context.Context, pkg *asg.Package) error {
+	inst, err := d.BuildInstance()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err == nil {
+		err = inst.Value().Validate()
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	for _, e := range errors.Errors(err) {
+		// Conflicts are reported at all values that contributed to them.
+		for _, start := range errors.Positions(e) {
+			end := start
+			if n := pkg.Find(start); n != nil {
+				end = asg.ClampToFile(n.End())
+			}
+
+			diagnostic, err := d.cueErrToProtocolDiagnostic(e, start, end)
+			if err != nil {
+				return err
+			}
+
+			if err := d.addDiagnostic(diagnostic, URIFromPath(start.Filename())); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
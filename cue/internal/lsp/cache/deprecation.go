@@ -0,0 +1,109 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+	"golang.org/x/xerrors"
+)
+
+// Deprecation is a construct of a file in an old syntax, which cue fmt rewrites.
+type Deprecation struct {
+	Start, End token.Pos
+	Err        errors.Error
+}
+
+// Deprecations returns the deprecated constructs of a CUE file.
+//
+// The parser only reports them when it is asked for the latest version of the language.
+func Deprecations(filename string, content string) []Deprecation {
+	file, err := parser.ParseFile(filename, content, parser.FromVersion(parser.Latest), parser.ParseComments, parser.AllErrors)
+
+	var ret []Deprecation
+
+	for _, e := range errors.Errors(err) {
+		var d *parser.DeprecationError
+		if !xerrors.As(e, &d) {
+			continue
+		}
+
+		start, end := deprecationRange(file, content, e.Position())
+
+		ret = append(ret, Deprecation{Start: start, End: end, Err: e})
+	}
+
+	return ret
+}
+
+// deprecationRange returns the range of the deprecated construct the parser reported at a position.
+//
+// Old-style aliases are only recognized once the parser is past them, so they are the alias right
+// in front of the position. Other constructs are the smallest node starting at the position.
+func deprecationRange(file *ast.File, content string, pos token.Pos) (start, end token.Pos) {
+	start, end = pos, pos
+
+	if file == nil {
+		return start, end
+	}
+
+	var alias *ast.Alias
+
+	ast.Walk(file, func(n ast.Node) bool {
+		if n.Pos().Filename() != pos.Filename() {
+			return true
+		}
+
+		if n.Pos().Offset() == pos.Offset() {
+			start, end = n.Pos(), n.End()
+		}
+
+		if a, ok := n.(*ast.Alias); ok && a.End().Offset() <= pos.Offset() && pos.Offset() <= len(content) &&
+			strings.Trim(content[a.End().Offset():pos.Offset()], " \t\r\n,") == "" {
+			alias = a
+		}
+
+		return true
+	}, nil)
+
+	if alias != nil {
+		return alias.Pos(), alias.End()
+	}
+
+	return start, end
+}
+
+// addDeprecationDiagnostics reports the deprecated constructs of the document as warnings.
+func (d *DocumentHandle) addDeprecationDiagnostics(content string) error {
+	for _, dep := range Deprecations(d.doc.path, content) {
+		diagnostic, err := d.cueErrToProtocolDiagnostic(dep.Err, dep.Start, dep.End)
+		if err != nil {
+			return err
+		}
+
+		diagnostic.Severity = protocol.SeverityWarning
+		diagnostic.Tags = []protocol.DiagnosticTag{protocol.Deprecated}
+
+		if err := d.addDiagnostic(diagnostic, d.doc.uri); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
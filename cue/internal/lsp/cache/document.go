@@ -29,6 +29,8 @@ import (
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/span"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/event"
+	"cuelang.org/go/cue/internal/lsp/metrics"
 )
 
 // document caches content, metadata and compile results of a document.
@@ -148,24 +150,51 @@ func (d *DocumentHandle) SetContent(serverLifetime context.Context, content stri
 		d.doc.obsoleteVersion()
 	}
 
-	d.doc.versionCtx, d.doc.obsoleteVersion = context.WithCancel(serverLifetime)
-
 	d.doc.content = content
 	d.doc.version = version
 
 	// An additional newline is appended, to make sure the last line is indexed
 	d.doc.posData.SetLinesForContent(append([]byte(content), '\n'))
 
-	d.doc.diagnostics = make(map[protocol.DocumentURI][]protocol.Diagnostic)
-	d.doc.pkg = nil
+	d.doc.startCompile(serverLifetime)
+
+	return nil
+}
+
+// recompile compiles the document again without changing its content, e.g. after the options of the cache changed.
+//
+// Since results of the old version are discarded, a handle to the new version is returned.
+func (d *DocumentHandle) recompile(serverLifetime context.Context) *DocumentHandle {
+	d.doc.mu.Lock()
+	defer d.doc.mu.Unlock()
+
+	d.doc.obsoleteVersion()
+	d.doc.startCompile(serverLifetime)
 
-	d.doc.compilers.Add(1)
+	return &DocumentHandle{d.doc, d.doc.versionCtx}
+}
+
+// startCompile resets all compile results and starts compiling the document in the background.
+//
+// d.mu must be locked when calling this.
+func (d *document) startCompile(serverLifetime context.Context) {
+	d.versionCtx, d.obsoleteVersion = context.WithCancel(serverLifetime)
+
+	d.diagnostics = make(map[protocol.DocumentURI][]protocol.Diagnostic)
+	d.pkg = nil
+
+	d.compilers.Add(1)
 
 	// We need to create a new document handler here since the old one
 	// still carries the deprecated version context
-	go (&DocumentHandle{d.doc, d.doc.versionCtx}).compile() //nolint:errcheck
+	d.cache.Scheduler.schedule(&DocumentHandle{d, d.versionCtx}, d.cache.getOptions().CompileDelay)
+}
 
-	return nil
+// Prioritize compiles the document as soon as possible, instead of waiting for the delay of its cache.
+//
+// It should be called before waiting for the results of a document, when the user is waiting for them.
+func (d *DocumentHandle) Prioritize() {
+	d.doc.cache.Scheduler.prioritize(d.doc)
 }
 
 // GetContent returns the content of a document.
@@ -205,11 +234,26 @@ func (d *DocumentHandle) GetSubstring(pos token.Pos, endPos token.Pos) (string,
 	}
 }
 
-// GetQueries returns the compiled queries of a document.
+// GetCompiled returns the compiled package of a document, which is compiled right away if necessary.
 //
-// It blocks until all compile tasks are finished.
-func (d *DocumentHandle) GetCompiled() (*asg.Package, error) {
-	d.doc.compilers.Wait()
+// It blocks until all compile tasks are finished, the document version expires or the context is done.
+// The package is nil if compiling the document failed or was cancelled.
+func (d *DocumentHandle) GetCompiled(ctx context.Context) (*asg.Package, error) {
+	d.Prioritize()
+
+	result := metrics.Hit
+	if d.doc.compilers.busy() {
+		result = metrics.Miss
+	}
+
+	event.Record2(ctx, metrics.Result.Of(result), metrics.CacheLookups.Of(1))
+
+	ctx, cancel := mergeContexts(ctx, d.ctx)
+	defer cancel()
+
+	if err := d.doc.compilers.WaitContext(ctx); err != nil {
+		return nil, err
+	}
 
 	d.doc.mu.RLock()
 
@@ -223,6 +267,24 @@ func (d *DocumentHandle) GetCompiled() (*asg.Package, error) {
 	}
 }
 
+// mergeContexts returns a context that is done as soon as one of the given contexts is done, or it is cancelled.
+//
+// It only carries the values of the first context.
+func mergeContexts(ctx context.Context, other context.Context) (context.Context, context.CancelFunc) {
+	merged, cancel := context.WithCancel(ctx)
+
+	go func() {
+		defer cancel()
+
+		select {
+		case <-merged.Done():
+		case <-other.Done():
+		}
+	}()
+
+	return merged, cancel
+}
+
 // GetVersion returns the version of a document.
 func (d *DocumentHandle) GetVersion() (float64, error) {
 	d.doc.mu.RLock()
@@ -236,6 +298,11 @@ func (d *DocumentHandle) GetVersion() (float64, error) {
 	}
 }
 
+// GetURI returns the URI of the document.
+func (d *DocumentHandle) GetURI() protocol.DocumentURI {
+	return d.doc.uri
+}
+
 // GetLanguageID returns the language ID of a document.
 //
 // Since the languageID never changes, it does not block or return errors.
@@ -260,6 +327,42 @@ func (d *DocumentHandle) GetDiagnostics() (map[protocol.DocumentURI][]protocol.D
 	}
 }
 
+// DocumentInfo describes the state of a document, for debugging.
+type DocumentInfo struct {
+	URI        protocol.DocumentURI
+	LanguageID string
+	Version    float64
+	// Compiling is set while a compile of the document is scheduled or running.
+	Compiling bool
+	// Package is the result of the compile of the current version.
+	// It is nil until that compile has finished, since changes reset the compile results.
+	Package *asg.Package
+	// Diagnostics is the number of diagnostics the compile of the current version reported so far.
+	Diagnostics int
+}
+
+// Info returns the current state of a document.
+//
+// Unlike the other getters, it neither blocks nor fails if the document changed.
+func (d *DocumentHandle) Info() DocumentInfo {
+	d.doc.mu.RLock()
+	defer d.doc.mu.RUnlock()
+
+	info := DocumentInfo{
+		URI:        d.doc.uri,
+		LanguageID: d.doc.languageID,
+		Version:    d.doc.version,
+		Compiling:  d.doc.compilers.busy(),
+		Package:    d.doc.pkg,
+	}
+
+	for _, diags := range d.doc.diagnostics {
+		info.Diagnostics += len(diags)
+	}
+
+	return info
+}
+
 func (d *DocumentHandle) Log(level protocol.MessageType, msg string, args ...interface{}) {
 	d.doc.cache.Logging <- protocol.LogMessageParams{
 		Type:    level,
@@ -14,6 +14,9 @@
 package cache
 
 import (
+	"context"
+
+	"cuelang.org/go/cue/errors"
 	"cuelang.org/go/cue/internal/lsp/asg"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
 	"cuelang.org/go/cue/token"
@@ -33,8 +36,8 @@ type Location struct {
 
 // Find returns all the information about a given position the cache can provide.
 //
-// It blocks until the document is fully parsed.
-func (c *DocumentCache) Find(where *protocol.TextDocumentPositionParams) (there *Location, err error) {
+// It blocks until the document is fully parsed, or the context is done.
+func (c *DocumentCache) Find(ctx context.Context, where *protocol.TextDocumentPositionParams) (there *Location, err error) {
 	there = &Location{}
 
 	if there.Doc, err = c.GetDocument(where.TextDocument.URI); err != nil {
@@ -52,10 +55,14 @@ func (c *DocumentCache) Find(where *protocol.TextDocumentPositionParams) (there
 	// 	return
 	// }
 
-	if there.Package, err = there.Doc.GetCompiled(); err != nil {
+	if there.Package, err = there.Doc.GetCompiled(ctx); err != nil {
 		return
 	}
 
+	if there.Package == nil {
+		return nil, errors.New("document has not been compiled")
+	}
+
 	there.Node = there.Package.Find(there.Pos)
 
 	// there.Cursor = there.RootCursor.SmallestSurroundingNode(there.Pos)
@@ -0,0 +1,275 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"path/filepath"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/load"
+)
+
+// evaluator serializes all loading and evaluation of CUE in the process.
+//
+// The evaluator shares state between instances, like the index of builtin packages,
+// and is not safe for concurrent use.
+var evaluator sync.Mutex
+
+// LockEvaluator waits until nobody else loads or evaluates CUE and returns the function that releases the lock.
+//
+// Loading, building and evaluating packages, as well as using the resulting values, must happen while the lock is held.
+// Compiles hold it as well, so it must not be held while waiting for the compile results of a document.
+func LockEvaluator() (unlock func()) {
+	evaluator.Lock()
+	return evaluator.Unlock
+}
+
+// packagePath returns the path of the package containing the document, relative to the directory packages are loaded from.
+func (d *DocumentHandle) packagePath() string {
+	relative, err := filepath.Rel(d.loadDir(), d.Dir())
+	if err != nil {
+		return "."
+	}
+
+	return "./" + relative
+}
+
+// loadDir returns the directory packages are loaded from, which is the module root if there is one.
+func (d *DocumentHandle) loadDir() string {
+	if root := d.ModuleRoot(); root != "" {
+		return root
+	}
+
+	return d.Dir()
+}
+
+// loadConfig returns a load.Config for the module of the document, that uses the content of all documents as overlay.
+func (d *DocumentHandle) loadConfig() (*load.Config, error) {
+	overlay, err := d.doc.cache.overlay()
+	if err != nil {
+		return nil, err
+	}
+
+	return &load.Config{
+		Dir:        d.loadDir(),
+		ModuleRoot: d.ModuleRoot(),
+		BuildTags:  d.doc.cache.getOptions().BuildTags,
+		Overlay:    overlay,
+		// Tool files are only loaded for tool files, like cue cmd does.
+		Tools: IsToolFile(d.doc.path),
+	}, nil
+}
+
+// createCompiler returns an asg compiler for the module of the document.
+func (d *DocumentHandle) createCompiler() (*asg.Compiler, error) {
+	config, err := d.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := asg.NewCompiler(config)
+	compiler.ReadFile = func(filename string) ([]byte, error) {
+		content, err := d.doc.cache.ReadFile(filename)
+		return []byte(content), err
+	}
+
+	return compiler, nil
+}
+
+// Dir returns the directory containing the document.
+//
+// Since the path of a document never changes, it does not block or return errors.
+func (d *DocumentHandle) Dir() string {
+	return filepath.Dir(d.doc.path)
+}
+
+// LoadInstance loads the package containing the document.
+//
+// The contents of all open documents are used instead of the files on disk.
+// Entries of overlay take precedence over both, which allows checking the
+// effect of changes before applying them.
+//
+// The evaluator must be locked when calling this.
+func (d *DocumentHandle) LoadInstance(overlay map[string]load.Source) (*build.Instance, error) {
+	select {
+	case <-d.ctx.Done():
+		return nil, d.ctx.Err()
+	default:
+	}
+
+	config, err := d.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	for path, src := range overlay {
+		config.Overlay[path] = src
+	}
+
+	insts := load.Instances([]string{d.packagePath()}, config)
+	if len(insts) == 0 || insts[0] == nil {
+		return nil, errors.New("failed to load any instance for " + d.packagePath())
+	}
+
+	if insts[0].Err != nil {
+		return nil, insts[0].Err
+	}
+
+	return insts[0], nil
+}
+
+// BuildInstance loads and evaluates the package containing the document.
+//
+// In contrast to GetCompiled, this uses the CUE evaluator and not the asg, so
+// the result can be exported or validated the same way the cue command does.
+// The contents of all open documents are used instead of the files on disk.
+//
+// The evaluator must be locked when calling this.
+func (d *DocumentHandle) BuildInstance() (*cue.Instance, error) {
+	binst, err := d.LoadInstance(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return Build(binst)
+}
+
+// Build evaluates a loaded instance.
+//
+// The evaluator must be locked when calling this.
+func Build(binst *build.Instance) (*cue.Instance, error) {
+	inst := cue.Build([]*build.Instance{binst})[0]
+	if inst.Err != nil {
+		return nil, inst.Err
+	}
+
+	return inst, nil
+}
+
+// PackageFiles returns the names of all CUE files of the package containing
+// the document, including test and tool files.
+//
+// The evaluator must be locked when calling this.
+func (d *DocumentHandle) PackageFiles() ([]string, error) {
+	config, err := d.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	config.Tests = true
+	config.Tools = true
+
+	insts := load.Instances([]string{d.packagePath()}, config)
+	if len(insts) == 0 || insts[0] == nil {
+		return nil, errors.New("failed to load any instance for " + d.packagePath())
+	}
+
+	inst := insts[0]
+	if inst.Err != nil {
+		return nil, inst.Err
+	}
+
+	var ret []string
+
+	for _, f := range inst.BuildFiles {
+		if f.Encoding == build.CUE {
+			ret = append(ret, f.Filename)
+		}
+	}
+
+	ret = append(ret, inst.ToolCUEFiles...)
+	ret = append(ret, inst.TestCUEFiles...)
+
+	return ret, nil
+}
+
+// CompileModulePackages compiles the packages returned by ModulePackages.
+//
+// Packages that fail to compile are left out.
+// The evaluator must be locked when calling this.
+func (d *DocumentHandle) CompileModulePackages() ([]*asg.Package, error) {
+	insts, err := d.ModulePackages()
+	if err != nil || len(insts) == 0 {
+		return nil, err
+	}
+
+	compiler, err := d.createCompiler()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*asg.Package
+
+	for _, inst := range insts {
+		if pkg, _ := compiler.CompileInstance(inst); pkg != nil {
+			ret = append(ret, pkg)
+		}
+	}
+
+	return ret, nil
+}
+
+// ResolveImport loads the package with the given import path, as seen from the document.
+//
+// Packages of the module as well as packages in cue.mod/pkg are found.
+// The evaluator must be locked when calling this.
+func (d *DocumentHandle) ResolveImport(importPath string) (*build.Instance, error) {
+	config, err := d.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	insts := load.Instances([]string{importPath}, config)
+	if len(insts) == 0 || insts[0] == nil {
+		return nil, errors.New("failed to load any instance for " + importPath)
+	}
+
+	if insts[0].Err != nil {
+		return nil, insts[0].Err
+	}
+
+	return insts[0], nil
+}
+
+// ModulePackages loads all packages of the module containing the document, except the package of the document itself.
+//
+// The files of the packages are parsed but not evaluated. Packages that fail to load are left out.
+// If the document is not part of a module, there are no packages.
+// The evaluator must be locked when calling this.
+func (d *DocumentHandle) ModulePackages() ([]*build.Instance, error) {
+	if d.ModuleRoot() == "" {
+		return nil, nil
+	}
+
+	config, err := d.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*build.Instance
+
+	for _, inst := range load.Instances([]string{"./..."}, config) {
+		if inst == nil || inst.Err != nil || inst.Dir == d.Dir() || inst.ImportPath == "" {
+			continue
+		}
+
+		ret = append(ret, inst)
+	}
+
+	return ret, nil
+}
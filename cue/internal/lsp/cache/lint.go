@@ -0,0 +1,80 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/lint"
+)
+
+// addLintDiagnostics reports the problems the lint rules find in the document.
+//
+// The package is only evaluated if an enabled rule needs it. If the context expires, no diagnostics are added.
+func (d *DocumentHandle) addLintDiagnostics(ctx context.Context, pkg *asg.Package) error {
+	config := d.doc.cache.getOptions().Lint
+
+	var inst *cue.Instance
+
+	if config.NeedsInstance() {
+		// Rules that need the instance are skipped if the package does not evaluate.
+		inst, _ = d.BuildInstance()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	for _, p := range lint.Run(pkg, inst, config) {
+		if p.Start.Filename() != d.doc.path {
+			continue
+		}
+
+		diagnostic, err := d.cueErrToProtocolDiagnostic(errors.Newf(p.Start, "%s", p.Message), p.Start, p.End)
+		if err != nil {
+			return err
+		}
+
+		diagnostic.Severity = lintSeverity(p.Severity)
+		diagnostic.Source = lint.Source
+		diagnostic.Code = p.Rule.ID
+
+		if p.Rule.Unnecessary {
+			diagnostic.Tags = []protocol.DiagnosticTag{protocol.Unnecessary}
+		}
+
+		if err := d.addDiagnostic(diagnostic, d.doc.uri); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lintSeverity returns the diagnostic severity of a lint severity.
+func lintSeverity(s lint.Severity) protocol.DiagnosticSeverity {
+	switch s {
+	case lint.Hint:
+		return protocol.SeverityHint
+	case lint.Info:
+		return protocol.SeverityInformation
+	case lint.Warning:
+		return protocol.SeverityWarning
+	default:
+		return protocol.SeverityError
+	}
+}
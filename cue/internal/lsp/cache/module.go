@@ -0,0 +1,52 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ModuleRoot returns the root directory of the module containing the document,
+// or an empty string if the document is not part of a module.
+//
+// Unless the root is overridden in the options of the cache, it is the nearest
+// directory containing a cue.mod directory or a legacy cue.mod file.
+func (d *DocumentHandle) ModuleRoot() string {
+	if root := d.doc.cache.getOptions().ModuleRoot; root != "" {
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(d.doc.cache.root(), root)
+		}
+
+		return root
+	}
+
+	return findModuleRoot(d.Dir())
+}
+
+// findModuleRoot searches dir and its parents for a cue.mod entry.
+func findModuleRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "cue.mod")); err == nil {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+
+		dir = parent
+	}
+}
@@ -41,25 +41,10 @@ func (d *DocumentHandle) tokenPositionToProtocolPosition(pos token.Position) (pr
 			}, nil
 		}
 
-		// Convert to the Positions as described in the LSP Spec
-		// lineStart, err := d.lineStartSafe(line - 1)
-		// if err != nil {
-		// 	return protocol.Position{}, err
-		// }
-
-		//offset := lineStart + char - 1
-		//point := span.NewPoint(line, char, offset)
-
-		//char, err = span.ToUTF16Column(point, []byte(d.doc.content))
 		// Protocol has zero based positions
-
 		char--
 		line--
 
-		// if err != nil {
-		// 	return protocol.Position{}, err
-		// }
-
 		return protocol.Position{
 			Line:      float64(line),
 			Character: float64(char),
@@ -92,9 +77,10 @@ func (d *DocumentHandle) protocolPositionToTokenPos(pos protocol.Position) (toke
 		}
 
 		offset := lineStart
-		point := span.NewPoint(line, 0, offset)
+		point := span.NewPoint(line, 1, offset)
 
-		point, err = span.FromUTF16Column(point, char, []byte(d.doc.content))
+		// span uses 1 based columns
+		point, err = span.FromUTF16Column(point, char+1, []byte(d.doc.content))
 		if err != nil {
 			return token.NoPos, err
 		}
@@ -0,0 +1,55 @@
+// Copyright 2019 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/token"
+)
+
+// Protocol positions count UTF-16 code units, so a multi-byte character
+// before the cursor must not shift the resulting offset.
+func TestProtocolPositionToTokenPos(t *testing.T) {
+	const content = "a: 1\nx: \"ü\", y: 1\n"
+
+	doc := &document{content: content}
+	doc.posData = token.NewFile("", -1, len(content))
+	doc.posData.SetLinesForContent([]byte(content))
+
+	d := &DocumentHandle{doc, context.Background()}
+
+	testCases := []struct {
+		pos  protocol.Position
+		want byte
+	}{
+		{protocol.Position{Line: 0, Character: 0}, 'a'},
+		{protocol.Position{Line: 1, Character: 0}, 'x'},
+		{protocol.Position{Line: 1, Character: 8}, 'y'},
+		{protocol.Position{Line: 1, Character: 11}, '1'},
+	}
+
+	for _, tc := range testCases {
+		pos, err := d.protocolPositionToTokenPos(tc.pos)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.pos, err)
+		}
+
+		if got := content[pos.Offset()]; got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.pos, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import "context"
+
+// Progress reports the state of a long running operation of the cache.
+type Progress interface {
+	// Report updates the message of the operation. A percentage of 0 means it is unknown.
+	Report(message string, percentage float64)
+	// End marks the operation as finished.
+	End(message string)
+}
+
+// ProgressFunc starts reporting a long running operation.
+//
+// The returned context is cancelled if the user cancels a cancellable operation.
+type ProgressFunc func(ctx context.Context, title string, cancellable bool) (context.Context, Progress)
+
+type noProgress struct{}
+
+func (noProgress) Report(string, float64) {}
+
+func (noProgress) End(string) {}
+
+// startProgress starts reporting an operation, if the cache has a Progress function.
+func (c *DocumentCache) startProgress(ctx context.Context, title string, cancellable bool) (context.Context, Progress) {
+	if c.Progress == nil {
+		return ctx, noProgress{}
+	}
+
+	return c.Progress(ctx, title, cancellable)
+}
@@ -0,0 +1,242 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduler runs the compiles of documents in the background.
+//
+// Changes are debounced per package: the documents of a package are compiled once
+// the package did not change for the CompileDelay of their cache.
+// A change replaces the compile that is still scheduled for the previous version of the document.
+//
+// Compiles use the CUE evaluator, which is not safe for concurrent use, so only one of them runs at a time.
+// Prioritized documents go first, followed by the most recently changed ones.
+//
+// A Scheduler can be shared by several caches.
+type Scheduler struct {
+	mu      sync.Mutex
+	running bool
+	// Compiles that wait for their package to settle, by package directory.
+	pending map[string]*pendingPackage
+	// Compiles that wait for the running one to finish.
+	ready []*compileJob
+	// Increases with every scheduled compile, so more recent changes can be preferred.
+	seq uint64
+	// The number of compiles started so far.
+	started int64
+}
+
+type pendingPackage struct {
+	timer *time.Timer
+	jobs  map[*document]*compileJob
+}
+
+type compileJob struct {
+	handle *DocumentHandle
+	pkg    string
+	seq    uint64
+	urgent bool
+}
+
+// NewScheduler creates a Scheduler.
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		pending: make(map[string]*pendingPackage),
+	}
+}
+
+// Compiles returns the number of compiles the scheduler started.
+func (s *Scheduler) Compiles() int64 {
+	return atomic.LoadInt64(&s.started)
+}
+
+// schedule compiles a document version after the delay.
+//
+// d.doc.compilers.Add(1) must be called before calling this, it is released once the compile is done or replaced.
+func (s *Scheduler) schedule(d *DocumentHandle, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drop(d.doc)
+
+	s.seq++
+
+	key := filepath.Dir(d.doc.path)
+
+	p := s.pending[key]
+	if p == nil {
+		p = &pendingPackage{jobs: make(map[*document]*compileJob)}
+		s.pending[key] = p
+	}
+
+	p.jobs[d.doc] = &compileJob{handle: d, pkg: key, seq: s.seq}
+
+	if delay <= 0 {
+		s.release(key)
+		return
+	}
+
+	// Every change of the package postpones its compiles.
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+
+	p.timer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.pending[key] == p {
+			s.release(key)
+		}
+	})
+}
+
+// prioritize compiles a document as soon as possible, skipping the delay and other waiting compiles.
+func (s *Scheduler) prioritize(doc *document) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.pending {
+		if job, ok := p.jobs[doc]; ok {
+			job.urgent = true
+			s.release(job.pkg)
+
+			return
+		}
+	}
+
+	for _, job := range s.ready {
+		if job.handle.doc == doc {
+			job.urgent = true
+			return
+		}
+	}
+}
+
+// cancel drops the compile that is still scheduled for a document.
+func (s *Scheduler) cancel(doc *document) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drop(doc)
+}
+
+// drop removes the compile that is scheduled for a document, if any.
+//
+// s.mu must be locked when calling this.
+func (s *Scheduler) drop(doc *document) {
+	for key, p := range s.pending {
+		if job, ok := p.jobs[doc]; ok {
+			delete(p.jobs, doc)
+
+			if len(p.jobs) == 0 {
+				if p.timer != nil {
+					p.timer.Stop()
+				}
+
+				delete(s.pending, key)
+			}
+
+			job.handle.doc.compilers.Done()
+
+			return
+		}
+	}
+
+	for i, job := range s.ready {
+		if job.handle.doc == doc {
+			s.ready = append(s.ready[:i], s.ready[i+1:]...)
+			job.handle.doc.compilers.Done()
+
+			return
+		}
+	}
+}
+
+// release moves the compiles of a package to the ready queue.
+//
+// s.mu must be locked when calling this.
+func (s *Scheduler) release(key string) {
+	p := s.pending[key]
+	if p == nil {
+		return
+	}
+
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+
+	delete(s.pending, key)
+
+	for _, job := range p.jobs {
+		s.ready = append(s.ready, job)
+	}
+
+	s.dispatch()
+}
+
+// dispatch starts the next ready compile, unless one is running.
+//
+// s.mu must be locked when calling this.
+func (s *Scheduler) dispatch() {
+	if s.running || len(s.ready) == 0 {
+		return
+	}
+
+	next := 0
+
+	for i, job := range s.ready {
+		best := s.ready[next]
+		if job.urgent != best.urgent {
+			if job.urgent {
+				next = i
+			}
+
+			continue
+		}
+
+		if job.seq > best.seq {
+			next = i
+		}
+	}
+
+	job := s.ready[next]
+	s.ready = append(s.ready[:next], s.ready[next+1:]...)
+
+	s.running = true
+	atomic.AddInt64(&s.started, 1)
+
+	go s.run(job)
+}
+
+func (s *Scheduler) run(job *compileJob) {
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.running = false
+		s.dispatch()
+	}()
+
+	unlock := LockEvaluator()
+	defer unlock()
+
+	job.handle.compile() //nolint:errcheck
+}
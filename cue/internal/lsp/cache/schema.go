@@ -0,0 +1,343 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/load"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+	"cuelang.org/go/internal"
+	"cuelang.org/go/internal/encoding"
+)
+
+// SchemaMapping selects the CUE schema that JSON and YAML documents are validated against.
+type SchemaMapping struct {
+	// Files is a glob matched against the slash separated path of a document relative to the root folder.
+	// Globs without a slash are matched against the file name instead.
+	Files string
+	// Package is the import path of the package containing the schema,
+	// or a directory relative to the root folder, like ./schemas.
+	Package string
+	// Path is a CUE expression selecting the schema in the package, like #Deployment.
+	// If it is empty, the whole package is the schema.
+	Path string
+}
+
+// schemaDirective is the comment that selects the schema of a YAML document, like
+//
+//	# @schema ./schemas #Deployment
+//
+// Relative packages are resolved relative to the directory of the document.
+const schemaDirective = "@schema"
+
+// dataEncodings are the encodings of the language IDs of data documents.
+var dataEncodings = map[string]build.Encoding{
+	"json": build.JSON,
+	"yaml": build.YAML,
+}
+
+// ValidateSchemaPath returns an error if the path of a SchemaMapping is not a valid CUE expression.
+func ValidateSchemaPath(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	_, err := parser.ParseExpr("schema", path)
+
+	return err
+}
+
+// schema returns the schema a data document is validated against, and the directory relative packages are resolved in.
+//
+// A @schema comment takes precedence over the mappings of the cache options. ok is false if there is no schema.
+func (d *DocumentHandle) schema(content string) (schema SchemaMapping, dir string, ok bool) {
+	if schema, ok := parseSchemaDirective(content); ok {
+		return schema, d.Dir(), true
+	}
+
+	root := d.doc.cache.root()
+
+	rel, err := filepath.Rel(root, d.doc.path)
+	if err != nil {
+		return SchemaMapping{}, "", false
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	for _, m := range d.doc.cache.getOptions().Schemas {
+		name := rel
+		if !strings.Contains(m.Files, "/") {
+			name = filepath.Base(rel)
+		}
+
+		if matched, _ := filepath.Match(m.Files, name); matched {
+			return m, root, true
+		}
+	}
+
+	return SchemaMapping{}, "", false
+}
+
+// parseSchemaDirective looks for a @schema comment in the leading comments of a YAML document.
+func parseSchemaDirective(content string) (SchemaMapping, bool) {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "" || line == "---":
+			continue
+		case !strings.HasPrefix(line, "#"):
+			return SchemaMapping{}, false
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "#"))
+		if len(fields) < 2 || fields[0] != schemaDirective {
+			continue
+		}
+
+		schema := SchemaMapping{Package: fields[1]}
+		if len(fields) > 2 {
+			schema.Path = strings.Join(fields[2:], " ")
+		}
+
+		return schema, true
+	}
+
+	return SchemaMapping{}, false
+}
+
+// loadSchema loads and evaluates the package of a schema and selects the schema in it.
+//
+// Relative packages are resolved in dir.
+func (d *DocumentHandle) loadSchema(schema SchemaMapping, dir string) (*cue.Runtime, cue.Value, error) {
+	config, err := d.loadConfig()
+	if err != nil {
+		return nil, cue.Value{}, err
+	}
+
+	pkg := schema.Package
+
+	// Relative packages are loaded from their own directory, so they can be outside of the directory of the document.
+	if pkg == "." || pkg == ".." || strings.HasPrefix(pkg, "./") || strings.HasPrefix(pkg, "../") {
+		dir, pkg = filepath.Join(dir, filepath.FromSlash(pkg)), "."
+	}
+
+	config.Dir = dir
+
+	insts := load.Instances([]string{pkg}, config)
+	if len(insts) == 0 || insts[0] == nil {
+		return nil, cue.Value{}, errors.New("failed to load any instance for " + schema.Package)
+	}
+
+	if insts[0].Err != nil {
+		return nil, cue.Value{}, insts[0].Err
+	}
+
+	inst, err := Build(insts[0])
+	if err != nil {
+		return nil, cue.Value{}, err
+	}
+
+	r := internal.GetRuntime(inst).(*cue.Runtime)
+
+	if schema.Path == "" {
+		return r, inst.Value(), nil
+	}
+
+	expr, err := parser.ParseExpr("schema", schema.Path)
+	if err != nil {
+		return nil, cue.Value{}, err
+	}
+
+	v := inst.Eval(expr)
+
+	return r, v, v.Err()
+}
+
+// compileData validates a JSON or YAML document against its schema, like cue vet does.
+//
+// Documents without a schema are not checked.
+//
+// d.compilers.Add(1) must be called before calling this.
+func (d *DocumentHandle) compileData(enc build.Encoding) error {
+	defer d.doc.compilers.Done()
+
+	content, err := d.GetContent()
+	if err != nil {
+		return err
+	}
+
+	schema, dir, ok := d.schema(content)
+	if !ok {
+		return nil
+	}
+
+	ctx, progress := d.doc.cache.startProgress(d.ctx, fmt.Sprintf("Validating %s", filepath.Base(d.doc.path)), true)
+	defer progress.End("")
+
+	r, base, err := d.loadSchema(schema, dir)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err != nil {
+		start := d.doc.posData.Pos(0, token.NoRelPos)
+		msg := errors.Newf(start, "cannot load schema %s %s: %v", schema.Package, schema.Path, err)
+
+		return d.addDataDiagnostic(msg, start, start)
+	}
+
+	return d.validateData(ctx, r, base, enc, content)
+}
+
+// validateData reports the errors of unifying every value of a data document with a schema.
+func (d *DocumentHandle) validateData(ctx context.Context, r *cue.Runtime, base cue.Value, enc build.Encoding, content string) error {
+	dec := encoding.NewDecoder(&build.File{
+		Filename: d.doc.path,
+		Encoding: enc,
+		Source:   content,
+	}, nil)
+	defer dec.Close()
+
+	for ; !dec.Done(); dec.Next() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		f := dec.File()
+
+		inst, err := r.CompileFile(f)
+		if err == nil {
+			inst, err = inst.Fill(base)
+		}
+
+		if err == nil {
+			// Data is always concrete, so incomplete values are errors, like in cue vet.
+			err = inst.Value().Validate(cue.Concrete(true))
+		}
+
+		for _, e := range errors.Errors(err) {
+			start, end := d.dataErrorRange(f, e, enc)
+			if err := d.addDataDiagnostic(e, start, end); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Syntax errors of the document.
+	for _, e := range errors.Errors(dec.Err()) {
+		start := d.doc.posData.Pos(0, token.NoRelPos)
+		if pos := e.Position(); pos.Filename() == d.doc.path {
+			start = d.dataPos(pos, enc)
+		}
+
+		if err := d.addDataDiagnostic(e, start, start); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dataErrorRange returns the range of the smallest node of a data document an error refers to.
+//
+// Errors that only refer to the schema are reported at the start of the document.
+func (d *DocumentHandle) dataErrorRange(f *ast.File, e errors.Error, enc build.Encoding) (token.Pos, token.Pos) {
+	for _, pos := range errors.Positions(e) {
+		if pos.Filename() != d.doc.path {
+			continue
+		}
+
+		end := pos
+
+		var lit bool
+
+		ast.Walk(f, func(n ast.Node) bool {
+			if n.Pos().Filename() != d.doc.path || n.Pos().Offset() > pos.Offset() || n.End().Offset() < pos.Offset() {
+				return true
+			}
+
+			if n.Pos().Offset() == pos.Offset() && (end == pos || n.End().Offset() < end.Offset()) {
+				end = n.End()
+				_, lit = n.(*ast.BasicLit)
+			}
+
+			return true
+		}, nil)
+
+		start := d.dataPos(pos, enc)
+
+		// The end of a literal is computed from its CUE representation, which is longer than unquoted YAML strings.
+		if lit {
+			return start, d.clampToLine(start, d.dataPos(end, enc))
+		}
+
+		return start, d.dataPos(end, enc)
+	}
+
+	start := d.doc.posData.Pos(0, token.NoRelPos)
+
+	return start, start
+}
+
+// clampToLine moves end to the end of the line of start, if it is behind it.
+func (d *DocumentHandle) clampToLine(start token.Pos, end token.Pos) token.Pos {
+	d.doc.mu.RLock()
+	content := d.doc.content
+	d.doc.mu.RUnlock()
+
+	if start.Offset() > len(content) {
+		return end
+	}
+
+	if i := strings.IndexByte(content[start.Offset():], '\n'); i >= 0 && end.Offset() > start.Offset()+i {
+		return d.doc.posData.Pos(start.Offset()+i, token.NoRelPos)
+	}
+
+	return end
+}
+
+// dataPos converts a position of the decoded data to a position of the document.
+func (d *DocumentHandle) dataPos(pos token.Pos, enc build.Encoding) token.Pos {
+	offset := pos.Offset()
+
+	// The offsets of the YAML decoder are one byte past the position they refer to.
+	if enc == build.YAML && offset > 0 {
+		offset--
+	}
+
+	if offset > d.doc.posData.Size() {
+		offset = d.doc.posData.Size()
+	}
+
+	return d.doc.posData.Pos(offset, token.NoRelPos)
+}
+
+func (d *DocumentHandle) addDataDiagnostic(e errors.Error, start token.Pos, end token.Pos) error {
+	diagnostic, err := d.cueErrToProtocolDiagnostic(e, start, end)
+	if err != nil {
+		return err
+	}
+
+	return d.addDiagnostic(diagnostic, d.doc.uri)
+}
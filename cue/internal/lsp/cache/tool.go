@@ -0,0 +1,481 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/tool"
+	"cuelang.org/go/cue/token"
+	"cuelang.org/go/internal"
+	itask "cuelang.org/go/internal/task"
+	"cuelang.org/go/internal/walk"
+	_ "cuelang.org/go/pkg/tool/cli" // Register tasks
+	_ "cuelang.org/go/pkg/tool/exec"
+	_ "cuelang.org/go/pkg/tool/file"
+	_ "cuelang.org/go/pkg/tool/http"
+	_ "cuelang.org/go/pkg/tool/os"
+)
+
+// commandSection is the field of tool files that holds the commands run by cue cmd.
+const commandSection = "command"
+
+// IsToolFile reports whether a file is a tool file, which is only loaded by cue cmd.
+func IsToolFile(path string) bool {
+	return strings.HasSuffix(path, "_tool.cue")
+}
+
+// BuildToolInstance loads and evaluates the package containing the document together with its tool files,
+// the way cue cmd does.
+func (d *DocumentHandle) BuildToolInstance() (*cue.Instance, error) {
+	binst, err := d.LoadInstance(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tool files are not part of the package, but an instance of their own that is merged with it.
+	ti := binst.Context().NewInstance(binst.Root, nil)
+
+	for _, f := range binst.ToolCUEFiles {
+		filename := binst.Abs(f)
+
+		src, err := d.doc.cache.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ti.AddFile(filename, src); err != nil {
+			return nil, err
+		}
+	}
+
+	inst, err := Build(binst)
+	if err != nil {
+		return nil, err
+	}
+
+	inst = inst.Build(ti)
+	if inst.Err != nil {
+		return nil, inst.Err
+	}
+
+	return inst, nil
+}
+
+// RunToolCommand runs a command of the tool files of the package containing the document, like cue cmd does,
+// and writes the output of its tasks to out.
+//
+// In contrast to cue cmd, the tasks run one after another on the contents of the open documents, and read no input.
+//
+// The evaluator must be locked when calling this.
+func (d *DocumentHandle) RunToolCommand(ctx context.Context, name string, out io.Writer) error {
+	inst, err := d.BuildToolInstance()
+	if err != nil {
+		return err
+	}
+
+	base := []string{commandSection, name}
+
+	command := inst.Lookup(base...)
+	if !command.Exists() {
+		return errors.Newf(token.NoPos, "command %s not found", name)
+	}
+
+	var tasks []*toolTask
+	collectTasks(&tasks, command, base)
+
+	runners := make(map[*toolTask]itask.Runner, len(tasks))
+
+	for _, t := range tasks {
+		runner, err := newTaskRunner(t)
+		if err != nil {
+			return err
+		}
+
+		runners[t] = runner
+	}
+
+	addTaskDependencies(inst, tasks)
+
+	if len(taskCycles(tasks)) > 0 {
+		return errors.Newf(command.Pos(), "cyclic dependency in tasks")
+	}
+
+	done := make(map[*toolTask]bool, len(tasks))
+
+	for len(done) < len(tasks) {
+		for _, t := range tasks {
+			if done[t] || !taskReady(t, done) {
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			c := &itask.Context{
+				Context: ctx,
+				Stdin:   strings.NewReader(""),
+				Stdout:  out,
+				Stderr:  out,
+				Obj:     inst.Lookup(t.path...),
+			}
+
+			update, err := runners[t].Run(c)
+			if c.Err != nil {
+				err = c.Err
+			}
+
+			if err == nil && update != nil {
+				inst, err = inst.Fill(update, t.path...)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			done[t] = true
+		}
+	}
+
+	return nil
+}
+
+// newTaskRunner creates the runner of a task, after checking the task against the schema of its $id.
+func newTaskRunner(t *toolTask) (itask.Runner, error) {
+	field := t.value.Lookup("$id")
+	if !field.Exists() {
+		field = t.value.Lookup("kind")
+	}
+
+	id, err := field.String()
+	if err != nil {
+		return nil, err
+	}
+
+	// Older names of tasks, like exec, are registered under their $id.
+	known := tool.Lookup(id)
+	if known == nil || itask.Lookup(known.ID) == nil {
+		return nil, errors.Newf(field.Pos(), "runner of kind %q not found", id)
+	}
+
+	rf := itask.Lookup(known.ID)
+
+	v := internal.UnifyBuiltin(t.value, known.ID).(cue.Value)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	return rf(v)
+}
+
+// taskReady reports whether all dependencies of a task are done.
+func taskReady(t *toolTask, done map[*toolTask]bool) bool {
+	for _, dep := range t.deps {
+		if !done[dep] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// toolTask is a task of a command, found the way cue cmd finds them.
+type toolTask struct {
+	path  []string
+	value cue.Value
+	deps  []*toolTask
+}
+
+// taskKey returns the path of a task as a string, so paths can be compared by their prefix.
+func taskKey(path []string) string {
+	return strings.Join(path, "\000") + "\000"
+}
+
+// addToolDiagnostics reports the tasks of the commands in the document that cue cmd would fail to run:
+// tasks with an unknown $id and tasks that depend on each other.
+//
+// Errors in the tool files are reported as well, since the evaluation of the package does not include them.
+// If the context expires, no diagnostics are added.
+func (d *DocumentHandle) addToolDiagnostics(ctx context.Context, pkg *asg.Package) error {
+	inst, err := d.BuildToolInstance()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err != nil {
+		for _, e := range errors.Errors(err) {
+			for _, pos := range errors.Positions(e) {
+				if err := d.addToolDiagnostic(pkg, pos, e); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	commands, err := inst.Lookup(commandSection).Fields()
+	if err != nil {
+		return nil
+	}
+
+	for commands.Next() {
+		base := []string{commandSection, commands.Label()}
+
+		var tasks []*toolTask
+		collectTasks(&tasks, commands.Value(), base)
+
+		for _, t := range tasks {
+			if err := d.checkTaskID(pkg, t); err != nil {
+				return err
+			}
+		}
+
+		addTaskDependencies(inst, tasks)
+
+		for _, cycle := range taskCycles(tasks) {
+			names := make([]string, 0, len(cycle)+1)
+			for _, t := range append(cycle, cycle[0]) {
+				names = append(names, strings.Join(t.path, "."))
+			}
+
+			for _, t := range cycle {
+				e := errors.Newf(t.value.Pos(), "cyclic dependency in tasks: %s", strings.Join(names, " -> "))
+				if err := d.addToolDiagnostic(pkg, t.value.Pos(), e); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// addToolDiagnostic adds a diagnostic for an error at a position, if the position is in the document.
+func (d *DocumentHandle) addToolDiagnostic(pkg *asg.Package, pos token.Pos, e errors.Error) error {
+	if pos.Filename() != d.doc.path {
+		return nil
+	}
+
+	end := pos
+	if n := pkg.Find(pos); n != nil {
+		end = asg.ClampToFile(n.End())
+	}
+
+	diagnostic, err := d.cueErrToProtocolDiagnostic(e, pos, end)
+	if err != nil {
+		return err
+	}
+
+	return d.addDiagnostic(diagnostic, d.doc.uri)
+}
+
+// checkTaskID reports a task whose $id, or kind for older tasks, does not name a known task.
+func (d *DocumentHandle) checkTaskID(pkg *asg.Package, t *toolTask) error {
+	field := t.value.Lookup("$id")
+	if !field.Exists() {
+		field = t.value.Lookup("kind")
+	}
+
+	id, err := field.String()
+	if err != nil || tool.Lookup(id) != nil {
+		return nil
+	}
+
+	return d.addToolDiagnostic(pkg, field.Pos(), errors.Newf(field.Pos(), "runner of kind %q not found", id))
+}
+
+// isTask reports whether a value is a task, like cue cmd does.
+func isTask(v cue.Value) bool {
+	return v.Kind() == cue.StructKind &&
+		(v.Lookup("$id").Exists() || v.Lookup("kind").Exists())
+}
+
+// collectTasks adds the tasks of a command to tasks.
+func collectTasks(tasks *[]*toolTask, v cue.Value, path []string) {
+	if v.Err() != nil || v.Kind()&cue.StructKind == 0 {
+		return
+	}
+
+	if isTask(v) {
+		*tasks = append(*tasks, &toolTask{path: append([]string{}, path...), value: v})
+		return
+	}
+
+	for iter, _ := v.Fields(); iter.Next(); {
+		l := iter.Label()
+		if strings.HasPrefix(l, "$") || l == "command" || l == "commands" {
+			continue
+		}
+
+		collectTasks(tasks, iter.Value(), append(path, l))
+	}
+}
+
+// addTaskDependencies sets the dependencies of tasks, from their $after fields and from their references
+// to values of other tasks that are only known once those ran.
+func addTaskDependencies(inst *cue.Instance, tasks []*toolTask) {
+	for _, t := range tasks {
+		deps := map[*toolTask]bool{}
+
+		after := t.value.Lookup("$after")
+		if after.Exists() {
+			refs := []cue.Value{after}
+			if after.Kind() == cue.ListKind {
+				refs = nil
+				for iter, _ := after.List(); iter.Next(); {
+					refs = append(refs, iter.Value())
+				}
+			}
+
+			for _, ref := range refs {
+				if _, path := ref.Reference(); len(path) > 0 {
+					prefix := taskKey(path)
+
+					for _, dep := range tasks {
+						if strings.HasPrefix(taskKey(dep.path), prefix) {
+							deps[dep] = true
+						}
+					}
+				}
+			}
+		}
+
+		visited := map[string]bool{}
+
+		t.value.Walk(func(v cue.Value) bool {
+			if v == t.value {
+				return true
+			}
+
+			// Prevent infinite walks.
+			if _, path := v.Reference(); path != nil {
+				if visited[taskKey(path)] {
+					return false
+				}
+
+				visited[taskKey(path)] = true
+			}
+
+			for _, ref := range appendReferences(nil, inst, v) {
+				dep := findTask(tasks, ref)
+				if dep == nil || dep == t {
+					continue
+				}
+
+				// Only values that are set when the task runs make it a dependency.
+				if v := inst.Lookup(ref...); !v.IsConcrete() && v.Kind() != cue.StructKind {
+					deps[dep] = true
+				}
+			}
+
+			return true
+		}, nil)
+
+		for _, dep := range tasks {
+			if deps[dep] {
+				t.deps = append(t.deps, dep)
+			}
+		}
+	}
+}
+
+// findTask returns the task that contains the value a reference refers to, or nil.
+func findTask(tasks []*toolTask, ref []string) *toolTask {
+	for ; len(ref) > 0; ref = ref[:len(ref)-1] {
+		key := taskKey(ref)
+
+		for _, t := range tasks {
+			if taskKey(t.path) == key {
+				return t
+			}
+		}
+	}
+
+	return nil
+}
+
+// appendReferences adds the references to values of the instance that a value is made of.
+func appendReferences(a [][]string, root *cue.Instance, v cue.Value) [][]string {
+	inst, path := v.Reference()
+	if path != nil && inst == root {
+		return append(a, path)
+	}
+
+	switch op, args := v.Expr(); op {
+	case cue.NoOp:
+		walk.Value(v, &walk.Config{
+			Opts: []cue.Option{cue.All()},
+			After: func(w cue.Value) {
+				if v != w {
+					a = appendReferences(a, root, w)
+				}
+			},
+		})
+	default:
+		for _, arg := range args {
+			a = appendReferences(a, root, arg)
+		}
+	}
+
+	return a
+}
+
+// taskCycles returns the cycles in the dependencies of tasks, each one once.
+func taskCycles(tasks []*toolTask) [][]*toolTask {
+	var (
+		cycles  [][]*toolTask
+		stack   []*toolTask
+		onStack = map[*toolTask]bool{}
+		visited = map[*toolTask]bool{}
+		visit   func(t *toolTask)
+	)
+
+	visit = func(t *toolTask) {
+		visited[t] = true
+		onStack[t] = true
+		stack = append(stack, t)
+
+		for _, dep := range t.deps {
+			switch {
+			case onStack[dep]:
+				for i := range stack {
+					if stack[i] == dep {
+						cycles = append(cycles, append([]*toolTask{}, stack[i:]...))
+						break
+					}
+				}
+			case !visited[dep]:
+				visit(dep)
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		onStack[t] = false
+	}
+
+	for _, t := range tasks {
+		if !visited[t] {
+			visit(t)
+		}
+	}
+
+	return cycles
+}
@@ -14,6 +14,7 @@
 package cache
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 )
@@ -75,3 +76,29 @@ func (wg *waitGroup) Wait() {
 		wg.cond.Wait()
 	}
 }
+
+// busy reports whether there are jobs that have not finished.
+func (wg *waitGroup) busy() bool {
+	return atomic.LoadInt32(&wg.workers) > 0
+}
+
+// WaitContext is like Wait, but gives up once the context is done.
+func (wg *waitGroup) WaitContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package check reports the diagnostics of the language server for a set of files, without an editor.
+//
+// The files are opened in a headless server, so the results are the same an editor shows,
+// including the checks of the asg like unresolved references.
+package check
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/internal/lsp"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/lint"
+)
+
+// The supported output formats.
+const (
+	// TextFormat prints one line per diagnostic, like compilers do.
+	TextFormat = "text"
+	// JSONFormat prints one JSON object per diagnostic and line.
+	JSONFormat = "json"
+	// SARIFFormat prints a SARIF 2.1.0 log.
+	SARIFFormat = "sarif"
+)
+
+// Diagnostic is a diagnostic of a file.
+//
+// It is the output of the JSON format.
+type Diagnostic struct {
+	// File is the path of the file, relative to the working directory if possible.
+	File     string         `json:"file"`
+	Range    protocol.Range `json:"range"`
+	Severity string         `json:"severity"`
+	Source   string         `json:"source,omitempty"`
+	// Code identifies the check that reported the diagnostic, like the ID of a lint rule.
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// Result contains the diagnostics of all checked files.
+type Result struct {
+	Diagnostics []Diagnostic
+	// Files is the number of checked files.
+	Files int
+}
+
+// Errors returns the number of diagnostics with the error severity.
+func (r *Result) Errors() int {
+	n := 0
+
+	for _, d := range r.Diagnostics {
+		if d.Severity == severityError {
+			n++
+		}
+	}
+
+	return n
+}
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+	severityHint    = "hint"
+)
+
+func severityName(severity protocol.DiagnosticSeverity) string {
+	switch severity {
+	case protocol.SeverityWarning:
+		return severityWarning
+	case protocol.SeverityInformation:
+		return severityInfo
+	case protocol.SeverityHint:
+		return severityHint
+	default:
+		return severityError
+	}
+}
+
+// Run checks all CUE files matching the patterns.
+//
+// Like for the cue command, a pattern is a file, a directory, or a directory followed by /... to include all directories below it.
+func Run(ctx context.Context, config *lsp.Config, patterns []string) (*Result, error) {
+	files, err := Files(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := lsp.CreateHeadlessServerWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// All files are opened before collecting diagnostics, so every package is compiled with the same overlay.
+	uris := make([]protocol.DocumentURI, 0, len(files))
+
+	for _, path := range files {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		uri := cache.URIFromPath(path)
+
+		err = s.DidOpen(ctx, &protocol.DidOpenTextDocumentParams{
+			TextDocument: protocol.TextDocumentItem{
+				URI:        uri,
+				LanguageID: "cue",
+				Text:       string(content),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		uris = append(uris, uri)
+	}
+
+	result := &Result{Files: len(files)}
+
+	// Errors without a position, like those of loading the package, are reported for all files of the package.
+	noPos := cache.URIFromPath("")
+
+	for i, uri := range uris {
+		all, err := s.GetAllDiagnostics(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, diagnostics := range all {
+			if diagnostics == nil || (diagnostics.URI != uri && diagnostics.URI != noPos) {
+				continue
+			}
+
+			for _, d := range diagnostics.Diagnostics {
+				code, _ := d.Code.(string)
+
+				severity := severityName(d.Severity)
+				if diagnostics.URI == noPos {
+					severity = severityError
+				}
+
+				result.Diagnostics = append(result.Diagnostics, Diagnostic{
+					File:     displayPath(files[i]),
+					Range:    d.Range,
+					Severity: severity,
+					Source:   d.Source,
+					Code:     code,
+					Message:  d.Message,
+				})
+			}
+		}
+	}
+
+	result.sort()
+
+	return result, nil
+}
+
+// Lint runs the lint rules on packages that have already been loaded, without a server.
+//
+// The configuration sets the severity of the rules. The packages are only evaluated if an enabled rule needs it.
+func Lint(config *lsp.Config, insts []*build.Instance) (*Result, error) {
+	lintConfig, err := config.CUE.LintConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+
+	for _, inst := range insts {
+		result.Files += len(inst.Files)
+
+		// Imported packages are compiled as well, so references into them are resolved.
+		pkg, _ := asg.NewCompiler(nil).CompileInstance(inst)
+		if pkg == nil {
+			continue
+		}
+
+		var value *cue.Instance
+
+		if lintConfig.NeedsInstance() {
+			// Rules that need the evaluated package are skipped if it does not build.
+			if value = cue.Build([]*build.Instance{inst})[0]; value.Err != nil {
+				value = nil
+			}
+		}
+
+		for _, p := range lint.Run(pkg, value, lintConfig) {
+			start, end := p.Start.Position(), p.End.Position()
+
+			result.Diagnostics = append(result.Diagnostics, Diagnostic{
+				File: displayPath(start.Filename),
+				Range: protocol.Range{
+					Start: protocol.Position{Line: float64(start.Line - 1), Character: float64(start.Column - 1)},
+					End:   protocol.Position{Line: float64(end.Line - 1), Character: float64(end.Column - 1)},
+				},
+				Severity: p.Severity.String(),
+				Source:   lint.Source,
+				Code:     p.Rule.ID,
+				Message:  p.Message,
+			})
+		}
+	}
+
+	result.sort()
+
+	return result, nil
+}
+
+// displayPath returns the path of a file relative to the working directory, if it is below it.
+func displayPath(path string) string {
+	wd, _ := os.Getwd()
+
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+
+	return filepath.ToSlash(path)
+}
+
+// sort sorts the diagnostics by their file and position.
+func (r *Result) sort() {
+	sort.SliceStable(r.Diagnostics, func(i, j int) bool {
+		a, b := r.Diagnostics[i], r.Diagnostics[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+
+		if a.Range.Start.Line != b.Range.Start.Line {
+			return a.Range.Start.Line < b.Range.Start.Line
+		}
+
+		return a.Range.Start.Character < b.Range.Start.Character
+	})
+}
+
+// Files returns the absolute paths of the CUE files matching the patterns, sorted and without duplicates.
+//
+// Directories below cue.mod and directories starting with . or _ are skipped when walking a directory tree.
+func Files(patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+
+	seen := map[string]bool{}
+
+	var files []string
+
+	add := func(path string) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+
+		if !seen[abs] {
+			seen[abs] = true
+			files = append(files, abs)
+		}
+
+		return nil
+	}
+
+	for _, pattern := range patterns {
+		recursive := false
+		if pattern == "..." || strings.HasSuffix(pattern, "/...") {
+			recursive = true
+			pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, "..."), "/")
+
+			if pattern == "" {
+				pattern = "."
+			}
+		}
+
+		info, err := os.Stat(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			if recursive {
+				return nil, fmt.Errorf("%s is not a directory", pattern)
+			}
+
+			if err := add(pattern); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		err = filepath.Walk(pattern, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if path == pattern {
+					return nil
+				}
+
+				name := info.Name()
+				if !recursive || name == "cue.mod" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if filepath.Ext(path) != ".cue" {
+				return nil
+			}
+
+			return add(path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// ValidateFormat returns an error if the output format is not supported.
+func ValidateFormat(format string) error {
+	switch format {
+	case TextFormat, JSONFormat, SARIFFormat:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, expected %s, %s or %s", format, TextFormat, JSONFormat, SARIFFormat)
+	}
+}
+
+// Write writes a result in one of the supported formats.
+func Write(w io.Writer, format string, result *Result) error {
+	switch format {
+	case JSONFormat:
+		return writeJSON(w, result)
+	case SARIFFormat:
+		return writeSARIF(w, result)
+	case TextFormat:
+		return writeText(w, result)
+	default:
+		return ValidateFormat(format)
+	}
+}
+
+func writeText(w io.Writer, result *Result) error {
+	for _, d := range result.Diagnostics {
+		message := d.Message
+		if d.Code != "" {
+			message += fmt.Sprintf(" (%s)", d.Code)
+		}
+
+		// Lines and columns are 1 based, like in the errors of the cue command.
+		_, err := fmt.Fprintf(w, "%s:%d:%d: %s: %s\n",
+			d.File, int(d.Range.Start.Line)+1, int(d.Range.Start.Character)+1, d.Severity, message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeJSON(w io.Writer, result *Result) error {
+	enc := json.NewEncoder(w)
+
+	for _, d := range result.Diagnostics {
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/internal/lsp"
+	"cuelang.org/go/cue/load"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "cue-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.cue":                  "",
+		"a.json":                 "",
+		"sub/b.cue":              "",
+		"sub/deeper/c.cue":       "",
+		"_skipped/d.cue":         "",
+		".hidden/e.cue":          "",
+		"cue.mod/pkg/x/f.cue":    "",
+		"cue.mod/module.cue":     "",
+		"sub/deeper/_ignored.go": "",
+	})
+
+	tests := []struct {
+		patterns []string
+		want     []string
+	}{
+		{[]string{dir}, []string{"a.cue"}},
+		{[]string{dir + "/..."}, []string{"a.cue", "sub/b.cue", "sub/deeper/c.cue"}},
+		{[]string{dir + "/sub/b.cue", dir + "/sub/..."}, []string{"sub/b.cue", "sub/deeper/c.cue"}},
+	}
+
+	for _, tc := range tests {
+		files, err := Files(tc.patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, f := range files {
+			rel, _ := filepath.Rel(dir, f)
+			got = append(got, filepath.ToSlash(rel))
+		}
+
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%v: got %v, want %v", tc.patterns, got, tc.want)
+		}
+	}
+
+	if _, err := Files([]string{filepath.Join(dir, "a.cue") + "/..."}); err == nil {
+		t.Error("expected an error for a file followed by /...")
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a/a.cue": "package a\n\na: b\n",
+		"b/b.cue": "package b\n\nb: 1\n",
+	})
+
+	result, err := Run(context.Background(), &lsp.Config{}, []string{dir + "/..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Files != 2 || result.Errors() != 1 {
+		t.Fatalf("expected 1 error in 2 files, got %d in %d: %v", result.Errors(), result.Files, result.Diagnostics)
+	}
+
+	d := result.Diagnostics[0]
+	if !strings.HasSuffix(d.File, "a/a.cue") || d.Message != "unresolved reference b" || d.Range.Start.Line != 2 {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+
+	var text bytes.Buffer
+	if err := Write(&text, TextFormat, result); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(text.String(), "a/a.cue:3:4: error: unresolved reference b\n") {
+		t.Errorf("unexpected text output %q", text.String())
+	}
+
+	var lines bytes.Buffer
+	if err := Write(&lines, JSONFormat, result); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Diagnostic
+	if err := json.Unmarshal(lines.Bytes(), &decoded); err != nil || decoded != d {
+		t.Errorf("unexpected JSON output %q: %v", lines.String(), err)
+	}
+
+	var sarif bytes.Buffer
+	if err := Write(&sarif, SARIFFormat, result); err != nil {
+		t.Fatal(err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(sarif.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 {
+		t.Fatalf("unexpected SARIF output %s", sarif.String())
+	}
+
+	region := log.Runs[0].Results[0].Locations[0].PhysicalLocation.Region
+	if log.Runs[0].Results[0].Level != "error" || region.StartLine != 3 || region.StartColumn != 4 {
+		t.Errorf("unexpected SARIF result %+v", log.Runs[0].Results[0])
+	}
+
+	if err := Write(&sarif, "xml", result); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestLint(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"cue.mod/module.cue": "module: \"example.com/m\"\n",
+		"a/a.cue":            "package a\n\nimport \"example.com/m/b\"\n\n_unused: 1\n// cue-lint:ignore\n_ignored: 2\nx: b.y\nz: [1, 1]\n",
+		"b/b.cue":            "package b\n\ny: 1\n",
+	})
+
+	insts := load.Instances([]string{"./a"}, &load.Config{Dir: dir})
+
+	config := &lsp.Config{CUE: lsp.Settings{Lint: map[string]string{"duplicate-element": "error"}}}
+
+	result, err := Lint(config, insts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range result.Diagnostics {
+		got = append(got, fmt.Sprintf("%s:%v:%s:%s", filepath.Base(d.File), d.Range.Start.Line, d.Severity, d.Code))
+	}
+
+	want := "a.cue:4:warning:unused-hidden,a.cue:8:error:duplicate-element"
+	if strings.Join(got, ",") != want || result.Errors() != 1 || result.Files != 1 {
+		t.Errorf("got %v in %d files, want %s", got, result.Files, want)
+	}
+
+	config.CUE.Lint["shadow"] = "fatal"
+
+	if _, err := Lint(config, insts); err == nil {
+		t.Error("expected an error for an invalid severity")
+	}
+}
+
+func TestRunMixedPackages(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.cue": "package a\n\nx: y\n",
+		"b.cue": "package b\n",
+	})
+
+	result, err := Run(context.Background(), &lsp.Config{}, []string{dir + "/..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The error of loading the package has no position, so it is reported for each file.
+	var got []string
+	for _, d := range result.Diagnostics {
+		if d.Severity == severityError && strings.HasPrefix(d.Message, "found packages") {
+			got = append(got, filepath.Base(d.File))
+		}
+	}
+
+	if strings.Join(got, ",") != "a.cue,b.cue" || result.Errors() == 0 {
+		t.Errorf("expected the load error for both files, got %v", result.Diagnostics)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+	"strings"
+
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/diff"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/diff/myers"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/span"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/internal/fix"
+)
+
+// CodeAction is required by the protocol.Server interface
+//
+// Deprecated syntax is rewritten like cue fmt does: each deprecation diagnostic has a quick fix,
+// and source actions fix all deprecated constructs of the file or package.
+func (s *server) CodeAction(ctx context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
+	uri := params.TextDocument.URI
+
+	doc, err := s.workspace.GetDocument(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := doc.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.GetLanguageID() != "cue" {
+		return nil, nil
+	}
+
+	path := cache.PathFromURI(uri)
+	settings := s.workspace.settingsFor(uri).Format
+	opts := settings.options()
+
+	// Files that do not parse cannot be fixed.
+	fixed, err := fixSource(path, content, opts)
+	if err != nil || fixed == content {
+		return nil, nil
+	}
+
+	var actions []protocol.CodeAction
+
+	if wantsKind(params.Context.Only, protocol.QuickFix) {
+		edits := myers.ComputeEdits(span.URIFromURI(string(uri)), content, fixed)
+
+		for _, d := range params.Context.Diagnostics {
+			if !isDeprecation(d) {
+				continue
+			}
+
+			hunk := fixHunk(edits, int(d.Range.Start.Line)+1, int(d.Range.End.Line)+1)
+			if len(hunk) == 0 {
+				continue
+			}
+
+			actions = append(actions, protocol.CodeAction{
+				Title:       "Rewrite deprecated syntax",
+				Kind:        protocol.QuickFix,
+				Diagnostics: []protocol.Diagnostic{d},
+				IsPreferred: true,
+				Edit: protocol.WorkspaceEdit{
+					Changes: map[string][]protocol.TextEdit{string(uri): hunk},
+				},
+			})
+		}
+	}
+
+	if !wantsKind(params.Context.Only, protocol.SourceFixAll) || cancelled(ctx) != nil {
+		return actions, nil
+	}
+
+	if len(cache.Deprecations(path, content)) > 0 {
+		edit := newWorkspaceEdit()
+		if err := s.replaceFile(edit, path, fixed); err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, protocol.CodeAction{
+			Title: "Fix deprecated syntax in file",
+			Kind:  protocol.SourceFixAll,
+			Edit:  *edit,
+		})
+	}
+
+	if edit, others := s.fixPackage(doc, path, opts); others {
+		actions = append(actions, protocol.CodeAction{
+			Title: "Fix deprecated syntax in package",
+			Kind:  protocol.SourceFixAll,
+			Edit:  *edit,
+		})
+	}
+
+	return actions, nil
+}
+
+// fixPackage returns an edit fixing the deprecated constructs of all files of the package of a document.
+//
+// others is set if files other than the document, which has the given path, have deprecated constructs.
+func (s *server) fixPackage(doc *cache.DocumentHandle, path string, opts []format.Option) (edit *protocol.WorkspaceEdit, others bool) {
+	edit = newWorkspaceEdit()
+
+	unlock := cache.LockEvaluator()
+	files, err := doc.PackageFiles()
+	unlock()
+
+	if err != nil {
+		return edit, false
+	}
+
+	for _, filename := range files {
+		content, err := s.workspace.ReadFile(filename)
+		if err != nil || len(cache.Deprecations(filename, content)) == 0 {
+			continue
+		}
+
+		fixed, err := fixSource(filename, content, opts)
+		if err != nil {
+			continue
+		}
+
+		if err := s.replaceFile(edit, filename, fixed); err != nil {
+			continue
+		}
+
+		others = others || filename != path
+	}
+
+	return edit, others
+}
+
+// fixSource rewrites the deprecated constructs of a CUE file and formats it, like cue fmt does.
+func fixSource(filename string, content string, opts []format.Option) (string, error) {
+	file, err := parser.ParseFile(filename, content, parser.ParseComments)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := format.Node(fix.File(file), opts...)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// isDeprecation reports whether a diagnostic reports deprecated syntax.
+func isDeprecation(d protocol.Diagnostic) bool {
+	for _, tag := range d.Tags {
+		if tag == protocol.Deprecated {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wantsKind reports whether a client asked for code actions of a kind.
+func wantsKind(only []protocol.CodeActionKind, kind protocol.CodeActionKind) bool {
+	if len(only) == 0 {
+		return true
+	}
+
+	for _, k := range only {
+		if k == kind || strings.HasPrefix(string(kind), string(k)+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fixHunk returns the edits of a line diff that belong to the run of adjacent edits touching the given lines.
+//
+// Lines are 1 based, like in the edits of the diff.
+func fixHunk(edits []diff.TextEdit, start int, end int) []protocol.TextEdit {
+	var (
+		hunk      []diff.TextEdit
+		hunkStart int
+		hunkEnd   int
+	)
+
+	flush := func() []protocol.TextEdit {
+		// Hunks that only insert lines touch the line they insert in front of.
+		last := hunkEnd
+		if last == hunkStart {
+			last++
+		}
+
+		if len(hunk) == 0 || hunkStart > end || start >= last {
+			return nil
+		}
+
+		ret := make([]protocol.TextEdit, 0, len(hunk))
+		for _, e := range hunk {
+			ret = append(ret, protocol.TextEdit{
+				Range: protocol.Range{
+					Start: protocol.Position{Line: float64(e.Span.Start().Line() - 1)},
+					End:   protocol.Position{Line: float64(e.Span.End().Line() - 1)},
+				},
+				NewText: e.NewText,
+			})
+		}
+
+		return ret
+	}
+
+	for _, e := range edits {
+		if len(hunk) > 0 && e.Span.Start().Line() > hunkEnd {
+			if ret := flush(); ret != nil {
+				return ret
+			}
+
+			hunk = nil
+		}
+
+		if len(hunk) == 0 {
+			hunkStart = e.Span.Start().Line()
+		}
+
+		hunk = append(hunk, e)
+		hunkEnd = e.Span.End().Line()
+	}
+
+	return flush()
+}
@@ -1,36 +1,150 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
 package lsp
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/internal/lsp/cache"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
 )
 
 // CodeLens is required by the protocol.Server interface
+//
+// Lenses to export and vet are shown above the package clause and every top-level field.
+// In _tool.cue files, a lens to run each command is shown instead.
+//
+// Since tool files are not part of the compiled package, the lenses are created from the syntax of the document alone.
 func (s *server) CodeLens(ctx context.Context, params *protocol.CodeLensParams) ([]protocol.CodeLens, error) {
-	// doc, err := s.cache.GetDocument(params.TextDocument.URI)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// // _, _, rootCursor, err := doc.GetCompiled()
-	// // if err != nil {
-	// // 	return nil, err
-	// // }
-
-	// // root := []protocol.CodeLens{}
-
-	// // for iter, child := rootCursor.Children(); child != nil; child = iter.Next() {
-	// // 	root = append(root, protocol.CodeLens{
-	// // 		Range: rangeOfNode(child, doc),
-	// // 		Command: protocol.Command{
-	// // 			Title:     "Copy JSON",
-	// // 			Command:   "copy",
-	// // 			Arguments: []interface{}{"todo"},
-	// // 		},
-	// // 	})
-	// // }
-
-	// return root, nil
-	return nil, nil
+	uri := params.TextDocument.URI
+
+	doc, err := s.workspace.GetDocument(uri)
+	if err != nil {
+		return nil, nil
+	}
+
+	content, err := doc.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	path := cache.PathFromURI(uri)
+
+	// There is no point in showing lenses for a document we cannot parse.
+	file, err := parser.ParseFile(path, content)
+	if err != nil {
+		return nil, nil
+	}
+
+	lenses := &codeLenses{doc: doc}
+
+	if cache.IsToolFile(path) {
+		lenses.toolCommands(uri, file)
+		return lenses.lenses, lenses.err
+	}
+
+	for _, decl := range file.Decls {
+		switch n := decl.(type) {
+		case *ast.Package:
+			lenses.exportAndVet(n, commandArgs{URI: uri})
+		case *ast.Field:
+			label, isIdent, err := ast.LabelName(n.Label)
+			if err != nil || n.Token == token.ISA || (isIdent && (strings.HasPrefix(label, "_") || strings.HasPrefix(label, "#"))) {
+				// Definitions and hidden fields are not exported.
+				continue
+			}
+
+			lenses.exportAndVet(n, commandArgs{URI: uri, Path: []string{label}})
+		}
+	}
+
+	return lenses.lenses, lenses.err
+}
+
+// codeLenses collects the lenses for a document, remembering the first error.
+type codeLenses struct {
+	doc    *cache.DocumentHandle
+	lenses []protocol.CodeLens
+	err    error
+}
+
+func (l *codeLenses) add(n ast.Node, title string, command string, args commandArgs) {
+	if l.err != nil {
+		return
+	}
+
+	start, err := l.doc.PosToProtocolPosition(n.Pos())
+	if err != nil {
+		l.err = err
+		return
+	}
+
+	l.lenses = append(l.lenses, protocol.CodeLens{
+		// Lenses are shown above the first line of their range anyways.
+		Range: protocol.Range{Start: start, End: start},
+		Command: protocol.Command{
+			Title:     title,
+			Command:   command,
+			Arguments: []interface{}{args},
+		},
+	})
+}
+
+func (l *codeLenses) exportAndVet(n ast.Node, args commandArgs) {
+	jsonArgs, yamlArgs := args, args
+	jsonArgs.Format = "json"
+	yamlArgs.Format = "yaml"
+
+	l.add(n, "export JSON", exportCommand, jsonArgs)
+	l.add(n, "export YAML", exportCommand, yamlArgs)
+	l.add(n, "vet", vetCommand, args)
+}
+
+// toolCommands adds a lens for every command defined in the `command:` field of a tool file.
+func (l *codeLenses) toolCommands(uri protocol.DocumentURI, file *ast.File) {
+	for _, decl := range file.Decls {
+		field, ok := decl.(*ast.Field)
+		if !ok {
+			continue
+		}
+
+		if label, _, _ := ast.LabelName(field.Label); label != "command" {
+			continue
+		}
+
+		commands, ok := field.Value.(*ast.StructLit)
+		if !ok {
+			continue
+		}
+
+		for _, elt := range commands.Elts {
+			cmd, ok := elt.(*ast.Field)
+			if !ok {
+				continue
+			}
+
+			name, _, err := ast.LabelName(cmd.Label)
+			if err != nil {
+				continue
+			}
+
+			l.add(cmd, fmt.Sprintf("run command %s", name), runCommand, commandArgs{URI: uri, Name: name})
+		}
+	}
 }
@@ -0,0 +1,287 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/token"
+	"cuelang.org/go/internal"
+	"cuelang.org/go/internal/encoding"
+	"cuelang.org/go/internal/filetypes"
+)
+
+// The commands understood by ExecuteCommand.
+const (
+	exportCommand     = "cue.export"
+	evalCommand       = "cue.eval"
+	defCommand        = "cue.def"
+	vetCommand        = "cue.vet"
+	runCommand        = "cue.cmd"
+	trimCommand       = "cue.trim"
+	fmtPackageCommand = "cue.fmtPackage"
+	importCommand     = "cue.import"
+)
+
+// serverCommands lists all commands, so they can be advertised to the client.
+// nolint: gochecknoglobals
+var serverCommands = []string{
+	exportCommand,
+	evalCommand,
+	defCommand,
+	vetCommand,
+	runCommand,
+	trimCommand,
+	fmtPackageCommand,
+	importCommand,
+}
+
+// commandArgs are the arguments of a command.
+//
+// Every command expects exactly one argument, which is decoded into this struct.
+type commandArgs struct {
+	// The document whose package the command should operate on.
+	// For cue.import this is the file to import, which does not need to be open.
+	URI protocol.DocumentURI `json:"uri"`
+	// Path to the field the command should operate on, the whole package if empty.
+	Path []string `json:"path,omitempty"`
+	// The output encoding, e.g. json or yaml.
+	// For cue.import this is the file type qualifier, e.g. openapi or jsonschema.
+	Format string `json:"format,omitempty"`
+	// Name of the tool command to run.
+	Name string `json:"name,omitempty"`
+	// Package name of imported files.
+	Package string `json:"package,omitempty"`
+	// Whether to simplify the output when formatting.
+	Simplify bool `json:"simplify,omitempty"`
+	// Whether cue.import may overwrite existing files.
+	Force bool `json:"force,omitempty"`
+}
+
+func parseCommandArgs(arguments []interface{}) (*commandArgs, error) {
+	if len(arguments) != 1 {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "expected exactly one argument, got %d", len(arguments))
+	}
+
+	// The argument has already been decoded into a generic map, so we need a round trip to get our struct.
+	data, err := json.Marshal(arguments[0])
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "invalid command argument: %v", err)
+	}
+
+	args := &commandArgs{}
+	if err := json.Unmarshal(data, args); err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "invalid command argument: %v", err)
+	}
+
+	return args, nil
+}
+
+// ExecuteCommand is required by the protocol.Server interface
+//
+// The output of a successful command is returned to the client, which decides how to present it.
+func (s *server) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
+	args, err := parseCommandArgs(params.Arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	var result string
+
+	if params.Command == importCommand {
+		// The imported file is usually not a CUE file, so it is not in the cache.
+		result, err = s.importFile(ctx, args)
+	} else {
+		result, err = s.documentCommand(ctx, params.Command, args)
+	}
+
+	if _, ok := err.(*jsonrpc2.Error); ok {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInternalError, "%s: %s", params.Command, errors.Details(err, nil))
+	}
+
+	return result, nil
+}
+
+// documentCommand executes a command that operates on the package of an open document.
+func (s *server) documentCommand(ctx context.Context, command string, args *commandArgs) (string, error) {
+	doc, err := s.workspace.GetDocument(args.URI)
+	if err != nil {
+		return "", err
+	}
+
+	switch command {
+	case exportCommand:
+		return exportValue(doc, args)
+	case evalCommand:
+		return evalValue(doc, args)
+	case defCommand:
+		return defValue(doc, args)
+	case vetCommand:
+		return vetValue(doc, args)
+	case runCommand:
+		return runToolCommand(ctx, doc, args)
+	case trimCommand:
+		return s.trimPackage(ctx, doc, args)
+	case fmtPackageCommand:
+		return s.formatPackage(ctx, doc, args)
+	default:
+		return "", jsonrpc2.NewErrorf(jsonrpc2.CodeMethodNotFound, "unknown command %q", command)
+	}
+}
+
+// commandValue builds the package of the document and looks up the path given in the arguments.
+//
+// The evaluator must be locked when calling this.
+func commandValue(doc *cache.DocumentHandle, args *commandArgs) (*cue.Instance, cue.Value, error) {
+	inst, err := doc.BuildInstance()
+	if err != nil {
+		return nil, cue.Value{}, err
+	}
+
+	v := inst.Value()
+	if len(args.Path) > 0 {
+		v = inst.Lookup(args.Path...)
+		if !v.Exists() {
+			return nil, cue.Value{}, errors.Newf(v.Pos(), "field %v not found", args.Path)
+		}
+	}
+
+	return inst, v, v.Err()
+}
+
+// exportValue exports the package or field using the same encoders as cue export.
+func exportValue(doc *cache.DocumentHandle, args *commandArgs) (string, error) {
+	format := build.Encoding(args.Format)
+	if format == "" {
+		format = build.JSON
+	}
+
+	return encodeValue(doc, args, format, &encoding.Config{Mode: filetypes.Export},
+		cue.Final(), cue.Concrete(true))
+}
+
+// evalValue prints the evaluated package or field like cue eval does.
+func evalValue(doc *cache.DocumentHandle, args *commandArgs) (string, error) {
+	cfg := &encoding.Config{
+		Mode:   filetypes.Eval,
+		Format: []format.Option{format.UseSpaces(4), format.TabIndent(false)},
+	}
+
+	if args.Simplify {
+		cfg.Format = append(cfg.Format, format.Simplify())
+	}
+
+	return encodeValue(doc, args, build.CUE, cfg,
+		cue.Final(), cue.Definitions(true), cue.Optional(true))
+}
+
+// defValue prints the definitions of the package or field like cue def does.
+func defValue(doc *cache.DocumentHandle, args *commandArgs) (string, error) {
+	return encodeValue(doc, args, build.CUE, &encoding.Config{Mode: filetypes.Def},
+		cue.Definitions(true), cue.Optional(true), cue.Attributes(true))
+}
+
+// encodeValue encodes the package or field with the given encoding.
+//
+// A whole package is encoded as instance, which lets the encoder decide what to
+// include; a single field is converted to syntax using the given options.
+// Like cue eval, the Eval mode always converts the value to syntax.
+func encodeValue(doc *cache.DocumentHandle, args *commandArgs, format build.Encoding, cfg *encoding.Config, syn ...cue.Option) (string, error) {
+	unlock := cache.LockEvaluator()
+	defer unlock()
+
+	inst, v, err := commandValue(doc, args)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+
+	cfg.Out = &buf
+
+	enc, err := encoding.NewEncoder(&build.File{
+		Filename: "-",
+		Encoding: format,
+	}, cfg)
+	if err != nil {
+		return "", err
+	}
+	defer enc.Close()
+
+	if len(args.Path) == 0 && cfg.Mode != filetypes.Eval {
+		err = enc.Encode(inst)
+	} else {
+		err = enc.EncodeFile(internal.ToFile(v.Syntax(syn...)))
+	}
+
+	return buf.String(), err
+}
+
+// vetValue validates the package or field the same way cue vet does.
+func vetValue(doc *cache.DocumentHandle, args *commandArgs) (string, error) {
+	unlock := cache.LockEvaluator()
+	defer unlock()
+
+	_, v, err := commandValue(doc, args)
+	if err != nil {
+		return "", err
+	}
+
+	opt := []cue.Option{
+		cue.Attributes(true),
+		cue.Definitions(true),
+		cue.Hidden(true),
+	}
+
+	if err := v.Validate(append(opt, cue.Concrete(true))...); err != nil {
+		if err := v.Validate(append(opt, cue.Concrete(false))...); err != nil {
+			return "", err
+		}
+
+		return "vet: value is valid, but incomplete", nil
+	}
+
+	return "vet: no errors", nil
+}
+
+// runToolCommand runs a command defined in a _tool.cue file of the package and returns its output.
+func runToolCommand(ctx context.Context, doc *cache.DocumentHandle, args *commandArgs) (string, error) {
+	if args.Name == "" {
+		return "", errors.New("no command name given")
+	}
+
+	unlock := cache.LockEvaluator()
+	defer unlock()
+
+	var out bytes.Buffer
+
+	if err := doc.RunToolCommand(ctx, args.Name, &out); err != nil {
+		return "", errors.Wrapf(err, token.NoPos, "cue cmd %s: %s", args.Name, out.String())
+	}
+
+	return out.String(), nil
+}
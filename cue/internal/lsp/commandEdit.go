@@ -0,0 +1,341 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/load"
+	"cuelang.org/go/cue/token"
+	"cuelang.org/go/internal/diff"
+	"cuelang.org/go/internal/encoding"
+	"cuelang.org/go/internal/filetypes"
+	"cuelang.org/go/tools/trim"
+)
+
+// trimPackage removes values that are implied by other values of the package, like cue trim does.
+func (s *server) trimPackage(ctx context.Context, doc *cache.DocumentHandle, args *commandArgs) (string, error) {
+	files, err := trimmedFiles(doc)
+	if err != nil {
+		return "", err
+	}
+
+	edit := newWorkspaceEdit()
+
+	for _, f := range files {
+		content, err := format.Node(f, s.formatOptions(args)...)
+		if err != nil {
+			return "", err
+		}
+
+		if err := s.replaceFile(edit, f.Filename, string(content)); err != nil {
+			return "", err
+		}
+	}
+
+	return s.applyEdit(ctx, "cue trim", edit)
+}
+
+// trimmedFiles returns the files of the package of the document with the implied values removed.
+func trimmedFiles(doc *cache.DocumentHandle) ([]*ast.File, error) {
+	unlock := cache.LockEvaluator()
+	defer unlock()
+
+	binst, err := doc.LoadInstance(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	inst, err := cache.Build(binst)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = trim.Files(binst.Files, inst, &trim.Config{}); err != nil {
+		return nil, err
+	}
+
+	// Like cue trim, refuse to apply the changes if they alter the package.
+	overlay := make(map[string]load.Source)
+	for _, f := range binst.Files {
+		overlay[f.Filename] = load.FromFile(f)
+	}
+
+	tbinst, err := doc.LoadInstance(overlay)
+	if err != nil {
+		return nil, err
+	}
+
+	tinst, err := cache.Build(tbinst)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind, _ := diff.Final.Diff(inst.Value(), tinst.Value()); kind != diff.Identity {
+		return nil, errors.New("output differs after trimming, aborting")
+	}
+
+	return binst.Files, nil
+}
+
+// formatPackage formats all files of the package, including test and tool files, like cue fmt does.
+func (s *server) formatPackage(ctx context.Context, doc *cache.DocumentHandle, args *commandArgs) (string, error) {
+	unlock := cache.LockEvaluator()
+	files, err := doc.PackageFiles()
+	unlock()
+
+	if err != nil {
+		return "", err
+	}
+
+	edit := newWorkspaceEdit()
+
+	for _, filename := range files {
+		content, err := s.workspace.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+
+		formatted, err := format.Source([]byte(content), s.formatOptions(args)...)
+		if err != nil {
+			return "", err
+		}
+
+		if err := s.replaceFile(edit, filename, string(formatted)); err != nil {
+			return "", err
+		}
+	}
+
+	return s.applyEdit(ctx, "cue fmt", edit)
+}
+
+// importFile converts a JSON, YAML, OpenAPI, JSON Schema or Protobuf file to CUE, like cue import does.
+//
+// The result is written to a CUE file next to the imported file.
+func (s *server) importFile(ctx context.Context, args *commandArgs) (string, error) {
+	path := cache.PathFromURI(args.URI)
+	if path == "" {
+		return "", errors.Newf(token.NoPos, "invalid uri %q", args.URI)
+	}
+
+	spec := path
+	if args.Format != "" {
+		spec = args.Format + ":" + path
+	}
+
+	content, err := s.workspace.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	files, err := decodeFile(spec, content, &encoding.Config{
+		PkgName:   args.Package,
+		ProtoPath: []string{filepath.Dir(path)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(files) != 1 {
+		return "", errors.Newf(token.NoPos, "%s contains %d values, only single values can be imported", path, len(files))
+	}
+
+	f := files[0]
+	if args.Package != "" && f.PackageName() == "" {
+		f.Decls = append([]ast.Decl{&ast.Package{Name: ast.NewIdent(args.Package)}}, f.Decls...)
+	}
+
+	formatted, err := format.Node(f, format.Simplify())
+	if err != nil {
+		return "", err
+	}
+
+	target := strings.TrimSuffix(path, filepath.Ext(path)) + ".cue"
+
+	switch _, err := s.workspace.ReadFile(target); {
+	case os.IsNotExist(err):
+	case err != nil:
+		return "", err
+	case !args.Force:
+		return "", errors.Newf(token.NoPos, "%s already exists, use force to overwrite it", target)
+	}
+
+	if !s.createFilesSupported {
+		return "", errors.Newf(token.NoPos, "the client cannot create %s, it does not support creating files with workspace edits", target)
+	}
+
+	// The client creates the file, which empties it if it exists, and inserts the content.
+	uri := cache.URIFromPath(target)
+	edit := &protocol.WorkspaceEdit{
+		DocumentChanges: []interface{}{
+			protocol.CreateFile{
+				Kind:    string(protocol.Create),
+				URI:     uri,
+				Options: protocol.CreateFileOptions{Overwrite: args.Force},
+			},
+			protocol.TextDocumentEdit{
+				TextDocument: protocol.OptionalVersionedTextDocumentIdentifier{
+					TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+				},
+				Edits: []protocol.TextEdit{{NewText: string(formatted)}},
+			},
+		},
+	}
+
+	if _, err := s.applyEdit(ctx, "cue import", edit); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("import: created %s", target), nil
+}
+
+// decodeFile converts the content of a file to CUE, which yields a file for every value it contains.
+//
+// The spec selects the decoder like the arguments of cue import, as in openapi:api.yaml.
+func decodeFile(spec string, content string, cfg *encoding.Config) ([]*ast.File, error) {
+	// File types and schemas like OpenAPI are interpreted with the evaluator.
+	unlock := cache.LockEvaluator()
+	defer unlock()
+
+	file, err := filetypes.ParseFile(spec, filetypes.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	file.Source = content
+
+	d := encoding.NewDecoder(file, cfg)
+	defer d.Close()
+
+	var files []*ast.File
+	for ; !d.Done(); d.Next() {
+		files = append(files, d.File())
+	}
+
+	return files, d.Err()
+}
+
+// formatOptions returns the configured formatter options, simplifying if the arguments ask for it.
+func (s *server) formatOptions(args *commandArgs) []format.Option {
+	settings := s.workspace.settingsFor(args.URI)
+	if args.Simplify {
+		settings.Format.Simplify = true
+	}
+
+	return settings.Format.options()
+}
+
+func newWorkspaceEdit() *protocol.WorkspaceEdit {
+	return &protocol.WorkspaceEdit{
+		Changes: make(map[string][]protocol.TextEdit),
+	}
+}
+
+// replaceFile adds an edit replacing the whole content of a file, if the content changed.
+func (s *server) replaceFile(edit *protocol.WorkspaceEdit, path string, content string) error {
+	old, err := s.workspace.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if old == content {
+		return nil
+	}
+
+	edit.Changes[string(cache.URIFromPath(path))] = []protocol.TextEdit{{
+		Range: protocol.Range{
+			End: endPosition(old),
+		},
+		NewText: content,
+	}}
+
+	return nil
+}
+
+// canCreateFiles reports whether a client can create files with the document changes of workspace edits.
+func canCreateFiles(capabilities protocol.WorkspaceEditClientCapabilities) bool {
+	if !capabilities.DocumentChanges {
+		return false
+	}
+
+	for _, kind := range capabilities.ResourceOperations {
+		if kind == protocol.Create {
+			return true
+		}
+	}
+
+	return false
+}
+
+// endPosition returns the position after the last character of a text.
+func endPosition(content string) protocol.Position {
+	lastLine := content[strings.LastIndex(content, "\n")+1:]
+
+	return protocol.Position{
+		Line:      float64(strings.Count(content, "\n")),
+		Character: float64(len(utf16.Encode([]rune(lastLine)))),
+	}
+}
+
+// applyEdit asks the client to apply a workspace edit and returns a summary for the user.
+func (s *server) applyEdit(ctx context.Context, label string, edit *protocol.WorkspaceEdit) (string, error) {
+	if len(edit.Changes) == 0 && len(edit.DocumentChanges) == 0 {
+		return fmt.Sprintf("%s: no changes", label), nil
+	}
+
+	resp, err := s.client.ApplyEdit(ctx, &protocol.ApplyWorkspaceEditParams{
+		Label: label,
+		Edit:  *edit,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// The headless client does not report anything back.
+	if resp != nil && !resp.Applied {
+		return "", errors.Newf(token.NoPos, "%s: client did not apply the changes: %s", label, resp.FailureReason)
+	}
+
+	return fmt.Sprintf("%s: changed %d files", label, editedFiles(edit)), nil
+}
+
+// editedFiles returns the number of files a workspace edit creates or changes.
+func editedFiles(edit *protocol.WorkspaceEdit) int {
+	files := map[string]bool{}
+
+	for uri := range edit.Changes {
+		files[uri] = true
+	}
+
+	for _, change := range edit.DocumentChanges {
+		switch c := change.(type) {
+		case protocol.CreateFile:
+			files[string(c.URI)] = true
+		case protocol.TextDocumentEdit:
+			files[string(c.TextDocument.URI)] = true
+		}
+	}
+
+	return len(files)
+}
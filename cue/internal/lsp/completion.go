@@ -17,7 +17,6 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"strings"
 
 	"cuelang.org/go/cue/internal/adt"
 	"cuelang.org/go/cue/internal/lsp/asg"
@@ -30,9 +29,9 @@ import (
 func (s *server) Completion(ctx context.Context, params *protocol.CompletionParams) (ret *protocol.CompletionList, err error) {
 	posParams := params.TextDocumentPositionParams
 	//posParams.Position.Character-- // We need one char less to get correct token
-	location, err := s.cache.Find(&posParams)
+	location, err := s.workspace.Find(ctx, &posParams)
 	if err != nil {
-		return nil, nil
+		return nil, cancelled(ctx)
 	}
 
 	ret = &protocol.CompletionList{}
@@ -40,6 +39,16 @@ func (s *server) Completion(ctx context.Context, params *protocol.CompletionPara
 
 	completions := &ret.Items
 
+	// Attributes are opaque to the asg, so they are completed from the text.
+	if content, err := location.Doc.GetContent(); err == nil {
+		if attr, ok := attributeBefore(content, location.Pos.Offset()); ok {
+			s.completeAttribute(ctx, completions, location, content, attr)
+			s.storeResolvers(ctx, params.TextDocument.URI, ret.Items)
+
+			return ret, nil
+		}
+	}
+
 	switch n := location.Node.(type) {
 	case *asg.Reference:
 		start := n.Referenced
@@ -51,6 +60,9 @@ func (s *server) Completion(ctx context.Context, params *protocol.CompletionPara
 		if err = s.completeReference(ctx, completions, start); err != nil {
 			return
 		}
+		if n.Referenced == nil {
+			s.completeUnimported(ctx, completions, location)
+		}
 	case *asg.Decl:
 		s.completeDecls(ctx, completions, location)
 	// case *adt.SelectorExpr:
@@ -72,34 +84,37 @@ func (s *server) Completion(ctx context.Context, params *protocol.CompletionPara
 		}
 	}
 
+	s.completeTaskFields(ctx, completions, location)
+	s.completeKeywords(ctx, completions, location)
+
+	for i := range ret.Items {
+		if ret.Items[i].SortText == "" {
+			ret.Items[i].SortText = inScopeSortPrefix + ret.Items[i].Label
+		}
+	}
+
+	// Documentation is only computed when the client resolves an item, as are snippets if the client supports it.
+	s.storeResolvers(ctx, params.TextDocument.URI, ret.Items)
+
 	return //nolint: nakedret
 }
 
 func (s *server) completeBuiltin(ctx context.Context, completions *[]protocol.CompletionItem, b *asg.Builtin, prefix string) {
-	name := b.Name
-	if prefix != "" {
-		name = prefix + "." + name
-	}
-	insert := b.Name
-	if b.IsFunction {
-		insert += "("
-		argPlc := []string{}
-		for idx, arg := range b.Args {
-			argPlc = append(argPlc, fmt.Sprintf("${%d:%s}", idx+1, arg.String()))
-		}
-		insert += strings.Join(argPlc, ", ")
-		insert += ")"
+	item := protocol.CompletionItem{
+		Label:      b.Name,
+		Kind:       protocol.ClassCompletion,
+		InsertText: b.Name,
 	}
-	*completions = append(*completions, protocol.CompletionItem{
-		Label: b.Name,
-		Documentation: protocol.MarkupContent{
+
+	*completions = append(*completions, lazyEdits(item, func(ctx context.Context, item *protocol.CompletionItem) {
+		item.Documentation = protocol.MarkupContent{
 			Kind:  protocol.Markdown,
 			Value: b.Comment,
-		},
-		Kind:             protocol.ClassCompletion,
-		InsertText:       insert,
-		InsertTextFormat: protocol.SnippetTextFormat,
-	})
+		}
+	}, func(ctx context.Context, item *protocol.CompletionItem) {
+		item.InsertText = builtinSnippet(b)
+		item.InsertTextFormat = protocol.SnippetTextFormat
+	}))
 }
 
 func (s *server) completeBuiltins(ctx context.Context, completions *[]protocol.CompletionItem) {
@@ -111,14 +126,18 @@ func (s *server) completeBuiltins(ctx context.Context, completions *[]protocol.C
 		// for _, b := range pkg.Builtins {
 		// 	s.completeBuiltin(ctx, completions, b, pkg.Name)
 		// }
-		*completions = append(*completions, protocol.CompletionItem{
+		pkg := pkg
+		item := protocol.CompletionItem{
 			Label: pkg.Name,
-			Documentation: protocol.MarkupContent{
+			Kind:  protocol.ClassCompletion,
+		}
+
+		*completions = append(*completions, lazy(item, func(ctx context.Context, item *protocol.CompletionItem) {
+			item.Documentation = protocol.MarkupContent{
 				Kind:  protocol.Markdown,
 				Value: fmt.Sprintf("```cue\npackage %s (\"%s\")\n```\n%s", pkg.Name, pkg.DisplayPath, pkg.Comment),
-			},
-			Kind: protocol.ClassCompletion,
-		})
+			}
+		}))
 	}
 }
 
@@ -185,31 +204,19 @@ func (s *server) completeReference(ctx context.Context, completions *[]protocol.
 }
 
 func (s *server) completePackage(ctx context.Context, completions *[]protocol.CompletionItem, pkg *asg.Package, name string) {
-	var docBuf bytes.Buffer
-	s.nodeDocMarkdown(ctx, nil, pkg, &docBuf)
 	item := protocol.CompletionItem{
 		Label: name,
-		Documentation: protocol.MarkupContent{
-			Kind:  protocol.Markdown,
-			Value: docBuf.String(),
-		},
 	}
 
-	*completions = append(*completions, item)
+	*completions = append(*completions, lazy(item, s.resolveDocumentation(pkg)))
 }
 
 func (s *server) completeDecl(ctx context.Context, completions *[]protocol.CompletionItem, decl *asg.Decl) {
-	var docBuf bytes.Buffer
-	s.nodeDocMarkdown(ctx, nil, decl, &docBuf)
 	item := protocol.CompletionItem{
 		Label: decl.LabelName,
-		Documentation: protocol.MarkupContent{
-			Kind:  protocol.Markdown,
-			Value: docBuf.String(),
-		},
 	}
 
-	*completions = append(*completions, item)
+	*completions = append(*completions, lazy(item, s.resolveDocumentation(decl)))
 }
 
 func (s *server) completeField(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, c *cache.ADTCursor, label string) {
@@ -73,31 +73,27 @@ func asgToAst(node asg.Node) ast.Node {
 }
 
 func (s *server) completeFieldDecl(ctx context.Context, completions *[]protocol.CompletionItem, decl *asg.Decl, val asg.Node, topLevel bool) {
-	var docBuf bytes.Buffer
-	s.nodeDocMarkdown(ctx, nil, decl, &docBuf)
-	var snippet bytes.Buffer
-	if topLevel {
-		snippet.WriteString(fmt.Sprintf("${1:%sInst} : %s & {\n\t$0\n}", decl.LabelName, decl.LabelName))
-	} else {
-		snippet.WriteString(fmt.Sprintf("%s : ${1:", decl.LabelName))
-		if a := asgToAst(val); a != nil {
-			b, _ := format.Node(a, format.Simplify())
-			snippet.Write(b)
-		}
-		snippet.WriteString("}")
-	}
 	item := protocol.CompletionItem{
 		Label: decl.LabelName,
-		Documentation: protocol.MarkupContent{
-			Kind:  protocol.Markdown,
-			Value: docBuf.String(),
-		},
-		Kind:             protocol.FileCompletion,
-		InsertText:       snippet.String(),
-		InsertTextFormat: protocol.SnippetTextFormat,
+		Kind:  protocol.FileCompletion,
 	}
 
-	*completions = append(*completions, item)
+	*completions = append(*completions, lazyEdits(item, s.resolveDocumentation(decl), func(ctx context.Context, item *protocol.CompletionItem) {
+		var snippet bytes.Buffer
+		if topLevel {
+			snippet.WriteString(fmt.Sprintf("${1:%sInst} : %s & {\n\t$0\n}", decl.LabelName, decl.LabelName))
+		} else {
+			snippet.WriteString(fmt.Sprintf("%s : ${1:", decl.LabelName))
+			if a := asgToAst(val); a != nil {
+				b, _ := format.Node(a, format.Simplify())
+				snippet.Write(b)
+			}
+			snippet.WriteString("}")
+		}
+
+		item.InsertText = snippet.String()
+		item.InsertTextFormat = protocol.SnippetTextFormat
+	}))
 }
 
 type snippedBuilder struct {
@@ -0,0 +1,325 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/literal"
+	"cuelang.org/go/cue/parser"
+)
+
+// The sort text prefixes, so symbols in scope are listed before the ones that need an import.
+const (
+	inScopeSortPrefix    = "0"
+	autoImportSortPrefix = "1"
+)
+
+// completeUnimported offers the members of builtin packages and the definitions of module packages
+// that are not imported yet. Accepting an item adds the import.
+func (s *server) completeUnimported(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location) {
+	content, err := location.Doc.GetContent()
+	if err != nil {
+		return
+	}
+
+	file, err := parser.ParseFile(cache.PathFromURI(location.Doc.GetURI()), content, parser.ImportsOnly)
+	if err != nil {
+		return
+	}
+
+	// Packages that are imported or whose name is taken complete without an import.
+	imported := map[string]bool{}
+	names := map[string]bool{}
+
+	for _, spec := range importSpecs(file) {
+		importPath, err := literal.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		imported[importPath] = true
+
+		if spec.Name != nil {
+			names[spec.Name.Name] = true
+		} else {
+			names[importName(importPath)] = true
+		}
+	}
+
+	replace, err := getEditRange(location, "")
+	if err != nil {
+		return
+	}
+
+	// The members of a package share the edit adding its import.
+	edits := map[string]*protocol.TextEdit{}
+
+	add := func(label string, kind protocol.CompletionItemKind, insert string, doc string, importPath string) {
+		edit, ok := edits[importPath]
+		if !ok {
+			if e, err := importEdit(location.Doc, file, importPath); err == nil {
+				edit = &e
+			}
+
+			edits[importPath] = edit
+		}
+
+		if edit == nil {
+			return
+		}
+
+		// Unless the client resolves edits, the import and the snippet are added right away.
+		// Otherwise the plain name is inserted until the item is resolved.
+		item := protocol.CompletionItem{
+			Label:      label,
+			Kind:       kind,
+			SortText:   autoImportSortPrefix + label,
+			FilterText: label,
+			TextEdit:   &protocol.TextEdit{Range: replace, NewText: label},
+		}
+
+		*completions = append(*completions, lazyEdits(item, func(ctx context.Context, item *protocol.CompletionItem) {
+			item.Detail = fmt.Sprintf("import %q", importPath)
+			item.Documentation = protocol.MarkupContent{
+				Kind:  protocol.Markdown,
+				Value: doc,
+			}
+		}, func(ctx context.Context, item *protocol.CompletionItem) {
+			item.TextEdit = &protocol.TextEdit{Range: replace, NewText: insert}
+			item.InsertTextFormat = protocol.SnippetTextFormat
+			item.AdditionalTextEdits = []protocol.TextEdit{*edit}
+		}))
+	}
+
+	for importPath, pkg := range asg.BuiltinPkgs {
+		if imported[importPath] || names[pkg.Name] {
+			continue
+		}
+
+		for _, b := range pkg.Builtins {
+			kind := protocol.ConstantCompletion
+			if b.IsFunction {
+				kind = protocol.FunctionCompletion
+			}
+
+			add(pkg.Name+"."+b.Name, kind, pkg.Name+"."+builtinSnippet(b), b.Comment, importPath)
+		}
+	}
+
+	unlock := cache.LockEvaluator()
+	insts, err := location.Doc.ModulePackages()
+	unlock()
+
+	if err != nil {
+		return
+	}
+
+	for _, inst := range insts {
+		importPath := inst.ImportPath
+		if importName(importPath) != inst.PkgName && !strings.Contains(importPath, ":") {
+			importPath += ":" + inst.PkgName
+		}
+
+		if imported[importPath] || names[inst.PkgName] {
+			continue
+		}
+
+		for _, f := range inst.Files {
+			for _, decl := range f.Decls {
+				field, ok := decl.(*ast.Field)
+				if !ok {
+					continue
+				}
+
+				label, _, err := ast.LabelName(field.Label)
+				if err != nil || !strings.HasPrefix(label, "#") {
+					continue
+				}
+
+				var doc string
+				for _, c := range ast.Comments(field) {
+					doc += c.Text()
+				}
+
+				add(inst.PkgName+"."+label, protocol.ClassCompletion, inst.PkgName+"."+label, doc, importPath)
+			}
+		}
+	}
+}
+
+// builtinSnippet returns the text inserted for a builtin, with placeholders for the arguments of functions.
+func builtinSnippet(b *asg.Builtin) string {
+	if !b.IsFunction {
+		return b.Name
+	}
+
+	args := make([]string, 0, len(b.Args))
+	for i, arg := range b.Args {
+		args = append(args, fmt.Sprintf("${%d:%s}", i+1, arg.String()))
+	}
+
+	return b.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// importName returns the name a package is referred to by if it is imported without a name.
+func importName(importPath string) string {
+	if i := strings.LastIndex(importPath, ":"); i >= 0 {
+		return importPath[i+1:]
+	}
+
+	return path.Base(importPath)
+}
+
+// importEdit returns an edit that adds an import to a file, which must have been parsed from the content of the document.
+//
+// The import is added to the first import declaration, which becomes a block if it was a single import.
+// Without imports, it is added after the package clause.
+func importEdit(doc *cache.DocumentHandle, file *ast.File, importPath string) (protocol.TextEdit, error) {
+	quoted := strconv.Quote(importPath)
+
+	var decl *ast.ImportDecl
+
+	for _, d := range file.Decls {
+		if imports, ok := d.(*ast.ImportDecl); ok {
+			decl = imports
+			break
+		}
+	}
+
+	if decl == nil {
+		return newImportEdit(doc, file, quoted)
+	}
+
+	// A single import without parentheses is turned into a block.
+	if !decl.Lparen.IsValid() {
+		rng, err := nodeRange(doc, decl)
+		if err != nil {
+			return protocol.TextEdit{}, err
+		}
+
+		specs := []string{quoted}
+		for _, spec := range decl.Specs {
+			if spec.Name != nil {
+				specs = append(specs, spec.Name.Name+" "+spec.Path.Value)
+			} else {
+				specs = append(specs, spec.Path.Value)
+			}
+		}
+
+		sort.Slice(specs, func(i, j int) bool {
+			return importSortKey(specs[i]) < importSortKey(specs[j])
+		})
+
+		return protocol.TextEdit{
+			Range:   rng,
+			NewText: "import (\n\t" + strings.Join(specs, "\n\t") + "\n)",
+		}, nil
+	}
+
+	// The import is added before the first import that sorts after it, to keep sorted blocks sorted.
+	pos := decl.Rparen
+
+	for _, spec := range decl.Specs {
+		if importSortKey(spec.Path.Value) > quoted {
+			pos = spec.Pos()
+			break
+		}
+	}
+
+	start, err := doc.PosToProtocolPosition(pos)
+	if err != nil {
+		return protocol.TextEdit{}, err
+	}
+
+	// Imports are inserted at the start of the line, if nothing else is in front of them.
+	text := "\t" + quoted + "\n"
+	if pos == decl.Rparen && start.Character > 0 && !lineStartsWith(doc, start, ")") {
+		text = "\n\t" + quoted + "\n"
+	} else {
+		start.Character = 0
+	}
+
+	return protocol.TextEdit{
+		Range:   protocol.Range{Start: start, End: start},
+		NewText: text,
+	}, nil
+}
+
+// newImportEdit adds the first import of a file, after its package clause.
+func newImportEdit(doc *cache.DocumentHandle, file *ast.File, quoted string) (protocol.TextEdit, error) {
+	for _, d := range file.Decls {
+		if pkg, ok := d.(*ast.Package); ok {
+			end, err := doc.PosToProtocolPosition(pkg.End())
+			if err != nil {
+				return protocol.TextEdit{}, err
+			}
+
+			return protocol.TextEdit{
+				Range:   protocol.Range{Start: end, End: end},
+				NewText: "\n\nimport " + quoted,
+			}, nil
+		}
+	}
+
+	return protocol.TextEdit{NewText: "import " + quoted + "\n\n"}, nil
+}
+
+// importSortKey returns the quoted path of an import spec, which may start with a name.
+func importSortKey(spec string) string {
+	if i := strings.Index(spec, `"`); i > 0 {
+		return spec[i:]
+	}
+
+	return spec
+}
+
+// lineStartsWith reports whether the line of a position starts with a prefix, ignoring indentation.
+func lineStartsWith(doc *cache.DocumentHandle, pos protocol.Position, prefix string) bool {
+	content, err := doc.GetContent()
+	if err != nil {
+		return false
+	}
+
+	lines := strings.Split(content, "\n")
+	if int(pos.Line) >= len(lines) {
+		return false
+	}
+
+	return strings.HasPrefix(strings.TrimSpace(lines[int(pos.Line)]), prefix)
+}
+
+// nodeRange returns the range of an ast node in a document.
+func nodeRange(doc *cache.DocumentHandle, n ast.Node) (protocol.Range, error) {
+	start, err := doc.PosToProtocolPosition(n.Pos())
+	if err != nil {
+		return protocol.Range{}, err
+	}
+
+	end, err := doc.PosToProtocolPosition(n.End())
+	if err != nil {
+		return protocol.Range{}, err
+	}
+
+	return protocol.Range{Start: start, End: end}, nil
+}
@@ -0,0 +1,332 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+)
+
+// grammarContext is a place in a CUE file where certain language constructs are allowed.
+type grammarContext int
+
+const (
+	// packageContext is the start of a file without a package clause.
+	packageContext grammarContext = 1 << iota
+	// importContext is the start of a file, behind the package clause but before all other declarations.
+	importContext
+	// structContext is the body of a file or struct, where fields are declared.
+	structContext
+	// listContext is an element of a list.
+	listContext
+	// attributeContext is the end of the value of a field.
+	attributeContext
+)
+
+// construct is a keyword or language construct offered by completion.
+type construct struct {
+	label   string
+	kind    protocol.CompletionItemKind
+	snippet string
+	doc     string
+	// contexts are the grammar contexts the construct is allowed in.
+	contexts grammarContext
+}
+
+// constructs are the keywords and constructs offered by completion.
+var constructs = []construct{
+	{
+		label:    "package",
+		kind:     protocol.KeywordCompletion,
+		snippet:  "package ${1:name}",
+		doc:      "Declares the package the file belongs to.",
+		contexts: packageContext,
+	},
+	{
+		label:    "import",
+		kind:     protocol.KeywordCompletion,
+		snippet:  "import (\n\t\"${1:path}\"\n)",
+		doc:      "Imports packages into the file.",
+		contexts: importContext,
+	},
+	{
+		label:    "for",
+		kind:     protocol.KeywordCompletion,
+		snippet:  "for ${1:k}, ${2:v} in ${3:source} {\n\t$0\n}",
+		doc:      "Generates fields for every element of a list or field of a struct.",
+		contexts: structContext,
+	},
+	{
+		label:    "for",
+		kind:     protocol.KeywordCompletion,
+		snippet:  "for ${1:v} in ${2:source} {$0}",
+		doc:      "Generates an element for every element of a list or field of a struct.",
+		contexts: listContext,
+	},
+	{
+		label:    "if",
+		kind:     protocol.KeywordCompletion,
+		snippet:  "if ${1:condition} {\n\t$0\n}",
+		doc:      "Declares fields only if a condition holds.",
+		contexts: structContext,
+	},
+	{
+		label:    "if",
+		kind:     protocol.KeywordCompletion,
+		snippet:  "if ${1:condition} {$0}",
+		doc:      "Adds an element only if a condition holds.",
+		contexts: listContext,
+	},
+	{
+		label:    "let",
+		kind:     protocol.KeywordCompletion,
+		snippet:  "let ${1:x} = ${0:value}",
+		doc:      "Binds a value to an identifier that is not part of the output.",
+		contexts: structContext,
+	},
+	{
+		label:    "#Definition",
+		kind:     protocol.SnippetCompletion,
+		snippet:  "#${1:Name}: {\n\t$0\n}",
+		doc:      "Declares a definition, a closed schema that is not part of the output.",
+		contexts: structContext,
+	},
+	{
+		label:    "Definition ::",
+		kind:     protocol.SnippetCompletion,
+		snippet:  "${1:Name} :: {\n\t$0\n}",
+		doc:      "Declares a definition with the :: syntax of older CUE versions, which is still accepted.",
+		contexts: structContext,
+	},
+	{
+		label:    "optional field",
+		kind:     protocol.SnippetCompletion,
+		snippet:  "${1:foo}?: ${0:value}",
+		doc:      "Declares a field that constrains a value if it is present, but does not have to be.",
+		contexts: structContext,
+	},
+	{
+		label:    "[string]: constraint",
+		kind:     protocol.SnippetCompletion,
+		snippet:  "[${1:string}]: ${0:value}",
+		doc:      "Constrains the values of all fields whose label matches a pattern.",
+		contexts: structContext,
+	},
+	{
+		label:    "@tag()",
+		kind:     protocol.SnippetCompletion,
+		snippet:  "@tag(${1:name})",
+		doc:      "Sets the value of the field from a tag passed with `cue -t name=value`.",
+		contexts: attributeContext,
+	},
+}
+
+// completeKeywords offers the keywords and constructs the grammar allows at the position of a completion.
+func (s *server) completeKeywords(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location) {
+	file := fileAt(location)
+	if file == nil {
+		return
+	}
+
+	contexts := grammarContextAt(location)
+	if contexts == 0 {
+		return
+	}
+
+	if contexts&structContext != 0 && atFileLevel(location.Node) {
+		allowPackage, allowImport := preambleAt(file, location.Pos)
+		if allowPackage {
+			contexts |= packageContext
+		}
+
+		if allowImport {
+			contexts |= importContext
+		}
+	}
+
+	// Keywords replace the reference that is typed. Attributes are added behind the value of a field.
+	var replace *protocol.Range
+
+	if contexts&attributeContext != 0 {
+		// An empty range keeps clients from filtering the attributes by the value in front of them.
+		if pos, err := location.Doc.PosToProtocolPosition(location.Pos); err == nil {
+			replace = &protocol.Range{Start: pos, End: pos}
+		}
+	} else if _, ok := location.Node.(*asg.Reference); ok {
+		if rng, err := getEditRange(location, ""); err == nil {
+			replace = &rng
+		}
+	}
+
+	for _, c := range constructs {
+		if c.contexts&contexts == 0 {
+			continue
+		}
+
+		c := c
+		item := protocol.CompletionItem{
+			Label: c.label,
+			Kind:  c.kind,
+		}
+
+		insert := c.label
+		if c.contexts&attributeContext != 0 {
+			insert = " " + insert
+		}
+
+		if replace != nil {
+			item.TextEdit = &protocol.TextEdit{Range: *replace, NewText: insert}
+		} else {
+			item.InsertText = insert
+		}
+
+		*completions = append(*completions, lazyEdits(item, func(ctx context.Context, item *protocol.CompletionItem) {
+			item.Documentation = protocol.MarkupContent{
+				Kind:  protocol.Markdown,
+				Value: c.doc,
+			}
+		}, func(ctx context.Context, item *protocol.CompletionItem) {
+			snippet := c.snippet
+			if c.contexts&attributeContext != 0 {
+				snippet = " " + snippet
+			}
+
+			if item.TextEdit != nil {
+				item.TextEdit = &protocol.TextEdit{Range: item.TextEdit.Range, NewText: snippet}
+			} else {
+				item.InsertText = snippet
+			}
+
+			item.InsertTextFormat = protocol.SnippetTextFormat
+		}))
+	}
+}
+
+// grammarContextAt returns the grammar contexts of the position of a completion, judging by the node of the asg it is in.
+func grammarContextAt(location *cache.Location) grammarContext {
+	switch n := location.Node.(type) {
+	case nil, *asg.File, *asg.Struct:
+		return structContext
+	case *asg.Reference:
+		return valueContext(location, n, n.Parent())
+	case *asg.Value:
+		return valueContext(location, n, n.Parent())
+	}
+
+	return 0
+}
+
+// valueContext returns the grammar context of a value of the asg, depending on what it is a value of.
+func valueContext(location *cache.Location, n asg.Node, parent asg.Node) grammarContext {
+	switch p := parent.(type) {
+	case *asg.Decl:
+		if p.IsEmbedding() {
+			return structContext
+		}
+
+		// Attributes follow the complete value of a field.
+		if location.Pos.Offset() == n.End().Offset() && n.End().Offset() == p.End().Offset() {
+			return attributeContext
+		}
+	case *asg.Value:
+		if _, ok := p.Orig.(*ast.ListLit); ok {
+			return listContext
+		}
+	}
+
+	return 0
+}
+
+// atFileLevel reports whether a node is a file or a declaration embedded in a file, rather than part of a struct.
+func atFileLevel(n asg.Node) bool {
+	switch n := n.(type) {
+	case nil, *asg.File:
+		return true
+	case *asg.Reference:
+		d, ok := n.Parent().(*asg.Decl)
+		if !ok {
+			return false
+		}
+
+		_, ok = d.Parent().(*asg.File)
+
+		return ok
+	}
+
+	return false
+}
+
+// fileAt returns the syntax tree of the file a completion is in.
+//
+// Files without a package clause are not part of the compiled package, so they are parsed again.
+func fileAt(location *cache.Location) *ast.File {
+	for n := location.Node; n != nil; n = n.Parent() {
+		if f, ok := n.(*asg.File); ok {
+			return f.File
+		}
+	}
+
+	if location.Package != nil {
+		for _, f := range location.Package.Files {
+			if f.File.Filename == location.Pos.Filename() {
+				return f.File
+			}
+		}
+	}
+
+	content, err := location.Doc.GetContent()
+	if err != nil {
+		return nil
+	}
+
+	file, _ := parser.ParseFile(location.Pos.Filename(), content)
+
+	return file
+}
+
+// preambleAt reports whether a package clause and whether imports are allowed at a position of a file.
+//
+// The declaration the position is in is ignored, since it is the one that is typed.
+func preambleAt(file *ast.File, pos token.Pos) (allowPackage bool, allowImport bool) {
+	allowPackage, allowImport = true, true
+
+	for _, d := range file.Decls {
+		if d.Pos().Offset() <= pos.Offset() && pos.Offset() <= d.End().Offset() {
+			continue
+		}
+
+		before := d.End().Offset() <= pos.Offset()
+
+		switch d.(type) {
+		case *ast.Package:
+			allowPackage = false
+			// Nothing goes in front of the package clause.
+			allowImport = allowImport && before
+		case *ast.CommentGroup, *ast.Attribute:
+		case *ast.ImportDecl:
+			allowPackage = allowPackage && !before
+		default:
+			allowPackage = allowPackage && !before
+			allowImport = allowImport && !before
+		}
+	}
+
+	return allowPackage, allowImport
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// maxCompletionLists is the number of completion lists whose items can be resolved.
+//
+// Clients only resolve items of the latest list, but the REST API serves concurrent requests.
+const maxCompletionLists = 8
+
+// completionResolver fills in the fields of a completion item that are expensive to compute or large,
+// like documentation and snippets.
+type completionResolver func(ctx context.Context, item *protocol.CompletionItem)
+
+// editProperties are the properties of completion items that are only resolved lazily if the client supports it.
+//
+// All clients resolve documentation and detail, but clients have to list the other properties in resolveSupport.
+var editProperties = []string{"insertText", "textEdit", "insertTextFormat", "additionalTextEdits"}
+
+// pendingResolvers are the resolvers of a completion item whose edits may have to be applied right away.
+type pendingResolvers struct {
+	docs  completionResolver
+	edits completionResolver
+}
+
+// completionData is the data of completion items, which identifies their resolver.
+type completionData struct {
+	// List is the number of the completion list of the item.
+	List  uint64 `json:"list"`
+	Index int    `json:"index"`
+}
+
+// completionList holds the resolvers of the items of a completion list.
+type completionList struct {
+	uri       protocol.DocumentURI
+	resolvers []completionResolver
+}
+
+// lazy attaches a resolver to a completion item, which is called once the client resolves the item.
+//
+// The resolver may only set the documentation and detail of the item.
+func lazy(item protocol.CompletionItem, resolve completionResolver) protocol.CompletionItem {
+	item.Data = resolve
+
+	return item
+}
+
+// lazyEdits attaches a resolver for the documentation and detail and one for the edits to a completion item.
+//
+// The edits are only resolved lazily if the client supports resolving them, otherwise they are applied
+// before the item is returned.
+func lazyEdits(item protocol.CompletionItem, docs completionResolver, edits completionResolver) protocol.CompletionItem {
+	item.Data = pendingResolvers{docs: docs, edits: edits}
+
+	return item
+}
+
+// resolvesEdits reports whether a client can resolve the edits of completion items, given the properties
+// it lists in its resolveSupport capability.
+func resolvesEdits(properties []string) bool {
+	listed := make(map[string]bool, len(properties))
+	for _, p := range properties {
+		listed[p] = true
+	}
+
+	for _, p := range editProperties {
+		if !listed[p] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resolveDocumentation returns a resolver that adds the documentation of a node of the asg.
+func (s *server) resolveDocumentation(n asg.Node) completionResolver {
+	return func(ctx context.Context, item *protocol.CompletionItem) {
+		var doc bytes.Buffer
+
+		s.nodeDocMarkdown(ctx, nil, n, &doc)
+
+		item.Documentation = protocol.MarkupContent{
+			Kind:  protocol.Markdown,
+			Value: doc.String(),
+		}
+	}
+}
+
+// storeResolvers remembers the resolvers of a completion list and replaces them by completionData in the items.
+//
+// Edits the client cannot resolve are applied to the items.
+func (s *server) storeResolvers(ctx context.Context, uri protocol.DocumentURI, items []protocol.CompletionItem) {
+	for i := range items {
+		pending, ok := items[i].Data.(pendingResolvers)
+		if !ok {
+			continue
+		}
+
+		if s.editResolveSupported {
+			items[i].Data = completionResolver(func(ctx context.Context, item *protocol.CompletionItem) {
+				pending.docs(ctx, item)
+				pending.edits(ctx, item)
+			})
+
+			continue
+		}
+
+		pending.edits(ctx, &items[i])
+		items[i].Data = pending.docs
+	}
+
+	s.completionMu.Lock()
+	defer s.completionMu.Unlock()
+
+	s.completionSeq++
+
+	list := &completionList{uri: uri}
+
+	for i := range items {
+		resolve, ok := items[i].Data.(completionResolver)
+		if !ok {
+			continue
+		}
+
+		items[i].Data = completionData{List: s.completionSeq, Index: len(list.resolvers)}
+		list.resolvers = append(list.resolvers, resolve)
+	}
+
+	if s.completionLists == nil {
+		s.completionLists = make(map[uint64]*completionList)
+	}
+
+	s.completionLists[s.completionSeq] = list
+	delete(s.completionLists, s.completionSeq-maxCompletionLists)
+}
+
+// Resolve is required by the protocol.Server interface
+//
+// It fills in the documentation and detail of a completion item, as well as its snippet and additional edits
+// if the client supports resolving them.
+// Items of outdated completion lists are returned unchanged.
+func (s *server) Resolve(ctx context.Context, params *protocol.CompletionItem) (*protocol.CompletionItem, error) {
+	if params.Data == nil {
+		return params, nil
+	}
+
+	// The data has been decoded into a generic map, so we need a round trip to get our struct.
+	raw, err := json.Marshal(params.Data)
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "invalid completion data: %v", err)
+	}
+
+	var data completionData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "invalid completion data: %v", err)
+	}
+
+	s.completionMu.Lock()
+	list := s.completionLists[data.List]
+	s.completionMu.Unlock()
+
+	if list == nil || data.Index < 0 || data.Index >= len(list.resolvers) {
+		return params, nil
+	}
+
+	ret := *params
+	list.resolvers[data.Index](ctx, &ret)
+
+	if s.workspace.settingsFor(list.uri).CompletionDetail == minimalCompletionDetail {
+		ret.Detail = ""
+		ret.Documentation = protocol.MarkupContent{}
+	}
+
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
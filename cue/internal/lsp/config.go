@@ -15,10 +15,20 @@ package lsp
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/internal/lsp/attribute"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/lint"
 	"gopkg.in/yaml.v3"
 )
 
@@ -26,8 +36,110 @@ import (
 type Config struct {
 	RPCTrace    string `yaml:"rpc_trace"`
 	RESTAPIPort int    `yaml:"rest_api_port"`
+	// BuiltinDocURL is the documentation URL of builtin packages.
+	// The import path of the package replaces %s, which the URL must contain.
+	BuiltinDocURL string `yaml:"builtin_doc_url"`
+	// MetricsAddress is the address to serve metrics on, at /metrics in the Prometheus text format, like localhost:9090.
+	MetricsAddress string `yaml:"metrics_address"`
+	// OCAgentAddress is the URL of an OpenCensus agent that metrics and traces are sent to, like http://localhost:55678.
+	OCAgentAddress string `yaml:"ocagent_address"`
+	// DebugAddress is the address to serve debug pages on, like localhost:6060.
+	// They show the open documents, their compile results, recent RPC messages and memory statistics.
+	DebugAddress string `yaml:"debug_address"`
+	// CUE contains the default settings, which the client can override per workspace folder.
+	CUE Settings `yaml:"cue"`
 }
 
+// defaultBuiltinDocURL is used if no BuiltinDocURL is configured.
+const defaultBuiltinDocURL = "https://pkg.go.dev/cuelang.org/go/pkg/%s"
+
+// settingsSection is the section of the client configuration that contains the Settings.
+const settingsSection = "cue"
+
+// Settings are the settings of the cue section.
+//
+// They are read from the configuration file and through workspace/configuration
+// and can be changed without restarting the server.
+type Settings struct {
+	// EvalDiagnostics enables diagnostics reported by the CUE evaluator.
+	EvalDiagnostics bool `yaml:"eval_diagnostics" json:"evalDiagnostics"`
+	// Format contains the options of the formatter.
+	Format FormatSettings `yaml:"format" json:"format"`
+	// BuildTags are used when loading packages, like the -t flag of the cue command.
+	BuildTags []string `yaml:"build_tags" json:"buildTags"`
+	// ModuleRoot overrides the module root, which is otherwise found by looking for a cue.mod directory.
+	ModuleRoot string `yaml:"module_root" json:"moduleRoot"`
+	// CompletionDetail is either "full" (the default) or "minimal", which leaves out documentation.
+	CompletionDetail string `yaml:"completion_detail" json:"completionDetail"`
+	// DebugDumps logs dumps of the asg and ast of hovered nodes.
+	DebugDumps bool `yaml:"debug_dumps" json:"debugDumps"`
+	// DiagnosticsDelay is how long a package must stay unchanged before it is compiled and its diagnostics are published,
+	// like "200ms". It defaults to defaultDiagnosticsDelay.
+	DiagnosticsDelay string `yaml:"diagnostics_delay" json:"diagnosticsDelay"`
+	// Schemas select the CUE schemas that open JSON and YAML documents are validated against.
+	// A YAML document can also select its schema with a comment like "# @schema ./schemas #Deployment".
+	Schemas []SchemaSettings `yaml:"schemas" json:"schemas"`
+	// Attributes declare attributes in addition to the ones of the cue tools, like @go(Name),
+	// so they are documented, completed and validated.
+	Attributes []AttributeSettings `yaml:"attributes" json:"attributes"`
+	// Lint sets the severity of lint rules by their ID, like unused-import: error.
+	// The severities are off, hint, info, warning and error.
+	Lint map[string]string `yaml:"lint" json:"lint"`
+}
+
+// SchemaSettings map JSON and YAML files to the CUE schema they are validated against, like with cue vet.
+type SchemaSettings struct {
+	// Files is a glob matched against the path of a document relative to the workspace folder, like deploy/*.yaml.
+	// Globs without a slash are matched against the file name.
+	Files string `yaml:"files" json:"files"`
+	// Package is the import path of the package containing the schema, or a directory relative to the workspace folder.
+	Package string `yaml:"package" json:"package"`
+	// Path selects the schema in the package, like #Deployment. The whole package is used if it is empty.
+	Path string `yaml:"path" json:"path"`
+}
+
+// AttributeSettings declare an attribute, like @team(owner,oncall).
+type AttributeSettings struct {
+	// Key is the name of the attribute, without the @.
+	Key string `yaml:"key" json:"key"`
+	Doc string `yaml:"doc" json:"doc"`
+	// Args are the positional arguments of the attribute.
+	Args []AttributeParamSettings `yaml:"args" json:"args"`
+	// Options are the key=value pairs and flags following the arguments.
+	Options []AttributeParamSettings `yaml:"options" json:"options"`
+	// AnyOptions allows options that are not declared.
+	AnyOptions bool `yaml:"any_options" json:"anyOptions"`
+}
+
+// AttributeParamSettings declare an argument or option of an attribute.
+type AttributeParamSettings struct {
+	Name string `yaml:"name" json:"name"`
+	Doc  string `yaml:"doc" json:"doc"`
+	// Required is set for arguments that must not be empty.
+	Required bool `yaml:"required" json:"required"`
+	// Flag is set for options without a value.
+	Flag bool `yaml:"flag" json:"flag"`
+	// Values are the allowed values. Any value is allowed if it is empty.
+	Values []string `yaml:"values" json:"values"`
+}
+
+// FormatSettings are the options of the formatter.
+type FormatSettings struct {
+	Simplify bool `yaml:"simplify" json:"simplify"`
+	// UseSpaces indents with TabWidth spaces instead of tabs.
+	UseSpaces bool `yaml:"use_spaces" json:"useSpaces"`
+	TabWidth  int  `yaml:"tab_width" json:"tabWidth"`
+}
+
+// defaultDiagnosticsDelay is used if no DiagnosticsDelay is configured.
+const defaultDiagnosticsDelay = 200 * time.Millisecond
+
+// The possible values of Settings.CompletionDetail.
+const (
+	fullCompletionDetail    = "full"
+	minimalCompletionDetail = "minimal"
+)
+
 // ParseConfig parses a yaml configuration.
 //
 // It expects the content of the configuration file as its argument.
@@ -35,8 +147,11 @@ func ParseConfig(in []byte) (*Config, error) {
 	var config Config
 
 	err := yaml.Unmarshal(in, &config)
+	if err != nil {
+		return &config, err
+	}
 
-	return &config, err
+	return &config, config.validate()
 }
 
 // ParseConfigFile parses a yaml configuration file.
@@ -51,33 +166,301 @@ func ParseConfigFile(path string) (*Config, error) {
 	return ParseConfig(data)
 }
 
-// DidChangeConfiguration is required by the protocol.Server interface
-func (s *server) DidChangeConfiguration(ctx context.Context, params *protocol.DidChangeConfigurationParams) error {
-	langserverAddressConfigPath := []string{"promql", "url"}
+// builtinDocURLPlaceholder is replaced by the import path of a package in BuiltinDocURL.
+const builtinDocURLPlaceholder = "%s"
 
-	if params != nil {
-		// nolint: errcheck
-		s.client.LogMessage(
-			s.lifetime,
-			&protocol.LogMessageParams{
-				Type:    protocol.Info,
-				Message: fmt.Sprintf("Received notification change: %v\n", params),
-			})
+// builtinDocURL returns the documentation URL of a builtin package.
+func (c *Config) builtinDocURL(importPath string) string {
+	url := c.BuiltinDocURL
+	if url == "" {
+		url = defaultBuiltinDocURL
+	}
+
+	// The URL may contain escapes like %20, so it is not used as format string.
+	return strings.Replace(url, builtinDocURLPlaceholder, importPath, 1)
+}
+
+func (c *Config) validate() error {
+	if c.BuiltinDocURL != "" && !strings.Contains(c.BuiltinDocURL, builtinDocURLPlaceholder) {
+		return fmt.Errorf("invalid builtin doc url %q, expected %s in place of the import path", c.BuiltinDocURL, builtinDocURLPlaceholder)
+	}
+
+	return c.CUE.validate()
+}
+
+func (s *Settings) validate() error {
+	switch s.CompletionDetail {
+	case "", fullCompletionDetail, minimalCompletionDetail:
+	default:
+		return fmt.Errorf("invalid completion detail %q, expected %q or %q",
+			s.CompletionDetail, fullCompletionDetail, minimalCompletionDetail)
+	}
+
+	if s.Format.TabWidth < 0 {
+		return fmt.Errorf("invalid tab width %d", s.Format.TabWidth)
+	}
+
+	if _, err := s.diagnosticsDelay(); err != nil {
+		return err
+	}
+
+	for _, schema := range s.Schemas {
+		if _, err := filepath.Match(schema.Files, ""); err != nil || schema.Files == "" {
+			return fmt.Errorf("invalid schema files %q, expected a glob like *.yaml", schema.Files)
+		}
+
+		if schema.Package == "" {
+			return fmt.Errorf("missing schema package for %q", schema.Files)
+		}
 
-		setting := params.Settings
+		if err := cache.ValidateSchemaPath(schema.Path); err != nil {
+			return fmt.Errorf("invalid schema path %q: %v", schema.Path, err)
+		}
+	}
+
+	for _, attr := range s.Attributes {
+		if !ast.IsValidIdent(attr.Key) {
+			return fmt.Errorf("invalid attribute key %q, expected an identifier like go", attr.Key)
+		}
 
-		for _, e := range langserverAddressConfigPath {
-			m, ok := setting.(map[string]interface{})
-			if !ok {
-				break
+		for _, p := range append(append([]AttributeParamSettings{}, attr.Args...), attr.Options...) {
+			if p.Name == "" {
+				return fmt.Errorf("missing name of a parameter of attribute %q", attr.Key)
 			}
+		}
+	}
+
+	if _, err := s.LintConfig(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LintConfig returns the severities of the lint rules that the settings change.
+func (s *Settings) LintConfig() (lint.Config, error) {
+	config := lint.Config{}
+
+	for id, name := range s.Lint {
+		if lint.Lookup(id) == nil {
+			return nil, fmt.Errorf("unknown lint rule %q", id)
+		}
+
+		severity, err := lint.ParseSeverity(name)
+		if err != nil {
+			return nil, fmt.Errorf("lint rule %s: %v", id, err)
+		}
+
+		config[id] = severity
+	}
+
+	return config, nil
+}
+
+// decodeSettings overrides the given settings with the fields present in a settings section sent by the client.
+func decodeSettings(base Settings, section interface{}) (Settings, error) {
+	if section == nil {
+		return base, nil
+	}
+
+	// The section has already been decoded into a generic map, so we need a round trip to get our struct.
+	data, err := json.Marshal(section)
+	if err != nil {
+		return base, err
+	}
+
+	// Decoding reuses the maps and slice elements of the struct it decodes into, so the section is decoded
+	// into a copy of the base settings, which are shared by all documents.
+	ret, err := base.clone()
+	if err != nil {
+		return base, err
+	}
+
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return base, err
+	}
+
+	return ret, ret.validate()
+}
+
+// clone returns a deep copy of the settings.
+func (s Settings) clone() (Settings, error) {
+	var ret Settings
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return ret, err
+	}
+
+	return ret, json.Unmarshal(data, &ret)
+}
+
+func (s *Settings) diagnosticsDelay() (time.Duration, error) {
+	if s.DiagnosticsDelay == "" {
+		return defaultDiagnosticsDelay, nil
+	}
+
+	delay, err := time.ParseDuration(s.DiagnosticsDelay)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid diagnostics delay %q, expected a duration like 200ms", s.DiagnosticsDelay)
+	}
+
+	return delay, nil
+}
+
+func (s *Settings) cacheOptions() cache.Options {
+	// The settings have been validated.
+	delay, _ := s.diagnosticsDelay()
+
+	schemas := make([]cache.SchemaMapping, 0, len(s.Schemas))
+	for _, schema := range s.Schemas {
+		schemas = append(schemas, cache.SchemaMapping{
+			Files:   schema.Files,
+			Package: schema.Package,
+			Path:    schema.Path,
+		})
+	}
+
+	lintConfig, _ := s.LintConfig()
+
+	options := cache.Options{
+		BuildTags:       s.BuildTags,
+		ModuleRoot:      s.ModuleRoot,
+		EvalDiagnostics: s.EvalDiagnostics,
+		CompileDelay:    delay,
+		Schemas:         schemas,
+		Lint:            lintConfig,
+	}
+
+	if len(s.Attributes) > 0 {
+		specs := make([]*attribute.Spec, 0, len(s.Attributes))
+		for _, attr := range s.Attributes {
+			specs = append(specs, attr.spec())
+		}
+
+		options.Attributes = attribute.Default.Extend(specs...)
+	}
+
+	return options
+}
+
+func (a *AttributeSettings) spec() *attribute.Spec {
+	params := func(settings []AttributeParamSettings) []attribute.Param {
+		ret := make([]attribute.Param, 0, len(settings))
+		for _, p := range settings {
+			ret = append(ret, attribute.Param{
+				Name:     p.Name,
+				Doc:      p.Doc,
+				Required: p.Required,
+				Flag:     p.Flag,
+				Values:   p.Values,
+			})
+		}
+
+		return ret
+	}
+
+	return &attribute.Spec{
+		Key:        a.Key,
+		Doc:        a.Doc,
+		Args:       params(a.Args),
+		Options:    params(a.Options),
+		AnyOptions: a.AnyOptions,
+	}
+}
+
+func (f *FormatSettings) options() []format.Option {
+	var ret []format.Option
+
+	if f.Simplify {
+		ret = append(ret, format.Simplify())
+	}
+
+	if f.UseSpaces {
+		width := f.TabWidth
+		if width == 0 {
+			width = 4
+		}
+
+		ret = append(ret, format.UseSpaces(width), format.TabIndent(false))
+	}
+
+	return ret
+}
+
+// setSettings applies new settings to a folder and publishes the diagnostics of its documents, which might have changed.
+func (s *server) setSettings(f *folder, settings Settings) {
+	s.publishAll("Applying settings", s.workspace.setSettings(s.lifetime, f, settings))
+}
+
+// pullSettings requests the settings of the given workspace folders from the client.
+//
+// Fields the client does not set keep the values of the configuration file.
+func (s *server) pullSettings(ctx context.Context, folders []*folder) {
+	items := make([]protocol.ConfigurationItem, 0, len(folders))
+	for _, f := range folders {
+		items = append(items, protocol.ConfigurationItem{
+			ScopeURI: string(f.uri),
+			Section:  settingsSection,
+		})
+	}
+
+	sections, err := s.client.Configuration(ctx, &protocol.ParamConfiguration{
+		ConfigurationParams: protocol.ConfigurationParams{Items: items},
+	})
+	if err != nil || len(sections) != len(folders) {
+		s.logSettingsError(err)
+		return
+	}
 
-			setting, ok = m[e]
-			if !ok {
-				break
+	for i, f := range folders {
+		settings, err := decodeSettings(s.config.CUE, sections[i])
+		if err != nil {
+			s.logSettingsError(err)
+			continue
+		}
+
+		s.setSettings(f, settings)
+	}
+}
+
+func (s *server) logSettingsError(err error) {
+	if err == nil {
+		return
+	}
+
+	// nolint: errcheck
+	s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+		Type:    protocol.Error,
+		Message: fmt.Sprintf("failed to read %s settings: %v", settingsSection, err),
+	})
+}
+
+// DidChangeConfiguration is required by the protocol.Server interface
+//
+// Clients either push the changed settings or, if they support workspace/configuration, expect the server to pull them.
+func (s *server) DidChangeConfiguration(ctx context.Context, params *protocol.DidChangeConfigurationParams) error {
+	if params != nil {
+		if settings, ok := params.Settings.(map[string]interface{}); ok {
+			if section, ok := settings[settingsSection]; ok {
+				updated, err := decodeSettings(s.config.CUE, section)
+				if err != nil {
+					return jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "invalid %s settings: %v", settingsSection, err)
+				}
+
+				// Pushed settings are not specific to a folder.
+				for _, f := range s.workspace.getFolders(true) {
+					s.setSettings(f, updated)
+				}
+
+				return nil
 			}
 		}
 	}
 
+	if s.configurationSupported {
+		go s.pullSettings(s.lifetime, s.workspace.getFolders(false))
+	}
+
 	return nil
 }
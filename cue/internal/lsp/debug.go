@@ -0,0 +1,424 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// maxTraces is the number of RPC messages the debug pages keep.
+const maxTraces = 200
+
+// traceBuffer keeps the most recent RPC messages written to it.
+//
+// jSONLogStream writes every message with a single call, so every write is one entry.
+type traceBuffer struct {
+	mu      sync.Mutex
+	entries []string
+	// The index of the oldest entry, once the buffer is full.
+	next int
+}
+
+func (b *traceBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry := strings.TrimSpace(string(p))
+
+	if len(b.entries) < maxTraces {
+		b.entries = append(b.entries, entry)
+	} else {
+		b.entries[b.next] = entry
+		b.next = (b.next + 1) % maxTraces
+	}
+
+	return len(p), nil
+}
+
+// get returns the entries, oldest first.
+func (b *traceBuffer) get() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ret := make([]string, 0, len(b.entries))
+	ret = append(ret, b.entries[b.next:]...)
+
+	return append(ret, b.entries[:b.next]...)
+}
+
+// rpcTraces returns the buffer that the connections of the workspace log their messages to.
+func (w *workspace) rpcTraces() *traceBuffer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.traces == nil {
+		w.traces = &traceBuffer{}
+	}
+
+	return w.traces
+}
+
+// debugServer serves pages that show the state of a workspace.
+type debugServer struct {
+	workspace *workspace
+	started   time.Time
+}
+
+// startDebugServer serves the debug pages of a workspace on a TCP address until the context is done.
+func startDebugServer(ctx context.Context, addr string, w *workspace) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start debug server: %v", err)
+	}
+
+	srv := &http.Server{Handler: newDebugServer(w).handler()}
+
+	go srv.Serve(l) // nolint: errcheck
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	return nil
+}
+
+func newDebugServer(w *workspace) *debugServer {
+	// Make sure the traces are collected even if no connection was made yet.
+	w.rpcTraces()
+
+	return &debugServer{
+		workspace: w,
+		started:   time.Now(),
+	}
+}
+
+func (d *debugServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", d.serveIndex)
+	mux.HandleFunc("/documents", d.serveDocuments)
+	mux.HandleFunc("/asg", d.serveASG)
+	mux.HandleFunc("/rpc", d.serveRPC)
+	mux.HandleFunc("/memory", d.serveMemory)
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+var debugTemplates = template.Must(template.New("").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<title>CUE language server: {{.}}</title>
+<style>
+td, th { padding: 0 1em 0 0; text-align: left; }
+pre { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<a href="/">Overview</a>
+<a href="/documents">Documents</a>
+<a href="/rpc">RPC</a>
+<a href="/memory">Memory</a>
+<a href="/debug/pprof/goroutine?debug=2">Goroutines</a>
+<a href="/debug/pprof/">Profiles</a>
+<h1>{{.}}</h1>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "header" "Overview"}}
+<table>
+<tr><th>Uptime</th><td>{{.Uptime}}</td></tr>
+<tr><th>Connections</th><td>{{.Connections}}</td></tr>
+<tr><th>Open documents</th><td>{{.Documents}}</td></tr>
+<tr><th>Compiles started</th><td>{{.Compiles}}</td></tr>
+</table>
+<h2>Workspace folders</h2>
+<ul>
+{{range .Folders}}<li>{{.}}</li>
+{{else}}<li>none</li>
+{{end}}</ul>
+{{template "footer"}}{{end}}
+
+{{define "documents"}}{{template "header" "Documents"}}
+<table>
+<tr><th>URI</th><th>Folder</th><th>Language</th><th>Version</th><th>Opened</th><th>Compiling</th><th>Diagnostics</th><th></th></tr>
+{{range .}}<tr>
+<td>{{.URI}}</td><td>{{.Folder}}</td><td>{{.LanguageID}}</td><td>{{.Version}}</td><td>{{.Opened}}</td>
+<td>{{.Compiling}}</td><td>{{.Diagnostics}}</td>
+<td>{{if .Compiled}}<a href="/asg?uri={{.URI}}">asg</a>{{end}}</td>
+</tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "text"}}{{template "header" .Title}}
+<pre>{{.Text}}</pre>
+{{template "footer"}}{{end}}
+
+{{define "memory"}}{{template "header" "Memory"}}
+<table>
+<tr><th>Goroutines</th><td>{{.Goroutines}}</td></tr>
+<tr><th>Heap in use</th><td>{{.Stats.HeapInuse}} bytes</td></tr>
+<tr><th>Heap objects</th><td>{{.Stats.HeapObjects}}</td></tr>
+<tr><th>Total allocated</th><td>{{.Stats.TotalAlloc}} bytes</td></tr>
+<tr><th>Obtained from the system</th><td>{{.Stats.Sys}} bytes</td></tr>
+<tr><th>Garbage collections</th><td>{{.Stats.NumGC}}</td></tr>
+<tr><th>Total GC pause</th><td>{{.GCPause}}</td></tr>
+</table>
+{{template "footer"}}{{end}}
+`))
+
+func (d *debugServer) render(w http.ResponseWriter, name string, data interface{}) {
+	var buf bytes.Buffer
+
+	if err := debugTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes()) // nolint: errcheck
+}
+
+func (d *debugServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		Uptime      time.Duration
+		Connections int
+		Documents   int
+		Compiles    int64
+		Folders     []protocol.DocumentURI
+	}{
+		Uptime:      time.Since(d.started).Round(time.Second),
+		Connections: len(d.workspace.getListeners()),
+		Documents:   len(d.workspace.GetDocuments()),
+	}
+
+	d.workspace.mu.RLock()
+	scheduler := d.workspace.scheduler
+	d.workspace.mu.RUnlock()
+
+	if scheduler != nil {
+		data.Compiles = scheduler.Compiles()
+	}
+
+	for _, f := range d.workspace.getFolders(false) {
+		data.Folders = append(data.Folders, f.uri)
+	}
+
+	d.render(w, "index", data)
+}
+
+// debugDocument is a row of the documents page.
+type debugDocument struct {
+	URI         protocol.DocumentURI
+	Folder      protocol.DocumentURI
+	LanguageID  string
+	Version     float64
+	Opened      int
+	Compiling   bool
+	Compiled    bool
+	Diagnostics int
+}
+
+func (d *debugServer) serveDocuments(w http.ResponseWriter, r *http.Request) {
+	var docs []debugDocument
+
+	for _, f := range d.workspace.getFolders(true) {
+		for _, doc := range f.cache.GetDocuments() {
+			info := doc.Info()
+
+			docs = append(docs, debugDocument{
+				URI:         info.URI,
+				Folder:      f.uri,
+				LanguageID:  info.LanguageID,
+				Version:     info.Version,
+				Compiling:   info.Compiling,
+				Compiled:    info.Package != nil,
+				Diagnostics: info.Diagnostics,
+			})
+		}
+	}
+
+	d.workspace.mu.RLock()
+	for i := range docs {
+		docs[i].Opened = d.workspace.opened[docs[i].URI]
+	}
+	d.workspace.mu.RUnlock()
+
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].URI < docs[j].URI
+	})
+
+	d.render(w, "documents", docs)
+}
+
+func (d *debugServer) serveASG(w http.ResponseWriter, r *http.Request) {
+	uri := protocol.DocumentURI(r.URL.Query().Get("uri"))
+
+	doc, err := d.workspace.GetDocument(uri)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	pkg := doc.Info().Package
+	if pkg == nil {
+		http.Error(w, "document has not been compiled", http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+
+	dumpASGTree(pkg, 0, &buf)
+
+	d.render(w, "text", struct {
+		Title string
+		Text  string
+	}{string(uri), buf.String()})
+}
+
+func (d *debugServer) serveRPC(w http.ResponseWriter, r *http.Request) {
+	traces := d.workspace.rpcTraces().get()
+
+	d.render(w, "text", struct {
+		Title string
+		Text  string
+	}{"Recent RPC messages", strings.Join(traces, "\n")})
+}
+
+func (d *debugServer) serveMemory(w http.ResponseWriter, r *http.Request) {
+	var stats runtime.MemStats
+
+	runtime.ReadMemStats(&stats)
+
+	d.render(w, "memory", struct {
+		Goroutines int
+		Stats      runtime.MemStats
+		GCPause    time.Duration
+	}{runtime.NumGoroutine(), stats, time.Duration(stats.PauseTotalNs)})
+}
+
+// dumpASGTree writes a node and everything below it, one node per line.
+//
+// Unlike DumpASG, which walks up to the root, it walks down. Referenced nodes are only named,
+// since they belong to another part of the tree or another package.
+func dumpASGTree(n asg.Node, indent int, buf *bytes.Buffer) {
+	indentation := strings.Repeat("\t", indent)
+
+	switch t := n.(type) {
+	case *asg.Package:
+		fmtBuf(buf, "%s[%T]: %s (%s)", indentation, t, t.DisplayPath, t.Dir)
+
+		for _, f := range t.Files {
+			dumpASGTree(f, indent+1, buf)
+		}
+
+		for _, b := range t.Builtins {
+			dumpASGTree(b, indent+1, buf)
+		}
+
+		return
+	case *asg.Builtin:
+		fmtBuf(buf, "%s[%T]: %s", indentation, t, t.Name)
+		return
+	}
+
+	fmtBuf(buf, "%s[%T, %d:%d-%d:%d]: %s", indentation, n,
+		n.Pos().Line(), n.Pos().Column(), n.End().Line(), n.End().Column(), nodeSummary(n))
+
+	switch t := n.(type) {
+	case *asg.File:
+		imports := make([]string, 0, len(t.Imports))
+		for path := range t.Imports {
+			imports = append(imports, path)
+		}
+
+		sort.Strings(imports)
+
+		for _, path := range imports {
+			fmtBuf(buf, "%s\t[import]: %s", indentation, path)
+		}
+
+		for _, decl := range t.Decls {
+			dumpASGTree(decl, indent+1, buf)
+		}
+	case *asg.Struct:
+		for _, decl := range t.Decls {
+			dumpASGTree(decl, indent+1, buf)
+		}
+	case *asg.Decl:
+		for _, v := range t.Values {
+			dumpASGTree(v, indent+1, buf)
+		}
+	case *asg.Value:
+		for _, child := range t.Children {
+			dumpASGTree(child, indent+1, buf)
+		}
+	}
+}
+
+// nodeSummary describes a node of the asg on a single line.
+func nodeSummary(n asg.Node) string {
+	switch t := n.(type) {
+	case *asg.File:
+		return t.File.Filename
+	case *asg.Decl:
+		return t.LabelName
+	case *asg.Reference:
+		if t.Referenced == nil {
+			return "unresolved"
+		}
+
+		switch ref := t.Referenced.(type) {
+		case *asg.Package:
+			return fmt.Sprintf("-> package %s", ref.DisplayPath)
+		case *asg.Builtin:
+			return fmt.Sprintf("-> builtin %s", ref.Name)
+		default:
+			return fmt.Sprintf("-> %T at %s", ref, ref.Pos())
+		}
+	case *asg.Value:
+		return fmt.Sprintf("%T", t.Orig)
+	default:
+		return ""
+	}
+}
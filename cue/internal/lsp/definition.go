@@ -21,7 +21,7 @@ import (
 
 // Definition is required by the protocol.Server interface
 func (s *server) Definition(ctx context.Context, params *protocol.DefinitionParams) ([]protocol.Location, error) {
-	// location, err := s.cache.Find(&params.TextDocumentPositionParams)
+	// location, err := s.workspace.Find(&params.TextDocumentPositionParams)
 	// if err != nil {
 	// 	return nil, nil
 	// }
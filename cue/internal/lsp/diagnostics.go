@@ -15,21 +15,23 @@ package lsp
 
 import (
 	"context"
+	"path/filepath"
 
 	"github.com/pkg/errors"
 
+	"cuelang.org/go/cue/internal/lsp/cache"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
 )
 
 func (s *server) GetDiagnostics(uri protocol.DocumentURI) (*protocol.PublishDiagnosticsParams, error) {
-	d, err := s.cache.GetDocument(uri)
+	d, err := s.workspace.GetDocument(uri)
 	if err != nil {
 		return nil, errors.Wrapf(err, "document not found in cache")
 	}
 
 	version, expired := d.GetVersion()
 	if expired != nil {
-		return nil, err
+		return nil, expired
 	}
 
 	reply := &protocol.PublishDiagnosticsParams{
@@ -37,6 +39,9 @@ func (s *server) GetDiagnostics(uri protocol.DocumentURI) (*protocol.PublishDiag
 		Version: version,
 	}
 
+	// The caller is waiting, so the document should not wait for the diagnostics delay.
+	d.Prioritize()
+
 	diagnostics, err := d.GetDiagnostics()
 	if err != nil {
 		return nil, err
@@ -48,14 +53,14 @@ func (s *server) GetDiagnostics(uri protocol.DocumentURI) (*protocol.PublishDiag
 }
 
 func (s *server) getAllDiags(uri protocol.DocumentURI) ([]*protocol.PublishDiagnosticsParams, error) {
-	d, err := s.cache.GetDocument(uri)
+	d, err := s.workspace.GetDocument(uri)
 	if err != nil {
 		return nil, errors.Wrapf(err, "document not found in cache")
 	}
 
 	version, expired := d.GetVersion()
 	if expired != nil {
-		return nil, err
+		return nil, expired
 	}
 
 	ret := []*protocol.PublishDiagnosticsParams{}
@@ -74,12 +79,41 @@ func (s *server) getAllDiags(uri protocol.DocumentURI) ([]*protocol.PublishDiagn
 		ret = append(ret, reply)
 	}
 
+	// Publishing no diagnostics for the document itself clears the ones of its previous version.
+	if _, ok := diagnostics[uri]; !ok {
+		ret = append(ret, &protocol.PublishDiagnosticsParams{
+			URI:         uri,
+			Version:     version,
+			Diagnostics: []protocol.Diagnostic{},
+		})
+	}
+
 	return ret, nil
 }
 
+// GetAllDiagnostics returns the diagnostics of a document and of the other files they were found in.
+//
+// Errors without a position are reported under the URI of the empty path.
+func (s *server) GetAllDiagnostics(uri protocol.DocumentURI) ([]*protocol.PublishDiagnosticsParams, error) {
+	d, err := s.workspace.GetDocument(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "document not found in cache")
+	}
+
+	// The caller is waiting, so the document should not wait for the diagnostics delay.
+	d.Prioritize()
+
+	return s.getAllDiags(uri)
+}
+
 // nolint:funlen
 func (s *server) diagnostics(uri protocol.DocumentURI) {
 	replies, err := s.getAllDiags(uri)
+	// If the document changed in the meantime, the diagnostics of the new version are published instead.
+	if err == context.Canceled {
+		return
+	}
+
 	if err != nil {
 		// nolint: errcheck
 		s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
@@ -100,6 +134,38 @@ func (s *server) diagnostics(uri protocol.DocumentURI) {
 
 }
 
+// publishAll publishes the diagnostics of several documents in the background.
+//
+// The client can follow and cancel the progress, the documents that were not handled yet keep their old diagnostics.
+func (s *server) publishAll(title string, docs []*cache.DocumentHandle) {
+	if s.headless || len(docs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.lifetime)
+
+	go func() {
+		defer cancel()
+
+		progress := s.startWorkDone(title, true, cancel)
+		if progress != nil {
+			defer progress.End("")
+		}
+
+		for i, doc := range docs {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if progress != nil {
+				progress.Report(filepath.Base(cache.PathFromURI(doc.GetURI())), float64(100*i/len(docs)))
+			}
+
+			s.diagnostics(doc.GetURI())
+		}
+	}()
+}
+
 func (s *server) clearDiagnostics(ctx context.Context, uri protocol.DocumentURI, version float64) {
 	diagnostics := &protocol.PublishDiagnosticsParams{
 		URI:         uri,
@@ -0,0 +1,93 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// DocumentHighlight is required by the protocol.Server interface
+//
+// It highlights all labels and references in the current file that resolve to the same declaration as the symbol under the cursor.
+func (s *server) DocumentHighlight(ctx context.Context, params *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
+	location, err := s.workspace.Find(ctx, &params.TextDocumentPositionParams)
+	if err != nil {
+		return nil, cancelled(ctx)
+	}
+
+	decl := symbolDecl(location.Node)
+	if decl == nil {
+		return nil, nil
+	}
+
+	filename := location.Pos.Filename()
+	ret := []protocol.DocumentHighlight{}
+
+	add := func(node asg.Node, kind protocol.DocumentHighlightKind) error {
+		if node.Pos().Filename() != filename {
+			return nil
+		}
+
+		loc, err := nodeLocation(location.Doc, node)
+		if err != nil {
+			return err
+		}
+
+		ret = append(ret, protocol.DocumentHighlight{
+			Range: loc.Range,
+			Kind:  kind,
+		})
+
+		return nil
+	}
+
+	for _, label := range decl.Labels {
+		if err := add(label, protocol.Write); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ref := range asg.References(location.Package, decl) {
+		if err := add(ref, protocol.Read); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// symbolDecl returns the Decl, if the node is either one of its labels or a reference to it.
+func symbolDecl(node asg.Node) *asg.Decl {
+	switch n := node.(type) {
+	case *asg.Reference:
+		decl, _ := n.Referenced.(*asg.Decl)
+		return decl
+	case *asg.Value:
+		decl, ok := n.Parent().(*asg.Decl)
+		if !ok {
+			return nil
+		}
+
+		for _, label := range decl.Labels {
+			if label == n {
+				return decl
+			}
+		}
+	}
+
+	return nil
+}
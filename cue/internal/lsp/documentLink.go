@@ -0,0 +1,210 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/literal"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/scanner"
+	"cuelang.org/go/cue/token"
+)
+
+// urlPattern matches http(s) URLs in strings and comments.
+// nolint: gochecknoglobals
+var urlPattern = regexp.MustCompile("https?://[^\\s\"'`<>()\\[\\]{}\\\\]+")
+
+// importLinkData is attached to links of import paths, which are only resolved on request.
+type importLinkData struct {
+	URI        protocol.DocumentURI `json:"uri"`
+	ImportPath string               `json:"importPath"`
+}
+
+// DocumentLink is required by the protocol.Server interface
+//
+// Import paths and URLs in strings and comments are links.
+func (s *server) DocumentLink(ctx context.Context, params *protocol.DocumentLinkParams) ([]protocol.DocumentLink, error) {
+	uri := params.TextDocument.URI
+
+	doc, err := s.workspace.GetDocument(uri)
+	if err != nil {
+		return nil, nil
+	}
+
+	content, err := doc.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	links := &documentLinks{doc: doc}
+
+	// Resolving imports requires loading packages, so it is deferred to ResolveDocumentLink.
+	if file, err := parser.ParseFile(cache.PathFromURI(uri), content, parser.ImportsOnly); err == nil {
+		for _, spec := range importSpecs(file) {
+			importPath, err := literal.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+
+			links.add(spec.Path.Pos(), spec.Path.End(), protocol.DocumentLink{
+				Data: importLinkData{URI: uri, ImportPath: importPath},
+			})
+		}
+	}
+
+	links.urls(content)
+
+	return links.links, links.err
+}
+
+// ResolveDocumentLink is required by the protocol.Server interface
+//
+// It computes the target of an import path, which is either the first file of
+// the imported package, its directory or the documentation of a builtin package.
+func (s *server) ResolveDocumentLink(ctx context.Context, params *protocol.DocumentLink) (*protocol.DocumentLink, error) {
+	if params.Target != "" || params.Data == nil {
+		return params, nil
+	}
+
+	// The data has been decoded into a generic map, so we need a round trip to get our struct.
+	raw, err := json.Marshal(params.Data)
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "invalid link data: %v", err)
+	}
+
+	var data importLinkData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "invalid link data: %v", err)
+	}
+
+	ret := *params
+
+	if isBuiltinImport(data.ImportPath) {
+		ret.Target = s.config.builtinDocURL(data.ImportPath)
+		ret.Tooltip = "builtin package " + data.ImportPath
+
+		return &ret, nil
+	}
+
+	doc, err := s.workspace.GetDocument(data.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := cache.LockEvaluator()
+	inst, err := doc.ResolveImport(data.ImportPath)
+	unlock()
+
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInternalError, "could not resolve import %q: %v", data.ImportPath, err)
+	}
+
+	ret.Tooltip = inst.Dir
+
+	if len(inst.BuildFiles) > 0 {
+		ret.Target = string(cache.URIFromPath(inst.BuildFiles[0].Filename))
+	} else {
+		ret.Target = string(cache.URIFromPath(inst.Dir))
+	}
+
+	return &ret, nil
+}
+
+// isBuiltinImport reports whether an import path refers to a builtin package.
+//
+// Like in Go, other packages need a domain name as the first path element.
+func isBuiltinImport(importPath string) bool {
+	elem := strings.SplitN(importPath, "/", 2)[0]
+	return !strings.Contains(elem, ".")
+}
+
+func importSpecs(file *ast.File) []*ast.ImportSpec {
+	var ret []*ast.ImportSpec
+
+	for _, decl := range file.Decls {
+		if imports, ok := decl.(*ast.ImportDecl); ok {
+			ret = append(ret, imports.Specs...)
+		}
+	}
+
+	return ret
+}
+
+// documentLinks collects the links of a document, remembering the first error.
+type documentLinks struct {
+	doc   *cache.DocumentHandle
+	links []protocol.DocumentLink
+	err   error
+}
+
+func (l *documentLinks) add(pos token.Pos, end token.Pos, link protocol.DocumentLink) {
+	if l.err != nil {
+		return
+	}
+
+	start, err := l.doc.PosToProtocolPosition(pos)
+	if err != nil {
+		l.err = err
+		return
+	}
+
+	stop, err := l.doc.PosToProtocolPosition(end)
+	if err != nil {
+		l.err = err
+		return
+	}
+
+	link.Range = protocol.Range{Start: start, End: stop}
+	l.links = append(l.links, link)
+}
+
+// urls adds links for all URLs found in string literals and comments.
+//
+// The content is scanned rather than parsed, so this also works for documents with syntax errors.
+func (l *documentLinks) urls(content string) {
+	file := token.NewFile("", -1, len(content))
+
+	var s scanner.Scanner
+
+	s.Init(file, []byte(content), nil, scanner.ScanComments)
+
+	for {
+		pos, tok, lit := s.Scan()
+
+		switch tok {
+		case token.EOF:
+			return
+		case token.STRING, token.INTERPOLATION, token.COMMENT:
+		default:
+			continue
+		}
+
+		for _, match := range urlPattern.FindAllStringIndex(lit, -1) {
+			url := strings.TrimRight(lit[match[0]:match[1]], ".,;:!?")
+			offset := pos.Offset() + match[0]
+
+			l.add(file.Pos(offset, token.NoRelPos), file.Pos(offset+len(url), token.NoRelPos), protocol.DocumentLink{
+				Target: url,
+			})
+		}
+	}
+}
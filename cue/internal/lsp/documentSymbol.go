@@ -10,7 +10,7 @@ import (
 
 // DocumentSymbol is required by the protocol.Server interface
 func (s *server) DocumentSymbol(ctx context.Context, params *protocol.DocumentSymbolParams) ([]interface{}, error) {
-	// doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	// doc, err := s.workspace.GetDocument(params.TextDocument.URI)
 	// if err != nil {
 	// 	return nil, err
 	// }
@@ -0,0 +1,62 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// Formatting is required by the protocol.Server interface
+//
+// The document is formatted like cue fmt does, with the configured format settings.
+// If the client asks for spaces, they are used with the tab size of the client.
+func (s *server) Formatting(ctx context.Context, params *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error) {
+	uri := params.TextDocument.URI
+
+	doc, err := s.workspace.GetDocument(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := doc.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := s.workspace.settingsFor(uri).Format
+	if params.Options.InsertSpaces && !settings.UseSpaces {
+		settings.UseSpaces = true
+		settings.TabWidth = int(params.Options.TabSize)
+	}
+
+	formatted, err := format.Source([]byte(content), settings.options()...)
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "cannot format %s: %v", uri, err)
+	}
+
+	if string(formatted) == content {
+		return nil, nil
+	}
+
+	return []protocol.TextEdit{{
+		Range: protocol.Range{
+			End: endPosition(content),
+		},
+		NewText: string(formatted),
+	}}, nil
+}
@@ -34,16 +34,27 @@ func (s *server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 
 	s.state = serverInitializing
 
-	s.cache.Init()
-	s.cache.LoadRootFolder(params.RootURI)
-	s.cache.Logging = make(chan protocol.LogMessageParams, 100)
+	// Other connections might have prepared a shared workspace already.
+	if s.workspace.init() {
+		s.setSettings(s.workspace.fallback, s.config.CUE)
+	}
+
+	s.configurationSupported = params.Capabilities.Workspace.Configuration
+	s.progressSupported = params.Capabilities.Window.WorkDoneProgress
+	s.createFilesSupported = canCreateFiles(params.Capabilities.Workspace.WorkspaceEdit)
+	s.editResolveSupported = resolvesEdits(params.Capabilities.TextDocument.Completion.CompletionItem.ResolveSupport.Properties)
 
 	// Start receiving log messages in background.
-	go (func() {
-		for msg := range s.cache.Logging {
-			s.client.LogMessage(s.lifetime, &msg)
+	s.workspace.listen(s)
+
+	// Clients without workspace folder support only send a root.
+	if len(params.WorkspaceFolders) > 0 {
+		for _, f := range params.WorkspaceFolders {
+			s.workspace.addFolder(protocol.DocumentURI(f.URI), s.config.CUE)
 		}
-	})()
+	} else if params.RootURI != "" {
+		s.workspace.addFolder(params.RootURI, s.config.CUE)
+	}
 
 	return &protocol.InitializeResult{
 		Capabilities: protocol.ServerCapabilities{
@@ -57,9 +68,30 @@ func (s *server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 				TriggerCharacters: []string{
 					".", //" ", "\n", "\t", "(", ")", "[", "]", "{", "}", "+", "-", "*", "/", "!", "=", "\"", ",", "'", "\"", "`", "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "n", "m", "o", "p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z", "A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "N", "M", "O", "P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z",
 				},
+				ResolveProvider: true,
+			},
+			DocumentSymbolProvider:     true,
+			DefinitionProvider:         true,
+			TypeDefinitionProvider:     true,
+			ImplementationProvider:     true,
+			DocumentHighlightProvider:  true,
+			DocumentFormattingProvider: true,
+			CodeActionProvider: protocol.CodeActionOptions{
+				CodeActionKinds: []protocol.CodeActionKind{protocol.QuickFix, protocol.SourceFixAll},
+			},
+			CodeLensProvider: protocol.CodeLensOptions{},
+			DocumentLinkProvider: protocol.DocumentLinkOptions{
+				ResolveProvider: true,
+			},
+			ExecuteCommandProvider: protocol.ExecuteCommandOptions{
+				Commands: serverCommands,
+			},
+			Workspace: protocol.WorkspaceGn{
+				WorkspaceFolders: protocol.WorkspaceFoldersGn{
+					Supported:           true,
+					ChangeNotifications: "workspace/didChangeWorkspaceFolders",
+				},
 			},
-			DocumentSymbolProvider: true,
-			DefinitionProvider:     true,
 		},
 	}, nil
 }
@@ -76,6 +108,10 @@ func (s *server) Initialized(ctx context.Context, params *protocol.InitializedPa
 
 	s.state = serverInitialized
 
+	if s.configurationSupported {
+		go s.pullSettings(s.lifetime, s.workspace.getFolders(false))
+	}
+
 	return err
 }
 
@@ -24,6 +24,7 @@ import (
 	"cuelang.org/go/cue/internal/adt"
 	"cuelang.org/go/cue/internal/lsp/asg"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/token"
 
 	"cuelang.org/go/cue/internal/lsp/cache"
 	// Do not remove! Side effects of init() needed
@@ -80,11 +81,103 @@ func (s *server) DumpASG(ctx context.Context, n asg.Node, doc *cache.DocumentHan
 // Hover shows documentation on hover
 // required by the protocol.Server interface
 func (s *server) Hover(ctx context.Context, params *protocol.HoverParams) (*protocol.Hover, error) {
-	location, err := s.cache.Find(&params.TextDocumentPositionParams)
-	if err != nil || location.Node == nil {
+	location, err := s.workspace.Find(ctx, &params.TextDocumentPositionParams)
+	if err != nil {
+		return nil, cancelled(ctx)
+	}
+
+	if attr := attributeAt(location); attr != nil {
+		return s.attributeHover(location, attr)
+	}
+
+	if location.Node == nil {
+		return nil, cancelled(ctx)
+	}
+
+	if s.workspace.settingsFor(params.TextDocument.URI).DebugDumps {
+		s.logDumps(ctx, location)
+	}
+
+	markdown := bytes.Buffer{}
+
+	if f, ok := location.Node.(*asg.File); ok {
+		clause := packageClause(f.File, location.Pos)
+		if clause == nil {
+			return nil, nil
+		}
+
+		return s.packageClauseHover(location.Doc, clause)
+	}
+
+	// markdown = s.nodeToDocMarkdown(ctx, location, location.Cursor)
+	s.nodeDocMarkdown(ctx, location.Doc, location.Node, &markdown)
+
+	hoverRange, err := getEditRange(location, "")
+	if err != nil {
+		return nil, nil
+	}
+
+	return &protocol.Hover{
+		Contents: protocol.MarkupContent{
+			Kind:  "markdown",
+			Value: markdown.String(),
+		},
+		Range: hoverRange,
+	}, nil
+}
+
+// packageClause returns the package clause of a file if it contains the position.
+func packageClause(file *ast.File, pos token.Pos) *ast.Package {
+	for _, decl := range file.Decls {
+		if clause, ok := decl.(*ast.Package); ok && asg.Contains(clause, pos) {
+			return clause
+		}
+	}
+
+	return nil
+}
+
+// packageClauseHover shows the import path of the package and the module it belongs to.
+func (s *server) packageClauseHover(doc *cache.DocumentHandle, clause *ast.Package) (*protocol.Hover, error) {
+	markdown := bytes.Buffer{}
+
+	cfStart(&markdown)
+	fmtBuf(&markdown, "package %s", clause.Name.Name)
+	cfEnd(&markdown)
+
+	unlock := cache.LockEvaluator()
+	inst, err := doc.LoadInstance(nil)
+	unlock()
+
+	if err == nil && inst.Module != "" {
+		fmtBuf(&markdown, "\nImport path `%s`\n\nModule `%s` in `%s`", inst.ImportPath, inst.Module, inst.Root)
+	} else if root := doc.ModuleRoot(); root != "" {
+		fmtBuf(&markdown, "\nUnnamed module in `%s`", root)
+	} else {
+		markdown.WriteString("\nNot part of a module")
+	}
+
+	start, err := doc.PosToProtocolPosition(clause.Pos())
+	if err != nil {
 		return nil, nil
 	}
 
+	end, err := doc.PosToProtocolPosition(clause.End())
+	if err != nil {
+		return nil, nil
+	}
+
+	return &protocol.Hover{
+		Contents: protocol.MarkupContent{
+			Kind:  "markdown",
+			Value: markdown.String(),
+		},
+		Range: protocol.Range{Start: start, End: end},
+	}, nil
+}
+
+// logDumps logs the asg and ast of the hovered node, which helps debugging the server.
+func (s *server) logDumps(ctx context.Context, location *cache.Location) {
 	adtDump := bytes.Buffer{}
 	adtDump.WriteString("ASGDump:\n")
 	s.DumpASG(ctx, location.Node, location.Doc, 1, &adtDump)
@@ -111,24 +204,6 @@ func (s *server) Hover(ctx context.Context, params *protocol.HoverParams) (*prot
 		Type:    protocol.Info,
 		Message: astDump.String(),
 	})
-
-	markdown := bytes.Buffer{}
-
-	// markdown = s.nodeToDocMarkdown(ctx, location, location.Cursor)
-	s.nodeDocMarkdown(ctx, location.Doc, location.Node, &markdown)
-
-	hoverRange, err := getEditRange(location, "")
-	if err != nil {
-		return nil, nil
-	}
-
-	return &protocol.Hover{
-		Contents: protocol.MarkupContent{
-			Kind:  "markdown",
-			Value: markdown.String(),
-		},
-		Range: hoverRange,
-	}, nil
 }
 
 func (s *server) nodeDocMarkdown(ctx context.Context, doc *cache.DocumentHandle, node asg.Node, buf *bytes.Buffer) { //nolint: golint
@@ -243,7 +318,6 @@ func declExpr(expr adt.Expr, location *cache.Location, buf *bytes.Buffer) error
 		_, err = buf.WriteString(contents)
 		return err
 	}
-	return nil
 }
 
 func declaration(name string, expr adt.Expr, comments []*ast.CommentGroup, location *cache.Location, buf *bytes.Buffer) error {
@@ -0,0 +1,86 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// Implementation is required by the protocol.Server interface
+//
+// It lists every field in the open packages and the other packages of the module that is unified with or embeds
+// the definition under the cursor.
+func (s *server) Implementation(ctx context.Context, params *protocol.ImplementationParams) ([]protocol.Location, error) {
+	location, err := s.workspace.Find(ctx, &params.TextDocumentPositionParams)
+	if err != nil {
+		return nil, cancelled(ctx)
+	}
+
+	def := enclosingDecl(location.Node)
+	if def == nil {
+		return nil, nil
+	}
+
+	ret := []protocol.Location{}
+	// Every document compiles its whole package, so the same field may be found multiple times.
+	seen := make(map[protocol.Location]bool)
+
+	var pkgs []*asg.Package
+
+	for _, doc := range s.workspace.GetDocuments() {
+		pkg, err := doc.GetCompiled(ctx)
+		if ctx.Err() != nil {
+			return nil, cancelled(ctx)
+		}
+
+		if err == nil && pkg != nil {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+
+	// The other packages of the module may have no open documents, so they are compiled for this request.
+	unlock := cache.LockEvaluator()
+	modulePkgs, err := location.Doc.CompileModulePackages()
+	unlock()
+
+	if ctx.Err() != nil {
+		return nil, cancelled(ctx)
+	}
+
+	if err == nil {
+		pkgs = append(pkgs, modulePkgs...)
+	}
+
+	for _, pkg := range pkgs {
+		for _, impl := range asg.Implementations(pkg, def) {
+			locs, err := declLocations(location.Doc, impl)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, loc := range locs {
+				if !seen[loc] {
+					seen[loc] = true
+					ret = append(ret, loc)
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
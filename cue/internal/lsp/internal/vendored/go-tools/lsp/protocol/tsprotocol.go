@@ -553,6 +553,19 @@ type CompletionClientCapabilities struct {
 		 * @since 3.16.0 - Proposed state
 		 */
 		InsertReplaceSupport bool `json:"insertReplaceSupport,omitempty"`
+		/**
+		 * Indicates which properties a client can resolve lazily on a completion
+		 * item. Before version 3.16.0 only the predefined properties `documentation`
+		 * and `details` could be resolved lazily.
+		 *
+		 * @since 3.16.0 - Proposed state
+		 */
+		ResolveSupport struct {
+			/**
+			 * The properties that a client can resolve lazily.
+			 */
+			Properties []string `json:"properties"`
+		} `json:"resolveSupport,omitempty"`
 	} `json:"completionItem,omitempty"`
 	CompletionItemKind struct {
 		/**
@@ -3180,7 +3193,7 @@ type TextDocumentEdit struct {
 	/**
 	 * The text document to change.
 	 */
-	TextDocument VersionedTextDocumentIdentifier `json:"textDocument"`
+	TextDocument OptionalVersionedTextDocumentIdentifier `json:"textDocument"`
 	/**
 	 * The edits to be applied.
 	 */
@@ -3400,6 +3413,22 @@ type VersionedTextDocumentIdentifier struct {
 	TextDocumentIdentifier
 }
 
+/**
+ * A text document identifier to optionally denote a specific version of a text document.
+ */
+type OptionalVersionedTextDocumentIdentifier struct {
+	/**
+	 * The version number of this document. If an optional versioned text document
+	 * identifier is sent from the server to the client and the file is not
+	 * open in the editor (the server has not received an open notification
+	 * before) the server can send `null` to indicate that the version is
+	 * known and the content on disk is the master (as specified with document
+	 * content ownership).
+	 */
+	Version *float64/*number | null*/ `json:"version"`
+	TextDocumentIdentifier
+}
+
 type WatchKind float64
 
 /**
@@ -3582,7 +3611,7 @@ type WorkspaceEdit struct {
 	 * If a client neither supports `documentChanges` nor `workspace.workspaceEdit.resourceOperations` then
 	 * only plain `TextEdit`s using the `changes` property are supported.
 	 */
-	DocumentChanges []TextDocumentEdit/*TextDocumentEdit | CreateFile | RenameFile | DeleteFile*/ `json:"documentChanges,omitempty"`
+	DocumentChanges []interface{}/*TextDocumentEdit | CreateFile | RenameFile | DeleteFile*/ `json:"documentChanges,omitempty"`
 }
 
 type WorkspaceEditClientCapabilities struct {
@@ -15,24 +15,33 @@ package lsp
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"cuelang.org/go/cue/internal/lsp/cache"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/lint"
+	"cuelang.org/go/cue/internal/lsp/metrics"
 )
 
 // TestNotImplemented checks whether unimplemented functions return the approbiate Error
 func TestNotImplemented(*testing.T) { // nolint: gocognit, funlen, gocyclo
-	s := &server{}
+	s := &server{workspace: &workspace{}}
 
-	err := s.DidChangeWorkspaceFolders(context.Background(), &protocol.DidChangeWorkspaceFoldersParams{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
-	err = s.DidSave(context.Background(), &protocol.DidSaveTextDocumentParams{})
+	err := s.DidSave(context.Background(), &protocol.DidSaveTextDocumentParams{})
 	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
 	}
@@ -67,16 +76,6 @@ func TestNotImplemented(*testing.T) { // nolint: gocognit, funlen, gocyclo
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
 	}
 
-	_, err = s.Implementation(context.Background(), &protocol.ImplementationParams{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
-	_, err = s.TypeDefinition(context.Background(), &protocol.TypeDefinitionParams{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
 	_, err = s.DocumentColor(context.Background(), &protocol.DocumentColorParams{})
 	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
@@ -107,11 +106,6 @@ func TestNotImplemented(*testing.T) { // nolint: gocognit, funlen, gocyclo
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
 	}
 
-	_, err = s.Resolve(context.Background(), &protocol.CompletionItem{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
 	_, err = s.Definition(context.Background(), &protocol.DefinitionParams{})
 	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
@@ -122,21 +116,11 @@ func TestNotImplemented(*testing.T) { // nolint: gocognit, funlen, gocyclo
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
 	}
 
-	_, err = s.DocumentHighlight(context.Background(), &protocol.DocumentHighlightParams{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
 	_, err = s.DocumentSymbol(context.Background(), &protocol.DocumentSymbolParams{})
 	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
 	}
 
-	_, err = s.CodeAction(context.Background(), &protocol.CodeActionParams{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
 	_, err = s.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{})
 	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
@@ -152,11 +136,6 @@ func TestNotImplemented(*testing.T) { // nolint: gocognit, funlen, gocyclo
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
 	}
 
-	_, err = s.Formatting(context.Background(), &protocol.DocumentFormattingParams{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
 	_, err = s.RangeFormatting(context.Background(), &protocol.DocumentRangeFormattingParams{})
 	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
@@ -177,21 +156,6 @@ func TestNotImplemented(*testing.T) { // nolint: gocognit, funlen, gocyclo
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
 	}
 
-	_, err = s.DocumentLink(context.Background(), &protocol.DocumentLinkParams{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
-	_, err = s.ResolveDocumentLink(context.Background(), &protocol.DocumentLink{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
-	_, err = s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{})
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
 	_, err = s.IncomingCalls(context.Background(), nil)
 	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
@@ -222,11 +186,6 @@ func TestNotImplemented(*testing.T) { // nolint: gocognit, funlen, gocyclo
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
 	}
 
-	err = s.WorkDoneProgressCancel(context.Background(), nil)
-	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
-		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
-	}
-
 	err = s.WorkDoneProgressCreate(context.Background(), nil)
 	if err != nil && err.(*jsonrpc2.Error).Code != jsonrpc2.CodeMethodNotFound {
 		panic("Expected a jsonrpc2 Error with CodeMethodNotFound")
@@ -267,27 +226,36 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 	_, server := ServerFromStream(context.Background(), stream, &Config{})
 	s := server.server
 
+	dir, err := ioutil.TempDir("", "cue-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	uri := cache.URIFromPath(filepath.Join(dir, "test.cue"))
+
 	// Initialize Server
-	_, err := s.Initialize(context.Background(), &protocol.ParamInitialize{})
+	_, err = s.Initialize(context.Background(), &protocol.ParamInitialize{})
 	if err != nil {
-		panic("Failed to initialize Server")
+		t.Fatal("Failed to initialize Server")
 	}
 
 	_, err = s.Initialize(context.Background(), &protocol.ParamInitialize{})
 	if err == nil {
-		panic("cannot initialize server twice")
+		t.Fatal("cannot initialize server twice")
 	}
 	// Confirm Initialisation
 	err = s.Initialized(context.Background(), &protocol.InitializedParams{})
 	if err != nil {
-		panic("Failed to initialize Server")
+		t.Fatal("Failed to initialize Server")
 	}
 
 	err = s.Initialized(context.Background(), &protocol.InitializedParams{})
 	if err == nil {
-		panic("cannot confirm server initialisation twice")
+		t.Fatal("cannot confirm server initialisation twice")
 	}
-	example_start := `
+	example_start := `package test
 	// A closed struct
 	defined :: {
 		a : string
@@ -315,7 +283,7 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 		b : c : 1
 	}
 	`
-	example_4 := `
+	example_4 := `package test
 	// An open struct
 	defined2 : {
 		a : string & != ""
@@ -332,14 +300,14 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 	// Add a document to the server
 	err = s.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
 		TextDocument: protocol.TextDocumentItem{
-			URI:        "test.cue",
+			URI:        uri,
 			LanguageID: "cue",
 			Version:    0,
 			Text:       "",
 		},
 	})
 	if err != nil {
-		panic("Failed to open document")
+		t.Fatal("Failed to open document")
 	}
 
 	// Apply a Full Change to the document
@@ -347,7 +315,7 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 		TextDocument: protocol.VersionedTextDocumentIdentifier{
 			Version: 2,
 			TextDocumentIdentifier: protocol.TextDocumentIdentifier{
-				URI: "test.cue",
+				URI: uri,
 			},
 		},
 		ContentChanges: []protocol.TextDocumentContentChangeEvent{
@@ -359,13 +327,13 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 		},
 	})
 	if err != nil {
-		panic("Failed to apply full change to document")
+		t.Fatal("Failed to apply full change to document")
 	}
 
 	hover, err := s.Hover(context.Background(), &protocol.HoverParams{
 		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
 			TextDocument: protocol.TextDocumentIdentifier{
-				URI: "test.cue",
+				URI: uri,
 			},
 			Position: protocol.Position{
 				Line:      9,
@@ -375,12 +343,12 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 	})
 
 	if err != nil {
-		panic("Failed to get hovertext")
+		t.Fatal("Failed to get hovertext")
 	}
 
-	if hover == nil || !strings.Contains(hover.Contents.Value, "defined : {") {
+	if hover == nil || !strings.Contains(hover.Contents.Value, "defined :: {") {
 		fmt.Println(hover)
-		panic("unexpected or no hovertext")
+		t.Fatal("unexpected or no hovertext")
 	}
 
 	// Apply a Full Change to the document
@@ -388,7 +356,7 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 		TextDocument: protocol.VersionedTextDocumentIdentifier{
 			Version: 3,
 			TextDocumentIdentifier: protocol.TextDocumentIdentifier{
-				URI: "test.cue",
+				URI: uri,
 			},
 		},
 		ContentChanges: []protocol.TextDocumentContentChangeEvent{
@@ -406,29 +374,28 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 	hover, err = s.Hover(context.Background(), &protocol.HoverParams{
 		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
 			TextDocument: protocol.TextDocumentIdentifier{
-				URI: "test.cue",
+				URI: uri,
 			},
 			Position: protocol.Position{
-				Line:      15,
-				Character: 3,
+				Line:      16,
+				Character: 11,
 			},
 		},
 	})
 
 	if err != nil {
-		panic("Failed to get hovertext")
+		t.Fatal("Failed to get hovertext")
 	}
 
-	if hover == nil || !strings.Contains(hover.Contents.Value, "value2 : defined") {
-		fmt.Println(hover.Contents.Value)
-		panic("unexpected or no hovertext")
+	if hover == nil || !strings.Contains(hover.Contents.Value, "defined :: {") {
+		t.Fatalf("unexpected or no hovertext: %v", hover)
 	}
 	// Apply a partial Change to the document
 	err = s.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
 		TextDocument: protocol.VersionedTextDocumentIdentifier{
 			Version: 4,
 			TextDocumentIdentifier: protocol.TextDocumentIdentifier{
-				URI: "test.cue",
+				URI: uri,
 			},
 		},
 		ContentChanges: []protocol.TextDocumentContentChangeEvent{
@@ -449,13 +416,13 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 		},
 	})
 	if err != nil {
-		panic(fmt.Sprintf("Failed to apply change to document: %s", err.Error()))
+		t.Fatal(fmt.Sprintf("Failed to apply change to document: %s", err.Error()))
 	}
 
 	// Wait for diagnostics
-	doc, err := s.cache.GetDocument("test.cue")
+	doc, err := s.workspace.GetDocument(uri)
 	if err != nil {
-		panic("Failed to get document")
+		t.Fatal("Failed to get document")
 	}
 
 	diagnostics, err := doc.GetDiagnostics()
@@ -464,7 +431,7 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 	}
 	if err == nil && len(diagnostics) == 0 {
 		cont, _ := doc.GetContent()
-		panic("expected nonempty diagnostics: " + cont)
+		t.Fatal("expected nonempty diagnostics: " + cont)
 	}
 
 	//t.Fatalf("Diagnostics: %v", diagnostics)
@@ -474,7 +441,7 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 		TextDocument: protocol.VersionedTextDocumentIdentifier{
 			Version: 6,
 			TextDocumentIdentifier: protocol.TextDocumentIdentifier{
-				URI: "test.cue",
+				URI: uri,
 			},
 		},
 		ContentChanges: []protocol.TextDocumentContentChangeEvent{
@@ -486,24 +453,23 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 		},
 	})
 	if err != nil {
-		panic("Failed to apply full change to document")
+		t.Fatal("Failed to apply full change to document")
 	}
 
 	completion, err := s.Completion(context.Background(), &protocol.CompletionParams{
 		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
 			TextDocument: protocol.TextDocumentIdentifier{
-				URI: "test.cue",
+				URI: uri,
 			},
 			Position: protocol.Position{
 				Line:      11,
-				Character: 7,
+				Character: 8,
 			},
 		},
 	})
 
-	if err != nil || completion == nil || len(completion.Items) == 0 || completion.Items[0].Label != "rate" {
-		fmt.Println(completion)
-		panic("Failed to get completion")
+	if err != nil || !hasCompletion(completion, "value2") {
+		t.Fatalf("Failed to get completion: %v", completion)
 	}
 
 	// Apply a Full Change to the document
@@ -511,153 +477,2239 @@ func TestServer(t *testing.T) { //nolint:funlen, gocognit, gocyclo
 		TextDocument: protocol.VersionedTextDocumentIdentifier{
 			Version: 7,
 			TextDocumentIdentifier: protocol.TextDocumentIdentifier{
-				URI: "test.cue",
+				URI: uri,
 			},
 		},
 		ContentChanges: []protocol.TextDocumentContentChangeEvent{
 			{
 				Range:       nil,
 				RangeLength: 0,
-				Text:        "rat()",
+				Text:        "package test\nrate: 1\nr: rat",
 			},
 		},
 	})
 	if err != nil {
-		panic("Failed to apply full change to document")
+		t.Fatal("Failed to apply full change to document")
 	}
 
 	completion, err = s.Completion(context.Background(), &protocol.CompletionParams{
 		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
 			TextDocument: protocol.TextDocumentIdentifier{
-				URI: "test.cue",
+				URI: uri,
 			},
 			Position: protocol.Position{
-				Line:      0.0,
-				Character: 1.0,
+				Line:      2.0,
+				Character: 6.0,
 			},
 		},
 	})
 
-	if err != nil || completion == nil || len(completion.Items) == 0 || completion.Items[0].Label != "rate" {
-		fmt.Println(completion)
-		panic("Failed to get completion")
+	if err != nil || !hasCompletion(completion, "rate") {
+		t.Fatalf("Failed to get completion: %v", completion)
 	}
 
 	// Close a document
 	err = s.DidClose(context.Background(), &protocol.DidCloseTextDocumentParams{
 		TextDocument: protocol.TextDocumentIdentifier{
-			URI: "test.cue",
+			URI: uri,
 		},
 	})
 	if err != nil {
-		panic("Failed to close document")
+		t.Fatal("Failed to close document")
 	}
 
-	_, err = s.cache.GetDocument("test.cue")
+	_, err = s.workspace.GetDocument(uri)
 	if err == nil {
-		panic("getting a closed document should have failed")
+		t.Fatal("getting a closed document should have failed")
 	}
 
 	// Close a document twice
 	err = s.DidClose(context.Background(), &protocol.DidCloseTextDocumentParams{
 		TextDocument: protocol.TextDocumentIdentifier{
-			URI: "test.cue",
+			URI: uri,
 		},
 	})
 	if err == nil {
-		panic("should have failed to close document")
+		t.Fatal("should have failed to close document")
 	}
 
 	// Reopen a closed document
 	err = s.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
 		TextDocument: protocol.TextDocumentItem{
-			URI:        "test.cue",
+			URI:        uri,
 			LanguageID: "cue",
 			Version:    0,
-			Text:       "abs()",
+			Text:       "package test\nabs: 1\n",
 		},
 	})
 	if err != nil {
-		panic("Failed to reopen document")
+		t.Fatal("Failed to reopen document")
+	}
+
+	if _, err = s.workspace.GetDocument(uri); err != nil {
+		t.Fatal("Failed to get reopened document")
 	}
 
-	signature, err := s.SignatureHelp(context.Background(), &protocol.SignatureHelpParams{
+	// CUE has no function calls to show signatures for.
+	_, err = s.SignatureHelp(context.Background(), &protocol.SignatureHelpParams{
 		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
 			TextDocument: protocol.TextDocumentIdentifier{
-				URI: "test.promql",
-			},
-			Position: protocol.Position{
-				Line:      1.0,
-				Character: 0.0,
+				URI: uri,
 			},
 		},
 	})
+	if err == nil {
+		t.Fatal("SignatureHelp should not be implemented")
+	}
+
+	// Shutdown Server
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal("Failed to initialize Server")
+	}
+
+	err = s.Shutdown(context.Background())
+	if err == nil {
+		t.Fatal("cannot shutdown server twice")
+	}
+	// Left out until it does something else than calling os.Exit()
+	// Confirm Shutdown
+	err = s.Exit(context.Background())
+	if err != nil {
+		t.Fatal("Failed to initialize Server")
+	}
+} // nolint:wsl
+
+// hasCompletion reports whether the completion list contains an item with the given label.
+func hasCompletion(completion *protocol.CompletionList, label string) bool {
+	if completion == nil {
+		return false
+	}
+
+	for _, item := range completion.Items {
+		if item.Label == label {
+			return true
+		}
+	}
 
+	return false
+}
+
+// testWorkspace creates a headless server with a temporary workspace folder
+// containing the given files, all of which are opened in the server.
+func testWorkspace(t *testing.T, files map[string]string) (*server, string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "cue-lsp")
 	if err != nil {
-		panic("Failed to get signature")
+		t.Fatal(err)
 	}
 
-	if signature != nil && len(signature.Signatures) != 0 {
-		fmt.Println(signature)
-		panic("Wrong number of signatures returned")
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	hs, err := CreateHeadlessServer(context.Background())
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	signature, err = s.SignatureHelp(context.Background(), &protocol.SignatureHelpParams{
-		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
-			TextDocument: protocol.TextDocumentIdentifier{
-				URI: "test.promql",
-			},
-			Position: protocol.Position{
-				Line:      0,
-				Character: 4,
+	s := hs.(*server)
+	s.workspace.addFolder(cache.URIFromPath(dir), s.config.CUE)
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err = s.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+			TextDocument: protocol.TextDocumentItem{
+				URI:        cache.URIFromPath(path),
+				LanguageID: "cue",
+				Text:       content,
 			},
+		})
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", name, err)
+		}
+	}
+
+	return s, dir
+}
+
+// testPosition returns the position params for the first occurrence of marker in the given file.
+//
+// If the marker contains a "|", the position is moved to its location inside the marker.
+func testPosition(t *testing.T, dir string, name string, content string, marker string) protocol.TextDocumentPositionParams {
+	t.Helper()
+
+	cursor := strings.Index(marker, "|")
+	if cursor < 0 {
+		cursor = 0
+	}
+
+	offset := strings.Index(content, strings.Replace(marker, "|", "", 1))
+	if offset < 0 {
+		t.Fatalf("marker %q not found in %s", marker, name)
+	}
+
+	offset += cursor
+
+	line := strings.Count(content[:offset], "\n")
+	char := offset - strings.LastIndex(content[:offset], "\n") - 1
+
+	return protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: cache.URIFromPath(filepath.Join(dir, name)),
 		},
+		Position: protocol.Position{
+			Line:      float64(line),
+			Character: float64(char),
+		},
+	}
+}
+
+const typesExample = `package types
+
+Deployment :: {
+	replicas: int
+}
+
+Service :: {
+	port: int
+}
+
+web: Deployment & {
+	replicas: 2
+}
+
+embedded: {
+	Deployment
+	Service
+	replicas: 1
+}
+
+other: {
+	replicas: 3
+}
+`
+
+func TestTypeDefinition(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{"types.cue": typesExample})
+
+	tests := []struct {
+		marker string
+		lines  []float64
+	}{
+		{"web:", []float64{2}},
+		{"embedded:", []float64{2, 6}},
+		{"Deployment &", []float64{2}},
+		{"other:", nil},
+	}
+
+	for _, test := range tests {
+		locs, err := s.TypeDefinition(context.Background(), &protocol.TypeDefinitionParams{
+			TextDocumentPositionParams: testPosition(t, dir, "types.cue", typesExample, test.marker),
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.marker, err)
+		}
+
+		lines := []float64{}
+		for _, loc := range locs {
+			lines = append(lines, loc.Range.Start.Line)
+		}
+
+		if fmt.Sprint(lines) != fmt.Sprint(test.lines) {
+			t.Errorf("%s: got type definitions on lines %v, expected %v", test.marker, lines, test.lines)
+		}
+	}
+}
+
+func TestImplementation(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{"types.cue": typesExample})
+
+	locs, err := s.Implementation(context.Background(), &protocol.ImplementationParams{
+		TextDocumentPositionParams: testPosition(t, dir, "types.cue", typesExample, "Deployment ::"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []float64{}
+	for _, loc := range locs {
+		lines = append(lines, loc.Range.Start.Line)
+	}
+
+	if fmt.Sprint(lines) != "[10 14]" {
+		t.Errorf("got implementations on lines %v, expected [10 14]", lines)
+	}
+}
+
+func TestModuleImplementation(t *testing.T) {
+	const defs = "package defs\n\nDeployment :: {\n\treplicas: int\n}\n"
+
+	s, dir := testWorkspace(t, map[string]string{
+		"cue.mod/module.cue": "module: \"example.com/m\"\n",
+		"defs/defs.cue":      defs,
 	})
 
+	// The package using the definition is not opened.
+	if err := os.MkdirAll(filepath.Join(dir, "apps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	apps := "package apps\n\nimport \"example.com/m/defs\"\n\nweb: defs.Deployment & {\n\treplicas: 2\n}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "apps", "apps.cue"), []byte(apps), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	locs, err := s.Implementation(context.Background(), &protocol.ImplementationParams{
+		TextDocumentPositionParams: testPosition(t, dir, "defs/defs.cue", defs, "Deployment ::"),
+	})
 	if err != nil {
-		panic("Failed to get signature")
+		t.Fatal(err)
 	}
 
-	if signature == nil || len(signature.Signatures) != 1 {
-		fmt.Println(signature.Signatures)
-		panic("Wrong number of signatures returned")
+	if len(locs) != 1 || locs[0].URI != cache.URIFromPath(filepath.Join(dir, "apps", "apps.cue")) || locs[0].Range.Start.Line != 4 {
+		t.Errorf("expected the implementation in the package that is not open, got %v", locs)
 	}
+}
 
-	hover, err = s.Hover(context.Background(), &protocol.HoverParams{
-		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
-			TextDocument: protocol.TextDocumentIdentifier{
-				URI: "test.promql",
-			},
-			Position: protocol.Position{
-				Line:      0.0,
-				Character: 1.0,
-			},
-		},
+func TestDocumentHighlight(t *testing.T) {
+	const example = `package highlight
+
+name: "outer"
+
+a: {
+	name: "inner"
+	ref:  name
+}
+
+b: {
+	ref: name
+}
+
+c: name
+`
+	s, dir := testWorkspace(t, map[string]string{"highlight.cue": example})
+
+	tests := []struct {
+		marker   string
+		expected string
+	}{
+		{`name: "outer"`, "[2:Write 10:Read 13:Read]"},
+		{`name: "inner"`, "[5:Write 6:Read]"},
+		{"c: |name", "[2:Write 10:Read 13:Read]"},
+		{"|c: name", "[13:Write]"},
+	}
+
+	for _, test := range tests {
+		pos := testPosition(t, dir, "highlight.cue", example, test.marker)
+		highlights, err := s.DocumentHighlight(context.Background(), &protocol.DocumentHighlightParams{
+			TextDocumentPositionParams: pos,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.marker, err)
+		}
+
+		got := []string{}
+		for _, h := range highlights {
+			got = append(got, fmt.Sprintf("%v:%v", h.Range.Start.Line, h.Kind))
+		}
+
+		if fmt.Sprint(got) != test.expected {
+			t.Errorf("%s: got highlights %v, expected %s", test.marker, got, test.expected)
+		}
+	}
+}
+
+func TestCodeLens(t *testing.T) {
+	const example = `package lens
+
+Schema :: {
+	a: int
+}
+
+_hidden: 1
+
+config: Schema & {
+	a: 1
+}
+
+#Def: {
+	b: int
+}
+`
+	const tool = `package lens
+
+command: {
+	hello: {}
+	bye: {}
+}
+`
+	s, dir := testWorkspace(t, map[string]string{
+		"lens.cue":      example,
+		"lens_tool.cue": tool,
 	})
 
+	lenses, err := s.CodeLens(context.Background(), &protocol.CodeLensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: cache.URIFromPath(filepath.Join(dir, "lens.cue"))},
+	})
 	if err != nil {
-		panic("Failed to get hovertext")
+		t.Fatal(err)
 	}
 
-	if hover == nil || strings.Contains("abs", hover.Contents.Value) {
-		fmt.Println(hover)
-		panic("unexpected or no hovertext")
+	got := []string{}
+	for _, l := range lenses {
+		got = append(got, fmt.Sprintf("%v:%s", l.Range.Start.Line, l.Command.Title))
 	}
 
-	// Shutdown Server
-	err = s.Shutdown(context.Background())
+	expected := "[0:export JSON 0:export YAML 0:vet 8:export JSON 8:export YAML 8:vet]"
+	if fmt.Sprint(got) != expected {
+		t.Errorf("got lenses %v, expected %s", got, expected)
+	}
+
+	lenses, err = s.CodeLens(context.Background(), &protocol.CodeLensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: cache.URIFromPath(filepath.Join(dir, "lens_tool.cue"))},
+	})
 	if err != nil {
-		panic("Failed to initialize Server")
+		t.Fatal(err)
 	}
 
-	err = s.Shutdown(context.Background())
+	got = []string{}
+	for _, l := range lenses {
+		got = append(got, fmt.Sprintf("%v:%s", l.Range.Start.Line, l.Command.Title))
+	}
+
+	expected = "[3:run command hello 4:run command bye]"
+	if fmt.Sprint(got) != expected {
+		t.Errorf("got lenses %v, expected %s", got, expected)
+	}
+}
+
+func TestRunToolCommand(t *testing.T) {
+	const tool = `package run
+
+import "tool/cli"
+
+command: greet: {
+	second: cli.Print & {
+		text:   "bye " + name
+		$after: first
+	}
+	first: cli.Print & {text: "hello " + name}
+}
+
+command: broken: task: {$id: "tool/unknown.Task"}
+`
+	s, dir := testWorkspace(t, map[string]string{
+		"run.cue":      "package run\n\nname: \"world\"\n",
+		"run_tool.cue": tool,
+	})
+	uri := cache.URIFromPath(filepath.Join(dir, "run_tool.cue"))
+
+	result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command:   runCommand,
+		Arguments: []interface{}{commandArgs{URI: uri, Name: "greet"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "hello world\nbye world\n" {
+		t.Errorf("got %q, expected the output of both tasks in order", result)
+	}
+
+	for _, name := range []string{"", "missing", "broken"} {
+		if _, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+			Command:   runCommand,
+			Arguments: []interface{}{commandArgs{URI: uri, Name: name}},
+		}); err == nil {
+			t.Errorf("expected an error for command %q", name)
+		}
+	}
+}
+
+func TestExecuteCommand(t *testing.T) {
+	const example = `package export
+
+Schema :: {
+	a: int
+}
+
+config: Schema & {
+	a: 1
+}
+
+incomplete: Schema
+`
+	s, dir := testWorkspace(t, map[string]string{"export.cue": example})
+	uri := cache.URIFromPath(filepath.Join(dir, "export.cue"))
+
+	tests := []struct {
+		command  string
+		args     commandArgs
+		expected string
+	}{
+		{exportCommand, commandArgs{URI: uri, Path: []string{"config"}, Format: "json"}, "{\n    \"a\": 1\n}\n"},
+		{exportCommand, commandArgs{URI: uri, Path: []string{"config"}, Format: "yaml"}, "a: 1\n"},
+		{vetCommand, commandArgs{URI: uri, Path: []string{"config"}}, "vet: no errors"},
+		{vetCommand, commandArgs{URI: uri}, "vet: value is valid, but incomplete"},
+		{evalCommand, commandArgs{URI: uri, Path: []string{"config"}}, "a: 1\n"},
+		{defCommand, commandArgs{URI: uri, Path: []string{"incomplete"}}, "close({\n\ta: int\n})\n"},
+	}
+
+	for _, test := range tests {
+		result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+			Command:   test.command,
+			Arguments: []interface{}{test.args},
+		})
+		if err != nil {
+			t.Fatalf("%s %v: %v", test.command, test.args, err)
+		}
+
+		if result != test.expected {
+			t.Errorf("%s %v: got %q, expected %q", test.command, test.args, result, test.expected)
+		}
+	}
+
+	_, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command:   exportCommand,
+		Arguments: []interface{}{commandArgs{URI: uri}},
+	})
 	if err == nil {
-		panic("cannot shutdown server twice")
+		t.Errorf("expected exporting an incomplete package to fail")
 	}
-	// Left out until it does something else than calling os.Exit()
-	// Confirm Shutdown
-	err = s.Exit(context.Background())
+}
+
+// editRecorder is a client that records all workspace edits it is asked to apply.
+type editRecorder struct {
+	headlessClient
+	edits []protocol.WorkspaceEdit
+}
+
+func (c *editRecorder) ApplyEdit(_ context.Context, params *protocol.ApplyWorkspaceEditParams) (*protocol.ApplyWorkspaceEditResponse, error) {
+	c.edits = append(c.edits, params.Edit)
+	return &protocol.ApplyWorkspaceEditResponse{Applied: true}, nil
+}
+
+func TestEditCommands(t *testing.T) {
+	const trimExample = `package edit
+
+foo: [string]: a: *1 | int
+foo: b: a: 1
+`
+	const fmtExample = `package edit
+
+b:   2
+`
+	s, dir := testWorkspace(t, map[string]string{"trim.cue": trimExample, "fmt.cue": fmtExample})
+	client := &editRecorder{}
+	s.client = client
+
+	trimURI := cache.URIFromPath(filepath.Join(dir, "trim.cue"))
+	fmtURI := cache.URIFromPath(filepath.Join(dir, "fmt.cue"))
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "data.yaml"), []byte("c: [1, 2]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command  string
+		args     commandArgs
+		uri      protocol.DocumentURI
+		expected string
+	}{
+		{trimCommand, commandArgs{URI: trimURI}, trimURI, "package edit\n\nfoo: [string]: a: *1 | int\nfoo: b: {}\n"},
+		{fmtPackageCommand, commandArgs{URI: trimURI}, fmtURI, "package edit\n\nb: 2\n"},
+	}
+
+	for _, test := range tests {
+		client.edits = nil
+
+		_, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+			Command:   test.command,
+			Arguments: []interface{}{test.args},
+		})
+		if err != nil {
+			t.Fatalf("%s %v: %v", test.command, test.args, err)
+		}
+
+		if len(client.edits) != 1 {
+			t.Fatalf("%s: expected one edit, got %d", test.command, len(client.edits))
+		}
+
+		changes := client.edits[0].Changes[string(test.uri)]
+		if len(changes) != 1 || changes[0].NewText != test.expected {
+			t.Errorf("%s: got %v, expected %q", test.command, changes, test.expected)
+		}
+	}
+
+	importArgs := []interface{}{commandArgs{URI: cache.URIFromPath(filepath.Join(dir, "data.yaml")), Package: "edit"}}
+
+	client.edits = nil
+
+	if _, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{Command: importCommand, Arguments: importArgs}); err == nil || len(client.edits) != 0 {
+		t.Errorf("expected importing to fail for clients that cannot create files, got %v", client.edits)
+	}
+
+	s.createFilesSupported = true
+
+	result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{Command: importCommand, Arguments: importArgs})
 	if err != nil {
-		panic("Failed to initialize Server")
+		t.Fatal(err)
 	}
-} // nolint:wsl
+
+	if msg, _ := result.(string); !strings.HasPrefix(msg, "import: created ") || !strings.HasSuffix(msg, "data.cue") {
+		t.Errorf("expected the import to report the created file, got %q", result)
+	}
+
+	if len(client.edits) != 1 || len(client.edits[0].DocumentChanges) != 2 {
+		t.Fatalf("expected an edit creating the file and adding its content, got %+v", client.edits)
+	}
+
+	if n := editedFiles(&client.edits[0]); n != 1 {
+		t.Errorf("expected the import to edit 1 file, got %d", n)
+	}
+
+	dataURI := cache.URIFromPath(filepath.Join(dir, "data.cue"))
+
+	create, ok := client.edits[0].DocumentChanges[0].(protocol.CreateFile)
+	if !ok || create.Kind != "create" || create.URI != dataURI || create.Options.Overwrite {
+		t.Errorf("expected data.cue to be created, got %+v", client.edits[0].DocumentChanges[0])
+	}
+
+	content, ok := client.edits[0].DocumentChanges[1].(protocol.TextDocumentEdit)
+	if !ok || content.TextDocument.URI != dataURI || content.TextDocument.Version != nil ||
+		len(content.Edits) != 1 || content.Edits[0].NewText != "package edit\n\nc: [1, 2]\n" {
+		t.Errorf("expected the imported content to be inserted into data.cue, got %+v", client.edits[0].DocumentChanges[1])
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "data.cue")); !os.IsNotExist(err) {
+		t.Errorf("the server must leave creating the file to the client, got %v", err)
+	}
+}
+
+func TestDocumentLink(t *testing.T) {
+	const example = `package links
+
+import (
+	"strings"
+	"example.com/sub"
+	"other.org/lib"
+)
+
+// See https://cuelang.org/docs.
+a: "https://example.com/a"
+b: strings.ToUpper(sub.x + lib.y)
+`
+	s, dir := testWorkspace(t, map[string]string{
+		"cue.mod/module.cue":              `module: "example.com"`,
+		"links.cue":                       example,
+		"sub/sub.cue":                     "package sub\n\nx: \"x\"\n",
+		"cue.mod/pkg/other.org/lib/y.cue": "package lib\n\ny: \"y\"\n",
+	})
+	uri := cache.URIFromPath(filepath.Join(dir, "links.cue"))
+
+	links, err := s.DocumentLink(context.Background(), &protocol.DocumentLinkParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		line   float64
+		target string
+	}{
+		{3, "https://pkg.go.dev/cuelang.org/go/pkg/strings"},
+		{4, string(cache.URIFromPath(filepath.Join(dir, "sub", "sub.cue")))},
+		{5, string(cache.URIFromPath(filepath.Join(dir, "cue.mod", "pkg", "other.org", "lib", "y.cue")))},
+		{8, "https://cuelang.org/docs"},
+		{9, "https://example.com/a"},
+	}
+
+	if len(links) != len(expected) {
+		t.Fatalf("expected %d links, got %v", len(expected), links)
+	}
+
+	for i, e := range expected {
+		link, err := s.ResolveDocumentLink(context.Background(), &links[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if link.Range.Start.Line != e.line || link.Target != e.target {
+			t.Errorf("link %d: got %v, expected line %v and target %s", i, link, e.line, e.target)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+rpc_trace: text
+cue:
+  eval_diagnostics: true
+  build_tags: [prod]
+  completion_detail: minimal
+  format:
+    use_spaces: true
+    tab_width: 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !config.CUE.EvalDiagnostics || config.CUE.CompletionDetail != minimalCompletionDetail ||
+		len(config.CUE.BuildTags) != 1 || !config.CUE.Format.UseSpaces || config.CUE.Format.TabWidth != 2 {
+		t.Errorf("unexpected settings %+v", config.CUE)
+	}
+
+	if _, err := ParseConfig([]byte("cue:\n  completion_detail: everything\n")); err == nil {
+		t.Errorf("expected invalid completion detail to be rejected")
+	}
+
+	if _, err := ParseConfig([]byte("cue:\n  diagnostics_delay: soon\n")); err == nil {
+		t.Errorf("expected invalid diagnostics delay to be rejected")
+	}
+
+	if _, err := ParseConfig([]byte("cue:\n  schemas:\n  - files: '*.yaml'\n    package: ./schemas\n    path: '#A &'\n")); err == nil {
+		t.Errorf("expected invalid schema path to be rejected")
+	}
+
+	if _, err := ParseConfig([]byte("cue:\n  schemas:\n  - files: '[*.yaml'\n    package: ./schemas\n")); err == nil {
+		t.Errorf("expected invalid schema glob to be rejected")
+	}
+
+	if _, err := ParseConfig([]byte("cue:\n  attributes:\n  - key: 'my team'\n")); err == nil {
+		t.Errorf("expected invalid attribute key to be rejected")
+	}
+
+	if _, err := ParseConfig([]byte("cue:\n  lint:\n    unused-imports: error\n")); err == nil {
+		t.Errorf("expected unknown lint rule to be rejected")
+	}
+
+	if _, err := ParseConfig([]byte("cue:\n  lint:\n    shadow: fatal\n")); err == nil {
+		t.Errorf("expected invalid lint severity to be rejected")
+	}
+
+	if _, err := ParseConfig([]byte("builtin_doc_url: https://docs.example.com/cue\n")); err == nil {
+		t.Errorf("expected a builtin doc url without placeholder to be rejected")
+	}
+
+	docs, err := ParseConfig([]byte("builtin_doc_url: https://docs.example.com/cue%20pkg/%s\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := docs.builtinDocURL("strings"); got != "https://docs.example.com/cue%20pkg/strings" {
+		t.Errorf("expected the import path to replace the placeholder, got %s", got)
+	}
+
+	settings, err := decodeSettings(config.CUE, map[string]interface{}{"evalDiagnostics": false})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.EvalDiagnostics || settings.CompletionDetail != minimalCompletionDetail {
+		t.Errorf("client settings should only override the fields they contain, got %+v", settings)
+	}
+
+	base := Settings{
+		BuildTags:  []string{"prod"},
+		Schemas:    []SchemaSettings{{Files: "*.yaml", Package: "./schemas"}},
+		Attributes: []AttributeSettings{{Key: "team", Args: []AttributeParamSettings{{Name: "owner"}}}},
+		Lint:       map[string]string{"shadow": "error"},
+	}
+
+	settings, err = decodeSettings(base, map[string]interface{}{
+		"buildTags":  []interface{}{"dev"},
+		"schemas":    []interface{}{map[string]interface{}{"files": "*.json"}},
+		"attributes": []interface{}{map[string]interface{}{"key": "owner", "args": []interface{}{map[string]interface{}{"name": "name"}}}},
+		"lint":       map[string]interface{}{"unused-import": "off"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(base, Settings{
+		BuildTags:  []string{"prod"},
+		Schemas:    []SchemaSettings{{Files: "*.yaml", Package: "./schemas"}},
+		Attributes: []AttributeSettings{{Key: "team", Args: []AttributeParamSettings{{Name: "owner"}}}},
+		Lint:       map[string]string{"shadow": "error"},
+	}) {
+		t.Errorf("decoding client settings must not change the base settings, got %+v", base)
+	}
+
+	if settings.Schemas[0].Files != "*.json" || settings.Lint["shadow"] != "error" || settings.Lint["unused-import"] != "off" {
+		t.Errorf("client settings should be merged into the base settings, got %+v", settings)
+	}
+}
+
+func TestEvalDiagnosticsSetting(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{"conflict.cue": "package conflict\n\na: 1\na: 2\n"})
+	uri := cache.URIFromPath(filepath.Join(dir, "conflict.cue"))
+
+	diagnostics, err := s.GetDiagnostics(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diagnostics.Diagnostics) != 0 {
+		t.Errorf("expected no diagnostics without evaluation, got %v", diagnostics.Diagnostics)
+	}
+
+	err = s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{
+			"cue": map[string]interface{}{"evalDiagnostics": true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics, err = s.GetDiagnostics(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diagnostics.Diagnostics) == 0 || !strings.Contains(diagnostics.Diagnostics[0].Message, "conflicting values") {
+		t.Errorf("expected a conflict to be reported, got %v", diagnostics.Diagnostics)
+	}
+}
+
+// diagnosticsRecorder is a client that records all published diagnostics.
+type diagnosticsRecorder struct {
+	headlessClient
+	mu        sync.Mutex
+	published []*protocol.PublishDiagnosticsParams
+}
+
+func (c *diagnosticsRecorder) PublishDiagnostics(_ context.Context, params *protocol.PublishDiagnosticsParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.published = append(c.published, params)
+
+	return nil
+}
+
+func TestWorkspaceFolders(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{"a.cue": "package a\n\na: 1\n"})
+	client := &diagnosticsRecorder{}
+	s.client = client
+
+	other, err := ioutil.TempDir("", "cue-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(other)
+
+	otherURI := cache.URIFromPath(other)
+	bURI := cache.URIFromPath(filepath.Join(other, "b.cue"))
+
+	if err := ioutil.WriteFile(filepath.Join(other, "b.cue"), []byte("package b\n\nb: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: bURI, LanguageID: "cue", Text: "package b\n\nb: 1\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f := s.workspace.folderFor(bURI); f != s.workspace.fallback {
+		t.Fatalf("documents outside of all folders should use the fallback folder, got %v", f.uri)
+	}
+
+	err = s.DidChangeWorkspaceFolders(context.Background(), &protocol.DidChangeWorkspaceFoldersParams{
+		Event: protocol.WorkspaceFoldersChangeEvent{
+			Added: []protocol.WorkspaceFolder{{URI: string(otherURI), Name: "other"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := s.workspace.folderFor(bURI)
+	if f.uri != otherURI {
+		t.Fatalf("expected b.cue to belong to %v, got %v", otherURI, f.uri)
+	}
+
+	if _, err := f.cache.GetDocument(bURI); err != nil {
+		t.Errorf("b.cue should have moved to the added folder: %v", err)
+	}
+
+	if f := s.workspace.folderFor(cache.URIFromPath(filepath.Join(dir, "a.cue"))); f.uri != cache.URIFromPath(dir) {
+		t.Errorf("a.cue should stay in its folder, got %v", f.uri)
+	}
+
+	err = s.DidChangeWorkspaceFolders(context.Background(), &protocol.DidChangeWorkspaceFoldersParams{
+		Event: protocol.WorkspaceFoldersChangeEvent{
+			Removed: []protocol.WorkspaceFolder{{URI: string(otherURI), Name: "other"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.published) == 0 || client.published[0].URI != bURI || len(client.published[0].Diagnostics) != 0 {
+		t.Errorf("expected the diagnostics of b.cue to be cleared, got %v", client.published)
+	}
+
+	if _, err := s.workspace.GetDocument(bURI); err != nil {
+		t.Errorf("b.cue should still be open after removing its folder: %v", err)
+	}
+}
+
+func TestNestedModule(t *testing.T) {
+	const example = `package a
+
+import "example.com/mod/b"
+
+a: b.b
+`
+	s, dir := testWorkspace(t, map[string]string{
+		"mod/cue.mod/module.cue": `module: "example.com/mod"`,
+		"mod/a/a.cue":            example,
+		"mod/b/b.cue":            "package b\n\nb: 1\n",
+	})
+	uri := cache.URIFromPath(filepath.Join(dir, "mod", "a", "a.cue"))
+
+	diagnostics, err := s.GetDiagnostics(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diagnostics.Diagnostics) != 0 {
+		t.Errorf("imports of a nested module should resolve, got %v", diagnostics.Diagnostics)
+	}
+
+	hover, err := s.Hover(context.Background(), &protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     protocol.Position{Line: 0, Character: 9},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hover == nil || !strings.Contains(hover.Contents.Value, "Module `example.com/mod`") ||
+		!strings.Contains(hover.Contents.Value, "Import path `example.com/mod/a`") {
+		t.Errorf("expected the module in the hover of the package clause, got %v", hover)
+	}
+}
+
+func TestParseListenAddress(t *testing.T) {
+	tests := []struct {
+		listen  string
+		network string
+		addr    string
+		err     bool
+	}{
+		{listen: "tcp:localhost:4389", network: "tcp", addr: "localhost:4389"},
+		{listen: "unix:/tmp/cue-lsp.sock", network: "unix", addr: "/tmp/cue-lsp.sock"},
+		{listen: "localhost", err: true},
+		{listen: "udp:localhost:4389", err: true},
+		{listen: "tcp:", err: true},
+	}
+
+	for _, tc := range tests {
+		network, addr, err := ParseListenAddress(tc.listen)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: unexpected error %v", tc.listen, err)
+			continue
+		}
+
+		if network != tc.network || addr != tc.addr {
+			t.Errorf("%s: got %s %s, want %s %s", tc.listen, network, addr, tc.network, tc.addr)
+		}
+	}
+}
+
+func TestSharedServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cue-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ss := NewSharedServer(ctx, &Config{}).(*sharedServer)
+
+	// connect attaches a new client and returns the server as seen by the client.
+	connect := func() (protocol.Server, net.Conn, chan error) {
+		clientEnd, serverEnd := net.Pipe()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- ss.ServeStream(ctx, jsonrpc2.NewHeaderStream(serverEnd, serverEnd))
+		}()
+
+		conn := jsonrpc2.NewConn(jsonrpc2.NewHeaderStream(clientEnd, clientEnd))
+		conn.AddHandler(protocol.ClientHandler(headlessClient{}))
+
+		go conn.Run(ctx) // nolint: errcheck
+
+		srv := protocol.ServerDispatcher(conn)
+
+		if _, err := srv.Initialize(ctx, &protocol.ParamInitialize{
+			InitializeParams: protocol.InitializeParams{
+				InnerInitializeParams: protocol.InnerInitializeParams{RootURI: cache.URIFromPath(dir)},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := srv.Initialized(ctx, &protocol.InitializedParams{}); err != nil {
+			t.Fatal(err)
+		}
+
+		return srv, clientEnd, done
+	}
+
+	uri := cache.URIFromPath(filepath.Join(dir, "a.cue"))
+	open := &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: uri, LanguageID: "cue", Text: "a: 1\n"},
+	}
+
+	// Messages of a connection are handled in order, so once a request returns,
+	// the notifications the client sent before have been handled.
+	flush := func(srv protocol.Server) {
+		srv.Hover(ctx, &protocol.HoverParams{ // nolint: errcheck
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			},
+		})
+	}
+
+	a, _, _ := connect()
+	b, bConn, bDone := connect()
+
+	if err := a.DidOpen(ctx, open); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.DidOpen(ctx, open); err != nil {
+		t.Fatal(err)
+	}
+
+	flush(a)
+	flush(b)
+
+	if folders := ss.workspace.getFolders(false); len(folders) != 1 {
+		t.Errorf("expected both clients to share one folder, got %d", len(folders))
+	}
+
+	if err := a.DidClose(ctx, &protocol.DidCloseTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	flush(a)
+
+	if _, err := ss.workspace.GetDocument(uri); err != nil {
+		t.Errorf("document should stay open while another client has it open: %v", err)
+	}
+
+	bConn.Close()
+
+	if err := <-bDone; err != nil {
+		t.Errorf("unexpected error after disconnecting: %v", err)
+	}
+
+	if _, err := ss.workspace.GetDocument(uri); err == nil {
+		t.Error("document should be closed after the last client disconnected")
+	}
+}
+
+func TestListenAndServeIdleTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cue-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ListenAndServe(context.Background(), "unix", filepath.Join(dir, "lsp.sock"), &Config{}, 10*time.Millisecond)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the server to stop without error, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("server did not stop after the idle timeout")
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{"a.cue": "package a\n\na:    1\n"})
+	uri := cache.URIFromPath(filepath.Join(dir, "a.cue"))
+
+	edits, err := s.Formatting(context.Background(), &protocol.DocumentFormattingParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(edits) != 1 || edits[0].NewText != "package a\n\na: 1\n" || edits[0].Range.End.Line != 3 {
+		t.Errorf("unexpected edits %v", edits)
+	}
+}
+
+// progressRecorder is a client that records all progress notifications.
+type progressRecorder struct {
+	headlessClient
+	mu      sync.Mutex
+	created []protocol.ProgressToken
+	values  []interface{}
+}
+
+func (c *progressRecorder) WorkDoneProgressCreate(_ context.Context, params *protocol.WorkDoneProgressCreateParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.created = append(c.created, params.Token)
+
+	return nil
+}
+
+func (c *progressRecorder) Progress(_ context.Context, params *protocol.ProgressParams) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values = append(c.values, params.Value)
+
+	return nil
+}
+
+func TestWorkDoneProgress(t *testing.T) {
+	defer func(delay time.Duration) { progressDelay = delay }(progressDelay)
+
+	// The progress is only shown once begin is called below.
+	progressDelay = time.Hour
+
+	client := &progressRecorder{}
+	s := &server{client: client, progressSupported: true, lifetime: context.Background()}
+
+	quick := s.startWorkDone("quick", false, func() {})
+	quick.Report("working", 50)
+	quick.End("done")
+
+	if len(client.created) != 0 || len(client.values) != 0 {
+		t.Errorf("operations ending before the delay should not be reported, got %v", client.values)
+	}
+
+	cancelled := false
+
+	slow := s.startWorkDone("slow", true, func() { cancelled = true })
+	slow.Report("loading", 10)
+	slow.begin()
+	slow.Report("evaluating", 60)
+
+	if err := s.WorkDoneProgressCancel(context.Background(), &protocol.WorkDoneProgressCancelParams{Token: slow.token}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !cancelled {
+		t.Error("cancelling the progress should cancel the operation")
+	}
+
+	slow.End("cancelled")
+
+	if len(client.created) != 1 || client.created[0] != slow.token {
+		t.Errorf("expected the token %s to be created, got %v", slow.token, client.created)
+	}
+
+	expected := []interface{}{
+		&protocol.WorkDoneProgressBegin{Kind: "begin", Title: "slow", Cancellable: true, Message: "loading", Percentage: 10},
+		&protocol.WorkDoneProgressReport{Kind: "report", Cancellable: true, Message: "evaluating", Percentage: 60},
+		&protocol.WorkDoneProgressEnd{Kind: "end", Message: "cancelled"},
+	}
+
+	if !reflect.DeepEqual(client.values, expected) {
+		t.Errorf("expected %v, got %v", expected, client.values)
+	}
+
+	// Cancelling an operation that ended is not an error.
+	if err := s.WorkDoneProgressCancel(context.Background(), &protocol.WorkDoneProgressCancelParams{Token: slow.token}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCancelledRequest(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{"a.cue": "package a\n\na: 1\n"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.Hover(ctx, &protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: cache.URIFromPath(filepath.Join(dir, "a.cue"))},
+			Position:     protocol.Position{Line: 2, Character: 0},
+		},
+	})
+
+	if jerr, ok := err.(*jsonrpc2.Error); !ok || jerr.Code != protocol.RequestCancelledError {
+		t.Errorf("expected a RequestCancelledError, got %v", err)
+	}
+}
+
+func TestDiagnosticsScheduling(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{"a.cue": "package a\n\na: 1\n"})
+	uri := cache.URIFromPath(filepath.Join(dir, "a.cue"))
+
+	client := &diagnosticsRecorder{}
+	s.client = client
+	s.headless = false
+
+	before := s.workspace.scheduler.Compiles()
+
+	const edits = 100
+
+	for version := 1; version <= edits; version++ {
+		err := s.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+			TextDocument: protocol.VersionedTextDocumentIdentifier{
+				Version:                float64(version),
+				TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+			},
+			ContentChanges: []protocol.TextDocumentContentChangeEvent{{
+				Text: fmt.Sprintf("package a\n\na: %d\nb: a & string\n", version),
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diagnostics, err := s.GetDiagnostics(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diagnostics.Version != edits {
+		t.Errorf("expected the diagnostics of version %d, got %v", edits, diagnostics.Version)
+	}
+
+	// The burst is coalesced into the compile of the last version, unless the test machine is very slow.
+	if compiles := s.workspace.scheduler.Compiles() - before; compiles > 3 {
+		t.Errorf("expected the burst of %d edits to be compiled at most 3 times, got %d compiles", edits, compiles)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		client.mu.Lock()
+		n := len(client.published)
+		client.mu.Unlock()
+
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Give outdated publishers the chance to misbehave.
+	time.Sleep(50 * time.Millisecond)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if len(client.published) == 0 {
+		t.Fatal("no diagnostics were published")
+	}
+
+	for _, p := range client.published {
+		if p.Version != edits {
+			t.Errorf("only the diagnostics of the latest version should be published, got version %v", p.Version)
+		}
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := StartTelemetry(ctx, &Config{MetricsAddress: "127.0.0.1:0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	_, s := ServerFromStream(ctx, jsonrpc2.NewHeaderStream(serverEnd, serverEnd), &Config{})
+	go s.Run() // nolint: errcheck
+
+	conn := jsonrpc2.NewConn(jsonrpc2.NewHeaderStream(clientEnd, clientEnd))
+	conn.AddHandler(protocol.ClientHandler(headlessClient{}))
+
+	go conn.Run(ctx) // nolint: errcheck
+
+	srv := protocol.ServerDispatcher(conn)
+
+	series := []string{
+		`cue_lsp_request_latency_ms_count{method="initialize"}`,
+		`cue_lsp_request_latency_ms_count{method="textDocument/formatting"}`,
+		`cue_lsp_request_errors{method="textDocument/formatting",code="-32603"}`,
+	}
+
+	// The exporter is global, so other servers may have recorded requests as well.
+	before := scrapeMetrics(t, series)
+
+	if _, err := srv.Initialize(ctx, &protocol.ParamInitialize{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := srv.Formatting(ctx, &protocol.DocumentFormattingParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: "file:///missing.cue"},
+	})
+	if err == nil {
+		t.Fatal("expected formatting an unknown document to fail")
+	}
+
+	after := scrapeMetrics(t, series)
+
+	for _, name := range series {
+		if after[name]-before[name] != 1 {
+			t.Errorf("expected %s to increase by 1, got %v before and %v after", name, before[name], after[name])
+		}
+	}
+}
+
+// scrapeMetrics returns the values of the given series of the metrics endpoint, 0 for missing ones.
+func scrapeMetrics(t *testing.T, series []string) map[string]float64 {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	values := make(map[string]float64, len(series))
+
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		for _, name := range series {
+			if !strings.HasPrefix(line, name+" ") {
+				continue
+			}
+
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, name+" "), 64)
+			if err != nil {
+				t.Fatalf("invalid sample %q: %v", line, err)
+			}
+
+			values[name] = v
+		}
+	}
+
+	return values
+}
+
+func TestDebugServer(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{"a.cue": "package a\n\nfoo: bar: 1\nbaz: foo.bar\n"})
+	uri := cache.URIFromPath(filepath.Join(dir, "a.cue"))
+
+	if _, err := s.GetDiagnostics(uri); err != nil {
+		t.Fatal(err)
+	}
+
+	traces := s.workspace.rpcTraces()
+	for i := 0; i < maxTraces+1; i++ {
+		fmt.Fprintf(traces, `{"id":%d,"method":"textDocument/hover"}`+"\n", i)
+	}
+
+	if got := traces.get(); len(got) != maxTraces || !strings.Contains(got[0], `"id":1,`) {
+		t.Errorf("expected the %d most recent messages, oldest first, got %d starting with %q", maxTraces, len(got), got[0])
+	}
+
+	handler := newDebugServer(s.workspace).handler()
+
+	for _, tc := range []struct {
+		path     string
+		expected []string
+	}{
+		{"/", []string{"Open documents</th><td>1<", string(cache.URIFromPath(dir))}},
+		{"/documents", []string{string(uri), "<td>cue</td>", "/asg?uri="}},
+		{"/asg?uri=" + string(uri), []string{"[*asg.Package]", "[*asg.Decl", "]: bar", "-&gt; *asg.Decl"}},
+		{"/rpc", []string{"textDocument/hover", `&#34;id&#34;:200`}},
+		{"/memory", []string{"Goroutines", "Heap in use"}},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+
+		if rec.Code != 200 {
+			t.Errorf("%s: expected status 200, got %d: %s", tc.path, rec.Code, rec.Body.String())
+			continue
+		}
+
+		for _, expected := range tc.expected {
+			if !strings.Contains(rec.Body.String(), expected) {
+				t.Errorf("%s: expected the page to contain %q, got\n%s", tc.path, expected, rec.Body.String())
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/asg?uri=file:///missing.cue", nil))
+
+	if rec.Code != 404 {
+		t.Errorf("expected status 404 for an unknown document, got %d", rec.Code)
+	}
+}
+
+func TestDataValidation(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{
+		"schemas/schema.cue": "package schemas\n\n#Deployment: {\n\tname:     string\n\treplicas: int & >0\n}\n",
+	})
+
+	err := s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{
+			"cue": map[string]interface{}{
+				"schemas": []interface{}{
+					map[string]interface{}{"files": "deploy/*.json", "package": "./schemas", "path": "#Deployment"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		languageID string
+		content    string
+		// The expected diagnostics, as their range and the start of the message.
+		expected []string
+	}{
+		{
+			name:       "deploy/web.json",
+			languageID: "json",
+			content:    "{\n  \"name\": \"web\",\n  \"replicas\": 0\n}\n",
+			expected:   []string{"2:14-2:15 replicas: invalid value 0"},
+		},
+		{
+			name:       "deploy/valid.json",
+			languageID: "json",
+			content:    "{\"name\": \"web\", \"replicas\": 2}\n",
+		},
+		{
+			name:       "other/unmapped.json",
+			languageID: "json",
+			content:    "{\"replicas\": \"two\"}\n",
+		},
+		{
+			name:       "k8s/web.yaml",
+			languageID: "yaml",
+			content:    "# @schema ../schemas #Deployment\nname: web\nreplicas: two\n",
+			expected:   []string{"2:10-2:13 replicas: conflicting values"},
+		},
+		{
+			name:       "k8s/stream.yaml",
+			languageID: "yaml",
+			content:    "# @schema ../schemas #Deployment\nname: a\nreplicas: 1\n---\nname: 2\nreplicas: 1\n",
+			expected:   []string{"4:6-4:7 name: conflicting values"},
+		},
+		{
+			name:       "k8s/missing.yaml",
+			languageID: "yaml",
+			content:    "# @schema ../nothing #Deployment\nname: web\n",
+			expected:   []string{"0:0-0:0 cannot load schema ../nothing #Deployment"},
+		},
+	} {
+		uri := cache.URIFromPath(filepath.Join(dir, tc.name))
+
+		err := s.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+			TextDocument: protocol.TextDocumentItem{URI: uri, LanguageID: tc.languageID, Text: tc.content},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diagnostics, err := s.GetDiagnostics(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, d := range diagnostics.Diagnostics {
+			got = append(got, fmt.Sprintf("%v:%v-%v:%v %s", d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character, d.Message))
+		}
+
+		if len(got) != len(tc.expected) {
+			t.Errorf("%s: expected %d diagnostics, got %q", tc.name, len(tc.expected), got)
+			continue
+		}
+
+		for i, expected := range tc.expected {
+			if !strings.HasPrefix(got[i], expected) {
+				t.Errorf("%s: expected a diagnostic starting with %q, got %q", tc.name, expected, got[i])
+			}
+		}
+	}
+}
+
+func TestAutoImportCompletion(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{
+		"cue.mod/module.cue": "module: \"example.com/m\"\n",
+		"defs/defs.cue":      "package defs\n\n// Thing is a thing.\n#Thing: {a: int}\n\nnotADefinition: 1\n",
+		"a/none.cue":         "package a\n\nx: 1\ny: str\n",
+		"a/single.cue":       "package a\n\nimport \"list\"\n\nz: str\n",
+		"a/block.cue":        "package a\n\nimport (\n\t\"list\"\n\t\"struct\"\n)\n\nw: str\n",
+	})
+
+	unresolved := map[string]protocol.CompletionItem{}
+
+	complete := func(name string, line, character float64) map[string]protocol.CompletionItem {
+		t.Helper()
+
+		completion, err := s.Completion(context.Background(), &protocol.CompletionParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: cache.URIFromPath(filepath.Join(dir, name))},
+				Position:     protocol.Position{Line: line, Character: character},
+			},
+		})
+		if err != nil || completion == nil {
+			t.Fatalf("%s: completion failed: %v", name, err)
+		}
+
+		items := map[string]protocol.CompletionItem{}
+		for i := range completion.Items {
+			unresolved[completion.Items[i].Label] = completion.Items[i]
+
+			item, err := s.Resolve(context.Background(), &completion.Items[i])
+			if err != nil {
+				t.Fatalf("%s: resolving %s failed: %v", name, completion.Items[i].Label, err)
+			}
+
+			items[item.Label] = *item
+		}
+
+		return items
+	}
+
+	importOf := func(item protocol.CompletionItem) string {
+		if len(item.AdditionalTextEdits) != 1 {
+			return fmt.Sprintf("%d edits", len(item.AdditionalTextEdits))
+		}
+
+		e := item.AdditionalTextEdits[0]
+
+		return fmt.Sprintf("%v:%v-%v:%v %q", e.Range.Start.Line, e.Range.Start.Character, e.Range.End.Line, e.Range.End.Character, e.NewText)
+	}
+
+	items := complete("a/none.cue", 3, 6)
+
+	upper, ok := items["strings.ToUpper"]
+	if !ok {
+		t.Fatalf("expected strings.ToUpper to be offered")
+	}
+
+	if got, expected := importOf(upper), `0:9-0:9 "\n\nimport \"strings\""`; got != expected {
+		t.Errorf("expected the import to be added after the package clause with %s, got %s", expected, got)
+	}
+
+	if upper.TextEdit == nil || upper.TextEdit.NewText != "strings.ToUpper(${1:string})" {
+		t.Errorf("expected a snippet with the arguments, got %+v", upper.TextEdit)
+	}
+
+	// Clients that do not resolve edits get the import and the snippet right away.
+	if u := unresolved["strings.ToUpper"]; importOf(u) != importOf(upper) || u.TextEdit == nil || *u.TextEdit != *upper.TextEdit ||
+		u.InsertTextFormat != protocol.SnippetTextFormat || u.Detail != "" || u.Documentation.Value != "" {
+		t.Errorf("expected the unresolved item to have the edits, but no detail or documentation, got %+v", u)
+	}
+
+	if x, ok := items["x"]; !ok || x.SortText >= upper.SortText {
+		t.Errorf("expected symbols in scope to be ranked before auto imports, got %q and %q", x.SortText, upper.SortText)
+	}
+
+	thing, ok := items["defs.#Thing"]
+	if !ok {
+		t.Fatalf("expected definitions of module packages to be offered")
+	}
+
+	if !strings.Contains(importOf(thing), `import \"example.com/m/defs\"`) || !strings.Contains(thing.Documentation.Value, "Thing is a thing.") {
+		t.Errorf("unexpected item %+v", thing)
+	}
+
+	if _, ok := items["defs.notADefinition"]; ok {
+		t.Errorf("only definitions of module packages should be offered")
+	}
+
+	items = complete("a/single.cue", 4, 6)
+
+	if got, expected := importOf(items["strings.ToUpper"]), `2:0-2:13 "import (\n\t\"list\"\n\t\"strings\"\n)"`; got != expected {
+		t.Errorf("expected a single import to become a block with %s, got %s", expected, got)
+	}
+
+	if _, ok := items["list.Sum"]; ok {
+		t.Errorf("members of imported packages should not need an import")
+	}
+
+	items = complete("a/block.cue", 7, 6)
+
+	if got, expected := importOf(items["time.Nanosecond"]), `5:0-5:0 "\t\"time\"\n"`; got != expected {
+		t.Errorf("expected the import to be merged into the block with %s, got %s", expected, got)
+	}
+
+	if got, expected := importOf(items["strings.ToUpper"]), `4:0-4:0 "\t\"strings\"\n"`; got != expected {
+		t.Errorf("expected the import to be sorted into the block with %s, got %s", expected, got)
+	}
+}
+
+func TestCompletionResolve(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{
+		"a.cue": "package a\n\nimport \"strings\"\n\n// foo is documented.\nfoo: 1\nbar: strings.ToU\nbaz: fo\n",
+	})
+
+	uri := cache.URIFromPath(filepath.Join(dir, "a.cue"))
+
+	complete := func(line, character float64) map[string]protocol.CompletionItem {
+		t.Helper()
+
+		completion, err := s.Completion(context.Background(), &protocol.CompletionParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+				Position:     protocol.Position{Line: line, Character: character},
+			},
+		})
+		if err != nil || completion == nil {
+			t.Fatalf("completion failed: %v", err)
+		}
+
+		items := map[string]protocol.CompletionItem{}
+		for _, item := range completion.Items {
+			items[item.Label] = item
+		}
+
+		return items
+	}
+
+	upper, ok := complete(6, 16)["ToUpper"]
+	if !ok {
+		t.Fatalf("expected ToUpper to be offered")
+	}
+
+	if upper.Documentation.Value != "" || upper.InsertText != "ToUpper(${1:string})" || upper.InsertTextFormat != protocol.SnippetTextFormat || upper.Data == nil {
+		t.Errorf("expected an item with the snippet and data, but without documentation, got %+v", upper)
+	}
+
+	// Items are sent to the client and back as JSON.
+	raw, err := json.Marshal(upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent protocol.CompletionItem
+	if err := json.Unmarshal(raw, &sent); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := s.Resolve(context.Background(), &sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved.Documentation.Value == "" || resolved.InsertText != "ToUpper(${1:string})" || resolved.InsertTextFormat != protocol.SnippetTextFormat {
+		t.Errorf("expected the documentation and snippet to be resolved, got %+v", resolved)
+	}
+
+	foo, ok := complete(7, 7)["foo"]
+	if !ok {
+		t.Fatalf("expected foo to be offered")
+	}
+
+	resolved, err = s.Resolve(context.Background(), &foo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(resolved.Documentation.Value, "foo is documented.") {
+		t.Errorf("expected the comment of foo to be resolved, got %q", resolved.Documentation.Value)
+	}
+
+	// Items of lists that have been replaced by newer lists stay as they are.
+	for i := 0; i < maxCompletionLists; i++ {
+		complete(7, 7)
+	}
+
+	resolved, err = s.Resolve(context.Background(), &sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved.Documentation.Value != "" {
+		t.Errorf("expected a stale item to be returned unchanged, got %+v", resolved)
+	}
+
+	invalid := protocol.CompletionItem{Label: "x", Data: "nonsense"}
+	if _, err := s.Resolve(context.Background(), &invalid); err == nil {
+		t.Errorf("expected invalid data to be rejected")
+	}
+
+	// Clients that list the edits in their resolveSupport get the snippet when resolving.
+	s.editResolveSupported = resolvesEdits([]string{"documentation", "detail", "insertText", "textEdit", "insertTextFormat", "additionalTextEdits"})
+
+	upper = complete(6, 16)["ToUpper"]
+	if upper.InsertText != "ToUpper" || upper.InsertTextFormat == protocol.SnippetTextFormat {
+		t.Errorf("expected an item without snippet, got %+v", upper)
+	}
+
+	resolved, err = s.Resolve(context.Background(), &upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved.Documentation.Value == "" || resolved.InsertText != "ToUpper(${1:string})" || resolved.InsertTextFormat != protocol.SnippetTextFormat {
+		t.Errorf("expected the documentation and snippet to be resolved, got %+v", resolved)
+	}
+}
+
+func TestKeywordCompletion(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{
+		"a.cue": "package a\n\nimport \"list\"\n\nfo\nx: {\n\tba\n\t\n}\ny: string\nz: [li]\n\nw: 1\n",
+		"b.cue": "pa\n",
+	})
+
+	labels := func(name string, line, character float64) map[string]protocol.CompletionItem {
+		t.Helper()
+
+		completion, err := s.Completion(context.Background(), &protocol.CompletionParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: cache.URIFromPath(filepath.Join(dir, name))},
+				Position:     protocol.Position{Line: line, Character: character},
+			},
+		})
+		if err != nil || completion == nil {
+			t.Fatalf("%s: completion failed: %v", name, err)
+		}
+
+		items := map[string]protocol.CompletionItem{}
+		for _, item := range completion.Items {
+			if item.Kind == protocol.KeywordCompletion || item.Kind == protocol.SnippetCompletion {
+				items[item.Label] = item
+			}
+		}
+
+		return items
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		line     float64
+		char     float64
+		expected []string
+	}{
+		{"start of file", "b.cue", 0, 2, []string{"package", "import", "for", "if", "let", "#Definition", "Definition ::", "optional field", "[string]: constraint"}},
+		{"after imports", "a.cue", 4, 2, []string{"import", "for", "if", "let", "#Definition", "Definition ::", "optional field", "[string]: constraint"}},
+		{"struct body", "a.cue", 6, 3, []string{"for", "if", "let", "#Definition", "Definition ::", "optional field", "[string]: constraint"}},
+		{"empty line in struct", "a.cue", 7, 1, []string{"for", "if", "let", "#Definition", "Definition ::", "optional field", "[string]: constraint"}},
+		{"after value", "a.cue", 9, 9, []string{"@tag()"}},
+		{"list element", "a.cue", 10, 6, []string{"for", "if"}},
+		{"label", "a.cue", 12, 0, nil},
+	}
+
+	for _, test := range tests {
+		items := labels(test.file, test.line, test.char)
+
+		var got []string
+		for label := range items {
+			got = append(got, label)
+		}
+
+		sort.Strings(got)
+
+		expected := append([]string{}, test.expected...)
+		sort.Strings(expected)
+
+		if !reflect.DeepEqual(got, expected) && !(len(got) == 0 && len(expected) == 0) {
+			t.Errorf("%s: expected %v, got %v", test.name, expected, got)
+		}
+	}
+
+	item := labels("a.cue", 6, 3)["for"]
+	if item.TextEdit == nil || item.TextEdit.NewText != "for ${1:k}, ${2:v} in ${3:source} {\n\t$0\n}" || item.InsertTextFormat != protocol.SnippetTextFormat {
+		t.Fatalf("expected for to replace the reference with the snippet, got %+v", item)
+	}
+
+	resolved, err := s.Resolve(context.Background(), &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved.Documentation.Value == "" || *resolved.TextEdit != *item.TextEdit {
+		t.Errorf("expected the documentation to be resolved, got %+v", resolved)
+	}
+
+	if def := labels("a.cue", 6, 3)["Definition ::"]; def.TextEdit == nil || def.TextEdit.NewText != "${1:Name} :: {\n\t$0\n}" {
+		t.Errorf("expected a definition skeleton with ::, got %+v", def)
+	}
+
+	item = labels("a.cue", 9, 9)["@tag()"]
+
+	tag, err := s.Resolve(context.Background(), &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := tag.TextEdit.Range; r.Start != r.End || r.Start.Character != 9 || tag.TextEdit.NewText != " @tag(${1:name})" {
+		t.Errorf("expected the attribute to be inserted behind the value, got %+v", tag.TextEdit)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{
+		"a.cue": "package a\n\nenv: string @tag(env,type=float)\nname: string @go(Name,unknown)\nowner: string @team()\nbroken: int @go(\"x\"y)\n",
+		"b.cue": "package a\n\nx: int @tag(env,ty\n",
+		"c.cue": "package a\n\ny: int @\n",
+	})
+
+	uri := func(name string) protocol.DocumentURI {
+		return cache.URIFromPath(filepath.Join(dir, name))
+	}
+
+	diagnostics := func() []string {
+		t.Helper()
+
+		diagnostics, err := s.GetDiagnostics(uri("a.cue"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ret []string
+
+		for _, d := range diagnostics.Diagnostics {
+			ret = append(ret, fmt.Sprintf("%v:%v-%v:%v %v %s", d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character, d.Severity, d.Message))
+		}
+
+		sort.Strings(ret)
+
+		return ret
+	}
+
+	expected := []string{
+		`2:21-2:31 Error invalid type "float", expected one of string, int, number, bool`,
+		`3:22-3:29 Warning unknown option "unknown" of @go attribute`,
+		`5:12-5:21 Error malformed @go attribute: invalid attribute: expected comma`,
+	}
+
+	if got := diagnostics(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected diagnostics %q, got %q", expected, got)
+	}
+
+	// Attributes declared in the settings are validated as well.
+	err := s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{"cue": map[string]interface{}{
+			"attributes": []interface{}{map[string]interface{}{
+				"key":  "team",
+				"doc":  "The team owning a field.",
+				"args": []interface{}{map[string]interface{}{"name": "name", "required": true}},
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := diagnostics(); len(got) != 4 || !strings.Contains(strings.Join(got, "\n"), "4:14-4:21 Error missing name of @team attribute") {
+		t.Errorf("expected a diagnostic for the custom attribute, got %q", got)
+	}
+
+	hover, err := s.Hover(context.Background(), &protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri("a.cue")},
+			Position:     protocol.Position{Line: 2, Character: 15},
+		},
+	})
+	if err != nil || hover == nil {
+		t.Fatalf("hover failed: %v", err)
+	}
+
+	if !strings.Contains(hover.Contents.Value, "@tag(<name>,[type=<type>],[short=<short>])") || !strings.Contains(hover.Contents.Value, "cmd/cue") {
+		t.Errorf("unexpected hover %q", hover.Contents.Value)
+	}
+
+	complete := func(name string, line, character float64) []string {
+		t.Helper()
+
+		completion, err := s.Completion(context.Background(), &protocol.CompletionParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri(name)},
+				Position:     protocol.Position{Line: line, Character: character},
+			},
+		})
+		if err != nil || completion == nil {
+			t.Fatalf("%s: completion failed: %v", name, err)
+		}
+
+		var ret []string
+		for _, item := range completion.Items {
+			ret = append(ret, item.Label)
+		}
+
+		sort.Strings(ret)
+
+		return ret
+	}
+
+	if got, expected := complete("b.cue", 2, 18), []string{"short=", "type="}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the options of @tag %q, got %q", expected, got)
+	}
+
+	if got, expected := complete("a.cue", 2, 26), []string{"bool", "int", "number", "string"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the values of the type option %q, got %q", expected, got)
+	}
+
+	if got, expected := complete("c.cue", 2, 8), []string{"@go", "@openapi", "@protobuf", "@tag", "@team"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the keys of the attributes %q, got %q", expected, got)
+	}
+}
+
+func TestToolFiles(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{
+		"a.cue": "package a\n\nname: \"world\"\n",
+		"a_tool.cue": `package a
+
+import (
+	"tool/exec"
+	"tool/cli"
+)
+
+command: hello: {
+	run: exec.Run & {
+		cmd: ["echo", name]
+		stdout: string
+		
+	}
+	print: cli.Print & {text: run.stdout}
+}
+
+command: loop: {
+	a: exec.Run & {cmd: "echo", stdin: b.stdout, stdout: string}
+	b: exec.Run & {cmd: "echo", stdin: a.stdout, stdout: string}
+}
+
+command: bad: x: {$id: "tool/exec.Start", cmd: "ls"}
+`,
+	})
+
+	uri := cache.URIFromPath(filepath.Join(dir, "a_tool.cue"))
+
+	diagnostics, err := s.GetDiagnostics(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+
+	for _, d := range diagnostics.Diagnostics {
+		got = append(got, fmt.Sprintf("%v:%v %s", d.Range.Start.Line, d.Range.Start.Character, d.Message))
+	}
+
+	sort.Strings(got)
+
+	expected := []string{
+		"17:4 cyclic dependency in tasks: command.loop.a -> command.loop.b -> command.loop.a",
+		"18:4 cyclic dependency in tasks: command.loop.a -> command.loop.b -> command.loop.a",
+		`21:23 runner of kind "tool/exec.Start" not found`,
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected diagnostics %q, got %q", expected, got)
+	}
+
+	completion, err := s.Completion(context.Background(), &protocol.CompletionParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     protocol.Position{Line: 11, Character: 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details := map[string]string{}
+	for _, item := range completion.Items {
+		details[item.Label] = item.Detail
+	}
+
+	// Fields the task already has are not offered again.
+	if details["stdin"] != "*null | string | bytes" || details["success"] != "bool" {
+		t.Errorf("expected the fields of exec.Run, got %q", details)
+	}
+
+	if _, ok := details["cmd"]; ok {
+		t.Errorf("expected cmd not to be offered, since it is set")
+	}
+}
+
+func TestDeprecations(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{
+		"a.cue": "package a\n\nX = 3\na: X\n",
+		"b.cue": "package a\n\n`b`: 4\n",
+	})
+
+	uri := cache.URIFromPath(filepath.Join(dir, "a.cue"))
+
+	diagnostics, err := s.GetDiagnostics(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deprecated []protocol.Diagnostic
+
+	for _, d := range diagnostics.Diagnostics {
+		if d.Severity == protocol.SeverityWarning && isDeprecation(d) {
+			deprecated = append(deprecated, d)
+		}
+	}
+
+	if len(deprecated) != 1 || deprecated[0].Range.Start.Line != 2 {
+		t.Fatalf("expected a deprecation warning for the alias, got %v", diagnostics.Diagnostics)
+	}
+
+	actions, err := s.CodeAction(context.Background(), &protocol.CodeActionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Context:      protocol.CodeActionContext{Diagnostics: deprecated},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	titles := map[string]protocol.CodeAction{}
+	for _, a := range actions {
+		titles[a.Title] = a
+	}
+
+	quickFix, ok := titles["Rewrite deprecated syntax"]
+	if !ok || quickFix.Kind != protocol.QuickFix {
+		t.Fatalf("expected a quick fix, got %v", actions)
+	}
+
+	var newText []string
+	for _, e := range quickFix.Edit.Changes[string(uri)] {
+		newText = append(newText, e.NewText)
+	}
+
+	if got := strings.Join(newText, ""); got != "let X = 3\n" {
+		t.Errorf("expected the alias to be rewritten, got %q", got)
+	}
+
+	if _, ok := titles["Fix deprecated syntax in file"]; !ok {
+		t.Errorf("expected an action fixing the file, got %v", actions)
+	}
+
+	pkg, ok := titles["Fix deprecated syntax in package"]
+	if !ok || len(pkg.Edit.Changes) != 2 {
+		t.Errorf("expected an action fixing both files, got %v", actions)
+	}
+}
+
+func TestLint(t *testing.T) {
+	s, dir := testWorkspace(t, map[string]string{
+		"a.cue": "package a\n\nimport \"strings\"\n\n_x: 1\n// cue-lint:ignore\n_y: 2\ntags: [\"a\", \"a\"]\n",
+	})
+
+	uri := cache.URIFromPath(filepath.Join(dir, "a.cue"))
+
+	diagnostics := func() []string {
+		t.Helper()
+
+		diagnostics, err := s.GetDiagnostics(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ret []string
+
+		for _, d := range diagnostics.Diagnostics {
+			if d.Source == lint.Source {
+				ret = append(ret, fmt.Sprintf("%v:%v %v %v %v", d.Range.Start.Line, d.Range.Start.Character, d.Severity, d.Code, d.Tags))
+			}
+		}
+
+		sort.Strings(ret)
+
+		return ret
+	}
+
+	expected := []string{
+		"2:7 Warning unused-import [Unnecessary]",
+		"4:0 Warning unused-hidden [Unnecessary]",
+		"7:12 Warning duplicate-element []",
+	}
+
+	if got := diagnostics(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected diagnostics %q, got %q", expected, got)
+	}
+
+	err := s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{"cue": map[string]interface{}{
+			"lint": map[string]interface{}{"unused-hidden": "error", "unused-import": "off"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []string{
+		"4:0 Error unused-hidden [Unnecessary]",
+		"7:12 Warning duplicate-element []",
+	}
+
+	if got := diagnostics(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected diagnostics %q, got %q", expected, got)
+	}
+}
+
+// syncWriter is a writer that can be written to concurrently.
+type syncWriter struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.b.Write(p)
+}
+
+func (w *syncWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.b.String()
+}
+
+func TestReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const content = "package a\n\n// x is documented.\nx: 1\ny: x\nz: missing\n"
+
+	dirs := make([]string, 2)
+
+	for i := range dirs {
+		dir, err := ioutil.TempDir("", "cue-lsp")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(dir)
+
+		if err := ioutil.WriteFile(filepath.Join(dir, "a.cue"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		dirs[i] = dir
+	}
+
+	// Record a session in the first folder.
+	trace := &syncWriter{}
+
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	s := serverFromStream(jSONLogStream(jsonrpc2.NewHeaderStream(serverEnd, serverEnd), trace), &Config{}, &workspace{})
+	s.lifetime, s.exit = context.WithCancel(ctx)
+
+	go s.Conn.Run(s.lifetime) // nolint: errcheck
+
+	client := &diagnosticsRecorder{}
+
+	conn := jsonrpc2.NewConn(jsonrpc2.NewHeaderStream(clientEnd, clientEnd))
+	conn.AddHandler(protocol.ClientHandler(client))
+
+	go conn.Run(ctx) // nolint: errcheck
+
+	srv := protocol.ServerDispatcher(conn)
+
+	if _, err := srv.Initialize(ctx, &protocol.ParamInitialize{
+		InitializeParams: protocol.InitializeParams{
+			InnerInitializeParams: protocol.InnerInitializeParams{RootURI: cache.URIFromPath(dirs[0])},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.Initialized(ctx, &protocol.InitializedParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	uri := cache.URIFromPath(filepath.Join(dirs[0], "a.cue"))
+
+	err := srv.DidOpen(ctx, &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: uri, LanguageID: "cue", Text: content},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := srv.Hover(ctx, &protocol.HoverParams{
+		TextDocumentPositionParams: testPosition(t, dirs[0], "a.cue", content, "y: |x"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for published := false; !published; {
+		select {
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		client.mu.Lock()
+		for _, p := range client.published {
+			published = published || (p.URI == uri && len(p.Diagnostics) > 0)
+		}
+		client.mu.Unlock()
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay it in the second folder.
+	var out strings.Builder
+
+	differences, err := Replay(ctx, &Config{}, strings.NewReader(trace.String()), dirs[1], &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if differences != 0 {
+		t.Errorf("expected the replay to match the recording, got\n%s", out.String())
+	}
+
+	// A recording that does not match is reported.
+	changed := strings.Replace(trace.String(), "unresolved reference missing", "unresolved reference other", 1)
+
+	out.Reset()
+
+	differences, err = Replay(ctx, &Config{}, strings.NewReader(changed), dirs[1], &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if differences != 1 || !strings.Contains(out.String(), "diagnostics of "+string(cache.URIFromPath(filepath.Join(dirs[1], "a.cue")))) ||
+		!strings.Contains(out.String(), `+    "message": "unresolved reference missing"`) {
+		t.Errorf("expected the diagnostics to differ, got %d differences\n%s", differences, out.String())
+	}
+}
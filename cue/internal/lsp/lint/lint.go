@@ -0,0 +1,281 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lint checks CUE packages for code that is valid, but likely a mistake, like unused imports.
+//
+// Every rule has an ID, which is used to change its severity in the configuration and to ignore its problems
+// with a comment like
+//
+//	// cue-lint:ignore unused-hidden
+//
+// A comment on a line of its own applies to the next line, a comment following code to its own line.
+// Without rule IDs, the problems of all rules are ignored.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/token"
+)
+
+// Source is the source of the diagnostics of lint problems.
+const Source = "cue-lint"
+
+// ignoreDirective starts the comments ignoring problems.
+const ignoreDirective = "cue-lint:ignore"
+
+// Severity is how the problems of a rule are reported.
+type Severity int
+
+// The severities, from the least to the most severe.
+const (
+	// Off disables a rule.
+	Off Severity = iota
+	Hint
+	Info
+	Warning
+	Error
+)
+
+var severityNames = [...]string{"off", "hint", "info", "warning", "error"}
+
+func (s Severity) String() string {
+	if s < Off || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+
+	return severityNames[s]
+}
+
+// ParseSeverity returns the severity with the given name, like warning.
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if n == name {
+			return Severity(i), nil
+		}
+	}
+
+	return Off, fmt.Errorf("invalid severity %q, expected one of %s", name, strings.Join(severityNames[:], ", "))
+}
+
+// Rule is a check of a package.
+type Rule struct {
+	// ID identifies the rule in the configuration and in cue-lint:ignore comments, like unused-import.
+	ID  string
+	Doc string
+	// Severity is the severity of the problems of the rule, unless the configuration changes it.
+	Severity Severity
+	// Unnecessary is set for rules reporting code that can be removed, which editors show faded.
+	Unnecessary bool
+	// NeedsInstance is set for rules that need the evaluated package. They are skipped if it is not available.
+	NeedsInstance bool
+	// Run reports the problems of the package of a pass.
+	Run func(p *Pass)
+}
+
+// Problem is an issue a rule found.
+type Problem struct {
+	Rule       *Rule
+	Start, End token.Pos
+	Message    string
+	Severity   Severity
+}
+
+// Pass is a run of a rule on a package.
+type Pass struct {
+	Package *asg.Package
+	// Instance is the evaluated package, if the rule needs it.
+	Instance *cue.Instance
+
+	rule     *Rule
+	problems []Problem
+}
+
+// Reportf reports a problem of the range from start to end.
+func (p *Pass) Reportf(start token.Pos, end token.Pos, format string, args ...interface{}) {
+	p.problems = append(p.problems, Problem{
+		Rule:    p.rule,
+		Start:   start,
+		End:     end,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+var rules = map[string]*Rule{}
+
+// Register adds a rule. It panics if there already is a rule with the same ID.
+func Register(r *Rule) {
+	if _, ok := rules[r.ID]; ok {
+		panic(fmt.Sprintf("lint rule %s registered twice", r.ID))
+	}
+
+	rules[r.ID] = r
+}
+
+// Rules returns all rules, sorted by their ID.
+func Rules() []*Rule {
+	ret := make([]*Rule, 0, len(rules))
+	for _, r := range rules {
+		ret = append(ret, r)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+
+	return ret
+}
+
+// Lookup returns the rule with the given ID, or nil.
+func Lookup(id string) *Rule {
+	return rules[id]
+}
+
+// Config changes the severity of rules, by their ID. Rules missing from it have their own severity.
+type Config map[string]Severity
+
+// Severity returns the severity of a rule.
+func (c Config) Severity(r *Rule) Severity {
+	if s, ok := c[r.ID]; ok {
+		return s
+	}
+
+	return r.Severity
+}
+
+// NeedsInstance reports whether an enabled rule needs the evaluated package.
+func (c Config) NeedsInstance() bool {
+	for _, r := range rules {
+		if r.NeedsInstance && c.Severity(r) != Off {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Run runs the enabled rules on a package and returns their problems that are not ignored,
+// sorted by their position.
+//
+// inst is the evaluated package. If it is nil, the rules that need it are skipped.
+func Run(pkg *asg.Package, inst *cue.Instance, config Config) []Problem {
+	var ret []Problem
+
+	ignored := ignores(pkg)
+
+	for _, r := range Rules() {
+		severity := config.Severity(r)
+		if severity == Off || (r.NeedsInstance && inst == nil) {
+			continue
+		}
+
+		p := &Pass{Package: pkg, Instance: inst, rule: r}
+		r.Run(p)
+
+		for _, problem := range p.problems {
+			if ignored.has(problem) {
+				continue
+			}
+
+			problem.Severity = severity
+			ret = append(ret, problem)
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		a, b := ret[i].Start, ret[j].Start
+		if a.Filename() != b.Filename() {
+			return a.Filename() < b.Filename()
+		}
+
+		return a.Offset() < b.Offset()
+	})
+
+	return ret
+}
+
+// ignoreKey is a line of a file.
+type ignoreKey struct {
+	filename string
+	line     int
+}
+
+// ignoreSet contains the rule IDs ignored on lines. An empty list ignores all rules.
+type ignoreSet map[ignoreKey][]string
+
+func (s ignoreSet) has(p Problem) bool {
+	ids, ok := s[ignoreKey{p.Start.Filename(), p.Start.Line()}]
+	if !ok {
+		return false
+	}
+
+	if len(ids) == 0 {
+		return true
+	}
+
+	for _, id := range ids {
+		if id == p.Rule.ID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ignores returns the lines the cue-lint:ignore comments of the files of a package apply to.
+func ignores(pkg *asg.Package) ignoreSet {
+	ret := ignoreSet{}
+
+	for _, f := range pkg.Files {
+		ast.Walk(f.File, func(n ast.Node) bool {
+			cg, ok := n.(*ast.CommentGroup)
+			if !ok {
+				return true
+			}
+
+			for _, c := range cg.List {
+				text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+				if !strings.HasPrefix(text, ignoreDirective) {
+					continue
+				}
+
+				ids := strings.FieldsFunc(strings.TrimPrefix(text, ignoreDirective), func(r rune) bool {
+					return r == ',' || r == ' ' || r == '\t'
+				})
+
+				line := c.Pos().Line()
+				if !cg.Line {
+					line = cg.End().Line() + 1
+				}
+
+				key := ignoreKey{c.Pos().Filename(), line}
+				if existing, ok := ret[key]; ok && (len(existing) == 0 || len(ids) == 0) {
+					ids = nil
+				} else {
+					ids = append(existing, ids...)
+				}
+
+				ret[key] = ids
+			}
+
+			return true
+		}, nil)
+	}
+
+	return ret
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/load"
+)
+
+const testSource = `package a
+
+import "list"
+
+_unused: 1
+_used:   2
+used:    _used
+
+#Unused: {}
+
+name: "x"
+a: {
+	name:     "y"
+	greeting: name
+}
+
+x: string
+y: x
+z: x | *"default"
+
+tags: ["a", "b", "a", 'a']
+sorted: list.Sum([3, 1])
+
+// cue-lint:ignore unused-hidden
+_ignored: 3
+_alsoIgnored: 4 // cue-lint:ignore shadow, unused-hidden
+_notIgnored: 5 // cue-lint:ignore shadow
+`
+
+// testUnusedImport is another file of the package, which the evaluator rejects.
+const testUnusedImport = `package a
+
+import "strings"
+`
+
+func runTest(t *testing.T, config Config) []string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "cue-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{"a.cue": testSource, "b.cue": testUnusedImport} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pkg, err := asg.NewCompiler(&load.Config{Dir: dir}).CompileFile(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst := cue.Build(load.Instances([]string{"a.cue"}, &load.Config{Dir: dir}))[0]
+	if inst.Err != nil {
+		t.Fatal(inst.Err)
+	}
+
+	var got []string
+
+	for _, p := range Run(pkg, inst, config) {
+		got = append(got, fmt.Sprintf("%d:%d %s %s: %s", p.Start.Line(), p.Start.Column(), p.Severity, p.Rule.ID, p.Message))
+	}
+
+	return got
+}
+
+func TestRun(t *testing.T) {
+	expected := []string{
+		"5:1 warning unused-hidden: hidden field _unused is not used",
+		"9:1 hint unused-definition: definition #Unused is not used in its package",
+		"13:2 warning shadow: name shadows the field at a.cue:11",
+		"18:4 warning non-concrete: x is not concrete, so y cannot be exported",
+		`21:18 warning duplicate-element: duplicate element "a", already at a.cue:21`,
+		"27:1 warning unused-hidden: hidden field _notIgnored is not used",
+		`3:8 warning unused-import: "strings" is imported and not used`,
+	}
+
+	if got := runTest(t, nil); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected problems\n%q\ngot\n%q", expected, got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	config := Config{
+		UnusedHidden:     Error,
+		UnusedDefinition: Off,
+		UnusedImport:     Off,
+		Shadow:           Off,
+		NonConcrete:      Off,
+		DuplicateElement: Off,
+	}
+
+	expected := []string{
+		"5:1 error unused-hidden: hidden field _unused is not used",
+		"27:1 error unused-hidden: hidden field _notIgnored is not used",
+	}
+
+	if got := runTest(t, config); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected problems\n%q\ngot\n%q", expected, got)
+	}
+
+	if config.NeedsInstance() {
+		t.Errorf("expected no rule to need the instance")
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for _, s := range []Severity{Off, Hint, Info, Warning, Error} {
+		parsed, err := ParseSeverity(s.String())
+		if err != nil || parsed != s {
+			t.Errorf("expected %s to parse, got %v, %v", s, parsed, err)
+		}
+	}
+
+	if _, err := ParseSeverity("fatal"); err == nil {
+		t.Errorf("expected an error for an unknown severity")
+	}
+}
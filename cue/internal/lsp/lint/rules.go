@@ -0,0 +1,461 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lint
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/literal"
+	"cuelang.org/go/cue/token"
+)
+
+// The IDs of the rules of this package.
+const (
+	UnusedHidden     = "unused-hidden"
+	UnusedDefinition = "unused-definition"
+	UnusedImport     = "unused-import"
+	Shadow           = "shadow"
+	NonConcrete      = "non-concrete"
+	DuplicateElement = "duplicate-element"
+)
+
+func init() {
+	Register(&Rule{
+		ID:          UnusedHidden,
+		Doc:         "Hidden fields and definitions, like _x, that are not referenced in their package.",
+		Severity:    Warning,
+		Unnecessary: true,
+		Run:         unusedHidden,
+	})
+	Register(&Rule{
+		ID: UnusedDefinition,
+		Doc: "Definitions, like #X, that are not referenced in their package. " +
+			"Definitions used by other packages are reported as well, which is why it is only a hint.",
+		Severity: Hint,
+		Run:      unusedDefinition,
+	})
+	Register(&Rule{
+		ID:          UnusedImport,
+		Doc:         "Imports that are not referenced in their file.",
+		Severity:    Warning,
+		Unnecessary: true,
+		Run:         unusedImport,
+	})
+	Register(&Rule{
+		ID:       Shadow,
+		Doc:      "Fields that hide a field with the same label in an outer struct from a reference.",
+		Severity: Warning,
+		Run:      shadow,
+	})
+	Register(&Rule{
+		ID:            NonConcrete,
+		Doc:           "References that make a regular field non-concrete, so cue export fails.",
+		Severity:      Warning,
+		NeedsInstance: true,
+		Run:           nonConcrete,
+	})
+	Register(&Rule{
+		ID:       DuplicateElement,
+		Doc:      "Lists of literals, which are used as sets, that contain an element twice.",
+		Severity: Warning,
+		Run:      duplicateElement,
+	})
+}
+
+// visitor walks the files of a package down, without following references or imports,
+// and calls the functions that are set.
+type visitor struct {
+	file  func(f *asg.File)
+	decl  func(d *asg.Decl)
+	ref   func(r *asg.Reference)
+	value func(v *asg.Value)
+}
+
+func (v *visitor) Direction() asg.VisitDirection {
+	return asg.DownDirection
+}
+
+func (v *visitor) Node(node asg.Node) (down bool, up bool) {
+	return true, false
+}
+
+func (v *visitor) File(f *asg.File) (decls bool, imports bool, up bool) {
+	if v.file != nil {
+		v.file(f)
+	}
+
+	return true, false, false
+}
+
+func (v *visitor) Decl(d *asg.Decl) (down bool, up bool) {
+	if v.decl != nil {
+		v.decl(d)
+	}
+
+	return true, false
+}
+
+func (v *visitor) Reference(r *asg.Reference) (down bool, up bool) {
+	if v.ref != nil {
+		v.ref(r)
+	}
+
+	return false, false
+}
+
+func (v *visitor) Value(val *asg.Value) (children bool, up bool) {
+	if v.value != nil {
+		v.value(val)
+	}
+
+	return true, false
+}
+
+// referencedNames returns the names of all identifiers of the files of a package that are not labels,
+// including the selected names of selectors like a._b.
+//
+// It is used instead of the references of the asg, which does not cover all expressions yet.
+func referencedNames(pkg *asg.Package) map[string]bool {
+	ret := map[string]bool{}
+
+	for _, f := range pkg.Files {
+		for _, ident := range identifiers(f.File) {
+			ret[ident.Name] = true
+		}
+	}
+
+	return ret
+}
+
+// identifiers returns the identifiers of a file that are not labels or otherwise declare a name.
+func identifiers(file *ast.File) []*ast.Ident {
+	var ret []*ast.Ident
+
+	declaring := map[*ast.Ident]bool{}
+
+	ast.Walk(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Field:
+			label := n.Label
+			if a, ok := label.(*ast.Alias); ok {
+				declaring[a.Ident] = true
+				label, _ = a.Expr.(ast.Label)
+			}
+
+			if ident, ok := label.(*ast.Ident); ok {
+				declaring[ident] = true
+			}
+		case *ast.Alias:
+			declaring[n.Ident] = true
+		case *ast.LetClause:
+			declaring[n.Ident] = true
+		case *ast.ForClause:
+			declaring[n.Key] = true
+			declaring[n.Value] = true
+		case *ast.ImportSpec:
+			return false
+		case *ast.Ident:
+			if !declaring[n] {
+				ret = append(ret, n)
+			}
+		}
+
+		return true
+	}, nil)
+
+	return ret
+}
+
+// isHidden reports whether a label is hidden, like _x or _#X.
+func isHidden(label string) bool {
+	return strings.HasPrefix(label, "_") && label != "_"
+}
+
+// reportLabels reports a problem at every label of a declaration.
+func reportLabels(p *Pass, d *asg.Decl, format string, args ...interface{}) {
+	for _, l := range d.Labels {
+		p.Reportf(l.Pos(), l.End(), format, args...)
+	}
+}
+
+func unusedHidden(p *Pass) {
+	used := referencedNames(p.Package)
+
+	asg.Walk(&visitor{decl: func(d *asg.Decl) {
+		if !isHidden(d.LabelName) || used[d.LabelName] {
+			return
+		}
+
+		if d.IsDefinition() {
+			reportLabels(p, d, "hidden definition %s is not used", d.LabelName)
+		} else {
+			reportLabels(p, d, "hidden field %s is not used", d.LabelName)
+		}
+	}}, p.Package)
+}
+
+func unusedDefinition(p *Pass) {
+	used := referencedNames(p.Package)
+
+	asg.Walk(&visitor{decl: func(d *asg.Decl) {
+		// Hidden definitions are reported as unused hidden fields.
+		if !d.IsDefinition() || isHidden(d.LabelName) || used[d.LabelName] {
+			return
+		}
+
+		reportLabels(p, d, "definition %s is not used in its package", d.LabelName)
+	}}, p.Package)
+}
+
+func unusedImport(p *Pass) {
+	asg.Walk(&visitor{file: func(f *asg.File) {
+		for _, spec := range f.File.Imports {
+			importPath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+
+			name := path.Base(importPath)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+
+			if name == "_" || importUsed(f.File, spec, name) {
+				continue
+			}
+
+			p.Reportf(spec.Pos(), spec.End(), "%s is imported and not used", spec.Path.Value)
+		}
+	}}, p.Package)
+}
+
+// importUsed reports whether an identifier of a file refers to an import.
+//
+// Identifiers referring to imports are either not resolved, or resolved to the import by the evaluator.
+func importUsed(file *ast.File, spec *ast.ImportSpec, name string) bool {
+	for _, ident := range identifiers(file) {
+		if ident.Name == name && (ident.Node == nil || ident.Node == spec) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func shadow(p *Pass) {
+	reported := map[*asg.Decl]bool{}
+
+	asg.Walk(&visitor{ref: func(r *asg.Reference) {
+		name := firstLabel(r.Orig)
+		if name == "" || r.Parent() == nil {
+			return
+		}
+
+		inner, ok := r.Parent().ResolveUp(name).(*asg.Decl)
+		if !ok || reported[inner] {
+			return
+		}
+
+		outer := outerDecl(inner, name)
+		if outer == nil || len(outer.Labels) == 0 {
+			return
+		}
+
+		reported[inner] = true
+		reportLabels(p, inner, "%s shadows the field at %s", name, shortPos(outer.Labels[0].Pos()))
+	}}, p.Package)
+}
+
+// firstLabel returns the name of the identifier a reference, like a.b.c, starts with.
+func firstLabel(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.SelectorExpr:
+			expr = e.X
+		case *ast.Ident:
+			name, _, err := ast.LabelName(e)
+			if err != nil {
+				return ""
+			}
+
+			return name
+		default:
+			return ""
+		}
+	}
+}
+
+// outerDecl returns the declaration with the given label in a scope around the struct declaring d, or nil.
+func outerDecl(d *asg.Decl, label string) *asg.Decl {
+	for n := d.Parent().Parent(); n != nil; n = n.Parent() {
+		if store, ok := n.(asg.DeclStore); ok {
+			outer, _ := store.ResolveUp(label).(*asg.Decl)
+			return outer
+		}
+	}
+
+	return nil
+}
+
+// shortPos formats a position as the base name of its file and its line.
+func shortPos(pos token.Pos) string {
+	return fmt.Sprintf("%s:%d", filepath.Base(pos.Filename()), pos.Line())
+}
+
+func nonConcrete(p *Pass) {
+	asg.Walk(&visitor{ref: func(r *asg.Reference) {
+		field := asg.ParentDecl(r)
+		if field == nil || !exported(field) {
+			return
+		}
+
+		target, ok := r.Referenced.(*asg.Decl)
+		if !ok || !inPackage(target, p.Package) {
+			return
+		}
+
+		fieldPath, targetPath := declPath(field), declPath(target)
+		if fieldPath == nil || targetPath == nil || concrete(p.Instance, fieldPath) || concrete(p.Instance, targetPath) {
+			return
+		}
+
+		p.Reportf(r.Pos(), r.End(), "%s is not concrete, so %s cannot be exported",
+			strings.Join(targetPath, "."), strings.Join(fieldPath, "."))
+	}}, p.Package)
+}
+
+// exported reports whether a declaration and the ones around it are regular fields, which cue export outputs.
+func exported(d *asg.Decl) bool {
+	for n := asg.Node(d); n != nil; n = n.Parent() {
+		d, ok := n.(*asg.Decl)
+		if !ok {
+			continue
+		}
+
+		if d.IsDefinition() || isHidden(d.LabelName) {
+			return false
+		}
+
+		if f, ok := d.Decl.(*ast.Field); ok && f.Optional.IsValid() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// inPackage reports whether a node belongs to a package.
+func inPackage(n asg.Node, pkg *asg.Package) bool {
+	for ; n != nil; n = n.Parent() {
+		if f, ok := n.(*asg.File); ok {
+			return f.Parent() == pkg
+		}
+	}
+
+	return false
+}
+
+// declPath returns the labels leading to a declaration, or nil if it is not a field of nested structs.
+func declPath(d *asg.Decl) []string {
+	var ret []string
+
+	for {
+		if d.LabelName != "" {
+			ret = append([]string{d.LabelName}, ret...)
+		}
+
+		switch p := d.Parent().(type) {
+		case *asg.File:
+			return ret
+		case *asg.Struct:
+			parent, ok := p.Parent().(*asg.Decl)
+			if !ok {
+				return nil
+			}
+
+			d = parent
+		default:
+			return nil
+		}
+	}
+}
+
+// concrete reports whether the value at a path of an instance is concrete, or does not exist.
+//
+// Structs and lists count as concrete, since their fields are checked on their own.
+func concrete(inst *cue.Instance, path []string) bool {
+	v := inst.Lookup(path...)
+	if !v.Exists() {
+		return true
+	}
+
+	if d, ok := v.Default(); ok {
+		v = d
+	}
+
+	return v.IsConcrete() || v.IncompleteKind()&(cue.StructKind|cue.ListKind) != 0
+}
+
+func duplicateElement(p *Pass) {
+	asg.Walk(&visitor{value: func(v *asg.Value) {
+		list, ok := v.Orig.(*ast.ListLit)
+		if !ok {
+			return
+		}
+
+		seen := map[string]*ast.BasicLit{}
+
+		var duplicates []*ast.BasicLit
+
+		for _, elt := range list.Elts {
+			switch elt := elt.(type) {
+			case *ast.Ellipsis:
+			case *ast.BasicLit:
+				key := literalKey(elt)
+				if first, ok := seen[key]; ok {
+					duplicates = append(duplicates, elt, first)
+				} else {
+					seen[key] = elt
+				}
+			default:
+				// Lists with other elements are data rather than sets.
+				return
+			}
+		}
+
+		for i := 0; i < len(duplicates); i += 2 {
+			elt, first := duplicates[i], duplicates[i+1]
+			p.Reportf(elt.Pos(), elt.End(), "duplicate element %s, already at %s", elt.Value, shortPos(first.Pos()))
+		}
+	}}, p.Package)
+}
+
+// literalKey returns a key that is the same for literals of the same value, like "a" and "\u0061".
+func literalKey(lit *ast.BasicLit) string {
+	if lit.Kind == token.STRING {
+		// Strings and bytes differ in their quotes.
+		if s, err := literal.Unquote(lit.Value); err == nil {
+			return lit.Value[:1] + s
+		}
+	}
+
+	return lit.Kind.String() + lit.Value
+}
@@ -0,0 +1,478 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The marker tests are an external test package, since internal/cuetest depends on the language server
+// through the cue command.
+package lsp_test
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"cuelang.org/go/cue/internal/lsp"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2/servertest"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/internal/cuetest"
+	"github.com/rogpeppe/testscript/txtar"
+)
+
+var update = flag.Bool("update", false, "update the golden output of the marker tests")
+
+// TestMarkers runs the txtar archives in testdata/markers against a headless server, which a client
+// talks to over a pipe.
+//
+// The CUE files of an archive contain markers in comments, like
+//
+//	a: b //@definition("b", target)
+//
+// Each marker names a text on its own line, which locates the marker. A "|" in the text moves the
+// location into the text, like in "a.|". The markers are
+//
+//	@mark(name, "text")                      names the location, for @definition
+//	@definition("text", name)                expects the definition at the location of a @mark
+//	@hover("text", "substring")              expects a hover containing the substring
+//	@complete("text", "label", ...)          expects completion items with the labels
+//	@diag("text", "severity", "regexp")      expects a diagnostic with the severity and a matching message
+//
+// All diagnostics of the files have to be expected by a @diag marker. The hovers are written to the
+// golden output of the archive, out/lsp, which -update rewrites.
+//
+// Settings are sent to the server before the files are opened, from a "#settings: {...}" line in the
+// comment of the archive, in JSON.
+func TestMarkers(t *testing.T) {
+	test := cuetest.TxTarTest{
+		Root:   "./testdata/markers",
+		Name:   "lsp",
+		Update: *update,
+	}
+
+	test.Run(t, runMarkers)
+}
+
+// marker is a marker in a comment of a CUE file.
+type marker struct {
+	name string
+	args []string
+	file string
+	// line is the 0 based line of the marker, with text its content in front of the comment.
+	line int
+	text string
+}
+
+func (m *marker) String() string {
+	return fmt.Sprintf("%s:%d: @%s", m.file, m.line+1, m.name)
+}
+
+// markerTest is the run of a txtar archive.
+type markerTest struct {
+	*cuetest.Test
+
+	ctx    context.Context
+	server protocol.Server
+	// headless is the server, as seen from the test. Only it returns the diagnostics of documents.
+	headless lsp.HeadlessServer
+	dir      string
+	marks    map[string]protocol.Location
+}
+
+func runMarkers(tc *cuetest.Test) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "cue-lsp")
+	if err != nil {
+		tc.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	hs, err := lsp.CreateHeadlessServer(ctx)
+	if err != nil {
+		tc.Fatal(err)
+	}
+
+	pipe := servertest.NewPipeServer(ctx, jsonrpc2.HandlerServer(protocol.ServerHandler(hs)))
+	defer pipe.Close()
+
+	mt := &markerTest{
+		Test:     tc,
+		ctx:      ctx,
+		server:   protocol.ServerDispatcher(pipe.Connect(ctx)),
+		headless: hs,
+		dir:      dir,
+		marks:    map[string]protocol.Location{},
+	}
+
+	err = mt.server.DidChangeWorkspaceFolders(ctx, &protocol.DidChangeWorkspaceFoldersParams{
+		Event: protocol.WorkspaceFoldersChangeEvent{
+			Added: []protocol.WorkspaceFolder{{URI: string(cache.URIFromPath(dir)), Name: "markers"}},
+		},
+	})
+	if err != nil {
+		tc.Fatal(err)
+	}
+
+	if settings, ok := tc.Value("settings"); ok {
+		var section interface{}
+		if err := json.Unmarshal([]byte(settings), &section); err != nil {
+			tc.Fatalf("invalid settings: %v", err)
+		}
+
+		err := mt.server.DidChangeConfiguration(ctx, &protocol.DidChangeConfigurationParams{
+			Settings: map[string]interface{}{"cue": section},
+		})
+		if err != nil {
+			tc.Fatal(err)
+		}
+	}
+
+	var (
+		markers []*marker
+		opened  []txtar.File
+	)
+
+	for _, f := range tc.Archive.Files {
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tc.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, f.Data, 0644); err != nil {
+			tc.Fatal(err)
+		}
+
+		if filepath.Ext(f.Name) != ".cue" || strings.HasPrefix(f.Name, "cue.mod/") {
+			continue
+		}
+
+		markers = append(markers, mt.parseMarkers(f.Name, string(f.Data))...)
+		opened = append(opened, f)
+	}
+
+	for _, f := range opened {
+		err := mt.server.DidOpen(ctx, &protocol.DidOpenTextDocumentParams{
+			TextDocument: protocol.TextDocumentItem{
+				URI:        mt.uri(f.Name),
+				LanguageID: "cue",
+				Text:       string(f.Data),
+			},
+		})
+		if err != nil {
+			tc.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+
+		mt.sync(f.Name)
+	}
+
+	for _, m := range markers {
+		if m.name == "mark" {
+			mt.mark(m)
+		}
+	}
+
+	diags := map[string][]*marker{}
+
+	for _, m := range markers {
+		switch m.name {
+		case "mark":
+		case "definition":
+			mt.definition(m)
+		case "hover":
+			mt.hover(m)
+		case "complete":
+			mt.complete(m)
+		case "diag":
+			diags[m.file] = append(diags[m.file], m)
+		default:
+			tc.Errorf("%v: unknown marker", m)
+		}
+	}
+
+	for _, f := range opened {
+		mt.diagnostics(f.Name, diags[f.Name])
+	}
+}
+
+// parseMarkers returns the markers in the comments of a file.
+func (mt *markerTest) parseMarkers(name string, content string) []*marker {
+	var ret []*marker
+
+	for i, line := range strings.Split(content, "\n") {
+		comment := strings.Index(line, "//@")
+		if comment < 0 {
+			continue
+		}
+
+		var s scanner.Scanner
+
+		s.Init(strings.NewReader(line[comment+len("//"):]))
+		s.Mode = scanner.ScanIdents | scanner.ScanStrings
+		s.Error = func(_ *scanner.Scanner, msg string) {
+			mt.Fatalf("%s:%d: invalid marker: %s", name, i+1, msg)
+		}
+
+		expect := func(tok rune) string {
+			if got := s.Scan(); got != tok {
+				mt.Fatalf("%s:%d: invalid marker: expected %s, got %s", name, i+1, scanner.TokenString(tok), s.TokenText())
+			}
+
+			return s.TokenText()
+		}
+
+		for s.Peek() != scanner.EOF {
+			expect('@')
+
+			m := &marker{name: expect(scanner.Ident), file: name, line: i, text: line[:comment]}
+
+			expect('(')
+
+			for tok := s.Scan(); tok != ')'; tok = s.Scan() {
+				switch tok {
+				case scanner.Ident:
+					m.args = append(m.args, s.TokenText())
+				case scanner.String:
+					arg, err := strconv.Unquote(s.TokenText())
+					if err != nil {
+						mt.Fatalf("%s:%d: invalid marker: %v", name, i+1, err)
+					}
+
+					m.args = append(m.args, arg)
+				default:
+					mt.Fatalf("%s:%d: invalid marker: unexpected %s", name, i+1, s.TokenText())
+				}
+
+				if s.Peek() == ',' {
+					s.Scan()
+				}
+			}
+
+			ret = append(ret, m)
+
+			for s.Peek() == ' ' || s.Peek() == '\t' {
+				s.Next()
+			}
+		}
+	}
+
+	return ret
+}
+
+// sync waits until the server handled the notifications sent before, which it handles in order with
+// requests, by sending a request for a document.
+func (mt *markerTest) sync(name string) {
+	_, err := mt.server.DocumentSymbol(mt.ctx, &protocol.DocumentSymbolParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: mt.uri(name)},
+	})
+	if err != nil {
+		mt.Fatalf("%s: %v", name, err)
+	}
+}
+
+func (mt *markerTest) uri(name string) protocol.DocumentURI {
+	return cache.URIFromPath(filepath.Join(mt.dir, filepath.FromSlash(name)))
+}
+
+// args returns the arguments of a marker, or fails the test if it does not have at least n of them.
+func (mt *markerTest) args(m *marker, n int) []string {
+	if len(m.args) < n {
+		mt.Fatalf("%v: expected at least %d arguments, got %q", m, n, m.args)
+	}
+
+	return m.args
+}
+
+// position returns the location of a text on the line of a marker. A "|" in the text moves the location
+// to its place in the text.
+func (mt *markerTest) position(m *marker, text string) protocol.TextDocumentPositionParams {
+	cursor := strings.Index(text, "|")
+	if cursor < 0 {
+		cursor = 0
+	}
+
+	char := strings.Index(m.text, strings.Replace(text, "|", "", 1))
+	if char < 0 {
+		mt.Fatalf("%v: %q not found on the line of the marker", m, text)
+	}
+
+	return protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: mt.uri(m.file)},
+		Position: protocol.Position{
+			Line:      float64(m.line),
+			Character: float64(char + cursor),
+		},
+	}
+}
+
+func (mt *markerTest) mark(m *marker) {
+	args := mt.args(m, 2)
+	pos := mt.position(m, args[1])
+
+	if _, ok := mt.marks[args[0]]; ok {
+		mt.Fatalf("%v: mark %s defined twice", m, args[0])
+	}
+
+	mt.marks[args[0]] = protocol.Location{
+		URI:   pos.TextDocument.URI,
+		Range: protocol.Range{Start: pos.Position, End: pos.Position},
+	}
+}
+
+func (mt *markerTest) definition(m *marker) {
+	args := mt.args(m, 2)
+
+	target, ok := mt.marks[args[1]]
+	if !ok {
+		mt.Fatalf("%v: unknown mark %s", m, args[1])
+	}
+
+	locations, err := mt.server.Definition(mt.ctx, &protocol.DefinitionParams{
+		TextDocumentPositionParams: mt.position(m, args[0]),
+	})
+	if err != nil {
+		mt.Errorf("%v: %v", m, err)
+		return
+	}
+
+	for _, l := range locations {
+		if l.URI == target.URI && l.Range.Start == target.Range.Start {
+			return
+		}
+	}
+
+	mt.Errorf("%v: expected the definition at %s, got %s", m, args[1], mt.locations(locations))
+}
+
+func (mt *markerTest) hover(m *marker) {
+	args := mt.args(m, 1)
+
+	hover, err := mt.server.Hover(mt.ctx, &protocol.HoverParams{
+		TextDocumentPositionParams: mt.position(m, args[0]),
+	})
+	if err != nil {
+		mt.Errorf("%v: %v", m, err)
+		return
+	}
+
+	var value string
+	if hover != nil {
+		value = strings.ReplaceAll(hover.Contents.Value, mt.dir, "$WORK")
+	}
+
+	fmt.Fprintf(mt, "%s:%d: @hover(%q)\n%s\n", m.file, m.line+1, args[0], strings.TrimRight(value, "\n"))
+
+	for _, want := range args[1:] {
+		if !strings.Contains(value, want) {
+			mt.Errorf("%v: expected the hover to contain %q, got %q", m, want, value)
+		}
+	}
+}
+
+func (mt *markerTest) complete(m *marker) {
+	args := mt.args(m, 2)
+
+	list, err := mt.server.Completion(mt.ctx, &protocol.CompletionParams{
+		TextDocumentPositionParams: mt.position(m, args[0]),
+	})
+	if err != nil {
+		mt.Errorf("%v: %v", m, err)
+		return
+	}
+
+	labels := map[string]bool{}
+
+	if list != nil {
+		for _, item := range list.Items {
+			labels[item.Label] = true
+		}
+	}
+
+	for _, want := range args[1:] {
+		if !labels[want] {
+			got := make([]string, 0, len(labels))
+			for l := range labels {
+				got = append(got, l)
+			}
+
+			sort.Strings(got)
+
+			mt.Errorf("%v: expected a completion %q, got %q", m, want, got)
+		}
+	}
+}
+
+// diagnostics compares the diagnostics of a file to the @diag markers in it.
+func (mt *markerTest) diagnostics(name string, markers []*marker) {
+	diagnostics, err := mt.headless.GetDiagnostics(mt.uri(name))
+	if err != nil {
+		mt.Fatal(err)
+	}
+
+	matched := make([]bool, len(markers))
+
+	for _, d := range diagnostics.Diagnostics {
+		found := false
+
+		for i, m := range markers {
+			if !matched[i] && mt.matchDiagnostic(m, d) {
+				matched[i], found = true, true
+				break
+			}
+		}
+
+		if !found {
+			mt.Errorf("%s:%d:%d: unexpected %v diagnostic %q", name, int(d.Range.Start.Line)+1, int(d.Range.Start.Character)+1, d.Severity, d.Message)
+		}
+	}
+
+	for i, m := range markers {
+		if !matched[i] {
+			mt.Errorf("%v: expected a diagnostic %q, got none", m, m.args)
+		}
+	}
+}
+
+func (mt *markerTest) matchDiagnostic(m *marker, d protocol.Diagnostic) bool {
+	args := mt.args(m, 3)
+
+	re, err := regexp.Compile(args[2])
+	if err != nil {
+		mt.Fatalf("%v: %v", m, err)
+	}
+
+	return d.Range.Start == mt.position(m, args[0]).Position &&
+		strings.EqualFold(fmt.Sprint(d.Severity), args[1]) &&
+		re.MatchString(d.Message)
+}
+
+func (mt *markerTest) locations(locations []protocol.Location) string {
+	ret := make([]string, 0, len(locations))
+	for _, l := range locations {
+		path := strings.TrimPrefix(cache.PathFromURI(l.URI), mt.dir+string(filepath.Separator))
+		ret = append(ret, fmt.Sprintf("%s:%v:%v", path, l.Range.Start.Line+1, l.Range.Start.Character+1))
+	}
+
+	return strings.Join(ret, ", ")
+}
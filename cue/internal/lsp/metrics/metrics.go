@@ -0,0 +1,324 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics defines the telemetry the language server records and exports it.
+//
+// Values are recorded as telemetry events with the keys of this package. Recording is cheap
+// as long as no exporter has been started with Start.
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/debug/tag"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/event"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/export"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/export/metric"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/export/ocagent"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/export/prometheus"
+)
+
+// The keys that describe the recorded values.
+var (
+	// Method is the LSP method of a request.
+	Method = tag.Method
+	// Code is the JSON RPC error code of a failed request.
+	Code = event.NewStringKey("code", "The error code of a failed request.")
+	// Package is the directory of a compiled package.
+	Package = tag.Package
+	// Result is either "hit" or "miss" for cache lookups.
+	Result = event.NewStringKey("result", "Whether a compile result was ready.")
+)
+
+// The recorded values.
+var (
+	// Latency is the time it took to answer a request, in milliseconds.
+	Latency = tag.Latency
+	// Errors is recorded for every request that was answered with an error.
+	Errors = event.NewInt64Key("errors", "Count of failed requests.")
+	// CompileTime is the time it took to load and check a package, in milliseconds.
+	CompileTime = event.NewFloat64Key("compile_ms", "Compile duration in milliseconds.")
+	// CacheLookups is recorded whenever a request needs the compile result of a document.
+	CacheLookups = event.NewInt64Key("cache_lookups", "Count of compile result lookups.")
+	// Documents is the number of open documents.
+	Documents = event.NewInt64Key("documents", "Count of open documents.")
+)
+
+// The results of cache lookups.
+const (
+	Hit  = "hit"
+	Miss = "miss"
+)
+
+// The buckets of the duration histograms, in milliseconds.
+var durationBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+func newMetrics() *metric.Config {
+	config := &metric.Config{}
+
+	metric.HistogramFloat64{
+		Name:        "cue_lsp_request_latency_ms",
+		Description: "Time it took to answer a request, in milliseconds.",
+		Keys:        []event.Key{Method},
+		Buckets:     durationBuckets,
+	}.Record(config, Latency)
+
+	metric.Scalar{
+		Name:        "cue_lsp_request_errors",
+		Description: "Number of requests answered with an error.",
+		Keys:        []event.Key{Method, Code},
+	}.Count(config, Errors)
+
+	metric.HistogramFloat64{
+		Name:        "cue_lsp_compile_duration_ms",
+		Description: "Time it took to load and check a package, in milliseconds.",
+		Keys:        []event.Key{Package},
+		Buckets:     durationBuckets,
+	}.Record(config, CompileTime)
+
+	metric.Scalar{
+		Name:        "cue_lsp_cache_lookups",
+		Description: "Number of compile result lookups, by whether the result was ready.",
+		Keys:        []event.Key{Result},
+	}.Count(config, CacheLookups)
+
+	metric.Scalar{
+		Name:        "cue_lsp_documents",
+		Description: "Number of open documents.",
+	}.LatestInt64(config, Documents)
+
+	return config
+}
+
+// Config selects where telemetry is exported to.
+type Config struct {
+	// MetricsAddress is the address to serve metrics on, at /metrics in the Prometheus text format.
+	MetricsAddress string
+	// OCAgentAddress is the URL of an OpenCensus agent that metrics and traces are sent to.
+	OCAgentAddress string
+}
+
+var (
+	mu sync.Mutex
+	// The exporters started by the last call of Start, nil if there was none.
+	current *exporters
+	// The installed Prometheus exporter, nil if no exporter is installed.
+	prom *prometheus.Exporter
+)
+
+// exporters are installed by Start until the context it was given is done.
+type exporters struct {
+	ctx context.Context
+	// The server of the metrics endpoint, if there is one.
+	srv *http.Server
+}
+
+// Enabled reports whether an exporter has been started.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return prom != nil
+}
+
+// Start installs the telemetry exporters and serves the metrics endpoint until the context is done.
+//
+// Exporters are global, so calls have no effect while the context of a previous call is not done.
+// Once it is done, the previous exporters are removed and the next call starts new ones.
+func Start(ctx context.Context, config Config) error {
+	if config.MetricsAddress == "" && config.OCAgentAddress == "" {
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current != nil {
+		if current.ctx.Err() == nil {
+			return nil
+		}
+
+		// The exporters may not have noticed yet that their context is done.
+		current.stop()
+	}
+
+	e := &exporters{ctx: ctx}
+
+	if config.MetricsAddress != "" {
+		l, err := net.Listen("tcp", config.MetricsAddress)
+		if err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", Handler())
+
+		e.srv = &http.Server{Handler: mux}
+
+		go e.srv.Serve(l) // nolint: errcheck
+	}
+
+	install(config.OCAgentAddress)
+
+	current = e
+
+	go func() {
+		<-ctx.Done()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if current == e {
+			e.stop()
+		}
+	}()
+
+	return nil
+}
+
+// stop closes the metrics endpoint and removes the global exporter.
+//
+// mu must be locked when calling this.
+func (e *exporters) stop() {
+	if e.srv != nil {
+		e.srv.Close()
+	}
+
+	event.SetExporter(nil)
+
+	prom = nil
+	current = nil
+}
+
+// install sets the global exporter, which collects metrics for Handler and sends them to the agent, if there is one.
+//
+// mu must be locked when calling this.
+func install(agent string) {
+	p := prometheus.New()
+	prom = p
+
+	var oc *ocagent.Exporter
+	if agent != "" {
+		oc = ocagent.Connect(&ocagent.Config{Address: agent, Service: "cue-lsp"})
+	}
+
+	output := func(ctx context.Context, ev event.Event, tags event.TagMap) context.Context {
+		ctx = p.ProcessEvent(ctx, ev, tags)
+
+		if oc != nil {
+			ctx = oc.ProcessEvent(ctx, ev, tags)
+		}
+
+		return ctx
+	}
+
+	event.SetExporter(export.Spans(export.Labels(newMetrics().Exporter(output))))
+}
+
+// Handler serves the collected metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		p := prom
+		mu.Unlock()
+
+		if p == nil {
+			http.Error(w, "metrics are not enabled", http.StatusNotFound)
+			return
+		}
+
+		rec := &bufferedResponse{header: w.Header()}
+		p.Serve(rec, r)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		scanner := bufio.NewScanner(&rec.body)
+		for scanner.Scan() {
+			w.Write([]byte(fixLabels(scanner.Text()) + "\n")) // nolint: errcheck
+		}
+	})
+}
+
+type bufferedResponse struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) Header() http.Header         { return r.header }
+func (r *bufferedResponse) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *bufferedResponse) WriteHeader(int)             {}
+
+// fixLabels rewrites the labels of a sample to the Prometheus syntax.
+//
+// The vendored exporter prints the label group as a Go slice, like name{[method="x" code="y"],le="1"},
+// and leaves missing labels as nil. This turns it into name{method="x",code="y",le="1"}.
+func fixLabels(line string) string {
+	start := strings.Index(line, "{[")
+	if start < 0 || strings.HasPrefix(line, "#") {
+		return line
+	}
+
+	var labels []string
+
+	inQuotes, escaped := false, false
+	label := strings.Builder{}
+
+	i := start + 2
+	for ; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && inQuotes:
+			escaped = true
+		case c == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && (c == ' ' || c == ']'):
+			if l := label.String(); l != "" && l != "nil" {
+				labels = append(labels, l)
+			}
+
+			label.Reset()
+
+			if c == ']' {
+				rest := line[i+1:]
+				if len(labels) == 0 {
+					// Drop the separator of the extra label, or the braces if there is none.
+					switch {
+					case strings.HasPrefix(rest, ","):
+						rest = "{" + rest[1:]
+					case strings.HasPrefix(rest, "}"):
+						return line[:start] + rest[1:]
+					}
+
+					return line[:start] + rest
+				}
+
+				return line[:start] + "{" + strings.Join(labels, ",") + rest
+			}
+
+			continue
+		}
+
+		label.WriteByte(c)
+	}
+
+	return line
+}
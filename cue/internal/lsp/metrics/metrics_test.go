@@ -0,0 +1,131 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/event"
+)
+
+func TestFixLabels(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{`# HELP cue_lsp_documents Number of open documents.`, `# HELP cue_lsp_documents Number of open documents.`},
+		{`cue_lsp_documents{[]} 3`, `cue_lsp_documents 3`},
+		{`cue_lsp_cache_lookups{[result="hit"]} 2`, `cue_lsp_cache_lookups{result="hit"} 2`},
+		{`cue_lsp_request_errors{[method="textDocument/hover" code="-32800"]} 1`,
+			`cue_lsp_request_errors{method="textDocument/hover",code="-32800"} 1`},
+		{`cue_lsp_request_errors{[method="initialize" nil]} 1`, `cue_lsp_request_errors{method="initialize"} 1`},
+		{`cue_lsp_compile_duration_ms_bucket{[package="/a b/\"c]\""],le="10"} 4`,
+			`cue_lsp_compile_duration_ms_bucket{package="/a b/\"c]\"",le="10"} 4`},
+		{`cue_lsp_compile_duration_ms_bucket{[],le="+Inf"} 4`, `cue_lsp_compile_duration_ms_bucket{le="+Inf"} 4`},
+	}
+
+	for _, test := range tests {
+		if got := fixLabels(test.line); got != test.expected {
+			t.Errorf("fixLabels(%s): expected %s, got %s", test.line, test.expected, got)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	mu.Lock()
+	install("")
+	mu.Unlock()
+
+	ctx := context.Background()
+
+	event.Record2(ctx, Method.Of("textDocument/hover"), Latency.Of(3))
+	event.Record2(ctx, Result.Of(Miss), CacheLookups.Of(1))
+	event.Record2(ctx, Result.Of(Hit), CacheLookups.Of(1))
+	event.Record2(ctx, Result.Of(Hit), CacheLookups.Of(1))
+	event.Record1(ctx, Documents.Of(2))
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	for _, expected := range []string{
+		`cue_lsp_request_latency_ms_bucket{method="textDocument/hover",le="5"} 1`,
+		`cue_lsp_request_latency_ms_count{method="textDocument/hover"} 1`,
+		`cue_lsp_cache_lookups{result="hit"} 2`,
+		`cue_lsp_cache_lookups{result="miss"} 1`,
+		"# TYPE cue_lsp_documents gauge\ncue_lsp_documents 2\n",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected the metrics to contain %s, got\n%s", expected, body)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	first, cancel := context.WithCancel(context.Background())
+
+	if err := Start(first, Config{MetricsAddress: "127.0.0.1:0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !Enabled() {
+		t.Fatalf("expected metrics to be enabled")
+	}
+
+	second, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+
+	if err := Start(second, Config{MetricsAddress: "127.0.0.1:0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	running := current.ctx
+	mu.Unlock()
+
+	if running != first {
+		t.Errorf("expected the first exporters to keep running while their context is not done")
+	}
+
+	cancel()
+
+	if err := Start(second, Config{MetricsAddress: "127.0.0.1:0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	running = current.ctx
+	mu.Unlock()
+
+	if running != second || !Enabled() {
+		t.Errorf("expected new exporters to be started once the context of the previous ones is done")
+	}
+
+	cancelSecond()
+
+	// The exporters are removed in the background once their context is done.
+	for i := 0; Enabled(); i++ {
+		if i == 100 {
+			t.Fatalf("expected metrics to be disabled once the context is done")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
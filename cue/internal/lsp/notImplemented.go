@@ -26,11 +26,6 @@ func notImplemented(method string) *jsonrpc2.Error {
 	return err
 }
 
-// DidChangeWorkspaceFolders is required by the protocol.Server interface
-func (s *server) DidChangeWorkspaceFolders(_ context.Context, _ *protocol.DidChangeWorkspaceFoldersParams) error {
-	return notImplemented("DidChangeWorkspaceFolders")
-}
-
 // DidSave is required by the protocol.Server interface
 func (s *server) DidSave(_ context.Context, _ *protocol.DidSaveTextDocumentParams) error {
 	return notImplemented("DidSave")
@@ -66,16 +61,6 @@ func (s *server) LogTraceNotification(_ context.Context, _ *protocol.LogTracePar
 	return notImplemented("LogTraceNotification")
 }
 
-// Implementation is required by the protocol.Server interface
-func (s *server) Implementation(_ context.Context, _ *protocol.ImplementationParams) ([]protocol.Location, error) {
-	return nil, notImplemented("Implementation")
-}
-
-// TypeDefinition is required by the protocol.Server interface
-func (s *server) TypeDefinition(_ context.Context, _ *protocol.TypeDefinitionParams) ([]protocol.Location, error) {
-	return nil, notImplemented("TypeDefinition")
-}
-
 // DocumentColor is required by the protocol.Server interface
 func (s *server) DocumentColor(_ context.Context, _ *protocol.DocumentColorParams) ([]protocol.ColorInformation, error) {
 	return nil, notImplemented("DocumentColor")
@@ -101,26 +86,11 @@ func (s *server) WillSaveWaitUntil(_ context.Context, _ *protocol.WillSaveTextDo
 	return nil, notImplemented("WillSaveWaitUntil")
 }
 
-// Resolve is required by the protocol.Server interface
-func (s *server) Resolve(_ context.Context, _ *protocol.CompletionItem) (*protocol.CompletionItem, error) {
-	return nil, notImplemented("Resolve")
-}
-
 // References is required by the protocol.Server interface
 func (s *server) References(_ context.Context, _ *protocol.ReferenceParams) ([]protocol.Location, error) {
 	return nil, notImplemented("References")
 }
 
-// DocumentHighlight is required by the protocol.Server interface
-func (s *server) DocumentHighlight(_ context.Context, _ *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
-	return nil, notImplemented("DocumentHighlight")
-}
-
-// CodeAction is required by the protocol.Server interface
-func (s *server) CodeAction(_ context.Context, _ *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
-	return nil, notImplemented("CodeAction")
-}
-
 // NonstandardRequest is required by the protocol.Server interface
 func (s *server) NonstandardRequest(_ context.Context, _ string, _ interface{}) (interface{}, error) {
 	return nil, notImplemented("NonstandardRequest")
@@ -141,11 +111,6 @@ func (s *server) ResolveCodeLens(_ context.Context, _ *protocol.CodeLens) (*prot
 	return nil, notImplemented("ResolveCodeLens")
 }
 
-// Formatting is required by the protocol.Server interface
-func (s *server) Formatting(_ context.Context, _ *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error) {
-	return nil, notImplemented("Formatting")
-}
-
 // RangeFormatting is required by the protocol.Server interface
 func (s *server) RangeFormatting(_ context.Context, _ *protocol.DocumentRangeFormattingParams) ([]protocol.TextEdit, error) {
 	return nil, notImplemented("RangeFormatting")
@@ -161,21 +126,6 @@ func (s *server) Rename(_ context.Context, _ *protocol.RenameParams) (*protocol.
 	return nil, notImplemented("Rename")
 }
 
-// DocumentLink is required by the protocol.Server interface
-func (s *server) DocumentLink(_ context.Context, _ *protocol.DocumentLinkParams) ([]protocol.DocumentLink, error) {
-	return nil, notImplemented("DocumentLink")
-}
-
-// ResolveDocumentLink is required by the protocol.Server interface
-func (s *server) ResolveDocumentLink(_ context.Context, _ *protocol.DocumentLink) (*protocol.DocumentLink, error) {
-	return nil, notImplemented("ResolveDocumentLink")
-}
-
-// ExecuteCommand is required by the protocol.Server interface
-func (s *server) ExecuteCommand(_ context.Context, _ *protocol.ExecuteCommandParams) (interface{}, error) {
-	return nil, notImplemented("ExecuteCommand")
-}
-
 // IncomingCalls is required by the protocol.Server interface
 func (s *server) IncomingCalls(_ context.Context, _ *protocol.CallHierarchyIncomingCallsParams) ([]protocol.CallHierarchyIncomingCall, error) {
 	return nil, notImplemented("IncomingCalls")
@@ -206,11 +156,6 @@ func (s *server) SemanticTokensRange(_ context.Context, _ *protocol.SemanticToke
 	return nil, notImplemented("SemanticTokensRange")
 }
 
-// WorkDoneProgressCancel is required by the protocol.Server interface
-func (s *server) WorkDoneProgressCancel(_ context.Context, _ *protocol.WorkDoneProgressCancelParams) error {
-	return notImplemented("WorkDoneProgressCancel")
-}
-
 // WorkDoneProgressCreate is required by the protocol.Server interface
 func (s *server) WorkDoneProgressCreate(_ context.Context, _ *protocol.WorkDoneProgressCreateParams) error {
 	return notImplemented("WorkDoneProgressCreate")
@@ -0,0 +1,237 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// progressDelay is how long an operation runs before its progress is shown, so quick operations don't flicker.
+var progressDelay = 500 * time.Millisecond
+
+// progressTokens is used to create unique progress tokens.
+var progressTokens int64
+
+// cancelled returns the error for requests the client cancelled, or nil if the request was not cancelled.
+func cancelled(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+
+	return jsonrpc2.NewErrorf(protocol.RequestCancelledError, "request cancelled")
+}
+
+// workDone reports the progress of an operation to the client with $/progress notifications.
+type workDone struct {
+	s           *server
+	token       string
+	title       string
+	cancellable bool
+	// cancel aborts the operation.
+	cancel context.CancelFunc
+
+	// mu is held while sending notifications, so they are sent in order.
+	mu      sync.Mutex
+	timer   *time.Timer
+	begun   bool
+	ended   bool
+	message string
+	percent float64
+}
+
+// startWorkDone starts reporting an operation, if the client supports it.
+//
+// The progress only becomes visible after progressDelay. If the user cancels it, cancel is called.
+func (s *server) startWorkDone(title string, cancellable bool, cancel context.CancelFunc) *workDone {
+	if s.headless || !s.progressSupported {
+		return nil
+	}
+
+	w := &workDone{
+		s:           s,
+		token:       fmt.Sprintf("cue-lsp-%d", atomic.AddInt64(&progressTokens, 1)),
+		title:       title,
+		cancellable: cancellable,
+		cancel:      cancel,
+	}
+
+	s.progressMu.Lock()
+	if s.progress == nil {
+		s.progress = make(map[string]*workDone)
+	}
+	s.progress[w.token] = w
+	s.progressMu.Unlock()
+
+	w.mu.Lock()
+	w.timer = time.AfterFunc(progressDelay, w.begin)
+	w.mu.Unlock()
+
+	return w
+}
+
+func (w *workDone) begin() {
+	w.mu.Lock()
+	ended := w.ended
+	w.mu.Unlock()
+
+	if ended {
+		return
+	}
+
+	// The operation goes on while the client creates the token.
+	err := w.s.client.WorkDoneProgressCreate(w.s.lifetime, &protocol.WorkDoneProgressCreateParams{Token: w.token})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// If the client did not accept the token, no progress is reported.
+	if err != nil || w.ended {
+		return
+	}
+
+	w.begun = true
+
+	// nolint: errcheck
+	w.s.client.Progress(w.s.lifetime, &protocol.ProgressParams{
+		Token: w.token,
+		Value: &protocol.WorkDoneProgressBegin{
+			Kind:        "begin",
+			Title:       w.title,
+			Cancellable: w.cancellable,
+			Message:     w.message,
+			Percentage:  w.percent,
+		},
+	})
+}
+
+// Report implements cache.Progress.
+func (w *workDone) Report(message string, percentage float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.message, w.percent = message, percentage
+
+	if !w.begun || w.ended {
+		return
+	}
+
+	// nolint: errcheck
+	w.s.client.Progress(w.s.lifetime, &protocol.ProgressParams{
+		Token: w.token,
+		Value: &protocol.WorkDoneProgressReport{
+			Kind:        "report",
+			Cancellable: w.cancellable,
+			Message:     message,
+			Percentage:  percentage,
+		},
+	})
+}
+
+// End implements cache.Progress.
+func (w *workDone) End(message string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.ended {
+		return
+	}
+
+	w.ended = true
+	w.timer.Stop()
+
+	w.s.progressMu.Lock()
+	delete(w.s.progress, w.token)
+	w.s.progressMu.Unlock()
+
+	if !w.begun {
+		return
+	}
+
+	// nolint: errcheck
+	w.s.client.Progress(w.s.lifetime, &protocol.ProgressParams{
+		Token: w.token,
+		Value: &protocol.WorkDoneProgressEnd{
+			Kind:    "end",
+			Message: message,
+		},
+	})
+}
+
+// WorkDoneProgressCancel is required by the protocol.Server interface
+//
+// Cancelling the progress of an operation aborts the operation.
+func (s *server) WorkDoneProgressCancel(ctx context.Context, params *protocol.WorkDoneProgressCancelParams) error {
+	token, ok := params.Token.(string)
+	if !ok {
+		return jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "unknown progress token %v", params.Token)
+	}
+
+	s.progressMu.Lock()
+	w := s.progress[token]
+	s.progressMu.Unlock()
+
+	// The operation might have finished in the meantime.
+	if w == nil || !w.cancellable {
+		return nil
+	}
+
+	w.cancel()
+
+	return nil
+}
+
+// multiProgress reports an operation to several clients.
+type multiProgress struct {
+	reporters []*workDone
+	cancel    context.CancelFunc
+}
+
+func (m *multiProgress) Report(message string, percentage float64) {
+	for _, w := range m.reporters {
+		w.Report(message, percentage)
+	}
+}
+
+func (m *multiProgress) End(message string) {
+	for _, w := range m.reporters {
+		w.End(message)
+	}
+
+	m.cancel()
+}
+
+// startProgress reports an operation to all servers using the workspace.
+//
+// It implements cache.ProgressFunc.
+func (w *workspace) startProgress(ctx context.Context, title string, cancellable bool) (context.Context, cache.Progress) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	m := &multiProgress{cancel: cancel}
+
+	for _, s := range w.getListeners() {
+		if r := s.startWorkDone(title, cancellable, cancel); r != nil {
+			m.reporters = append(m.reporters, r)
+		}
+	}
+
+	return ctx, m
+}
@@ -0,0 +1,467 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/diff"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/diff/myers"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/span"
+)
+
+// replayTimeout is how long a replayed request may take before it is reported as a difference.
+const replayTimeout = 30 * time.Second
+
+// replaySync is the method of the request that waits until the server handled the replayed notifications.
+// The server does not know it, but answers it after the messages sent before, like every request.
+const replaySync = "$/replay/sync"
+
+// traceMessage is a message of a trace written by jSONLogStream.
+//
+// Its type is one of send-request, send-notification and send-response for messages of the client,
+// and receive-request, receive-notification and receive-response for messages of the server.
+type traceMessage struct {
+	Type    string            `json:"type"`
+	Message protocol.Combined `json:"message"`
+}
+
+// readTrace reads the messages of a trace, skipping other lines, like log messages written to the same file.
+func readTrace(r io.Reader) ([]traceMessage, error) {
+	var ret []traceMessage
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<28)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if !strings.HasPrefix(text, "[LSP-") {
+			continue
+		}
+
+		i := strings.Index(text, "] ")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: invalid trace message", line)
+		}
+
+		var msg traceMessage
+		if err := json.Unmarshal([]byte(strings.TrimSpace(text[i+2:])), &msg); err != nil {
+			return nil, fmt.Errorf("line %d: invalid trace message: %v", line, err)
+		}
+
+		ret = append(ret, msg)
+	}
+
+	return ret, scanner.Err()
+}
+
+// replayClient answers the requests of the server with the responses recorded for requests with the
+// same method, in order, and ignores its notifications.
+type replayClient struct {
+	jsonrpc2.EmptyHandler
+
+	mu        sync.Mutex
+	responses map[string][]*protocol.Combined
+}
+
+func (c *replayClient) Deliver(ctx context.Context, r *jsonrpc2.Request, delivered bool) bool {
+	if delivered || r.IsNotify() {
+		return true
+	}
+
+	c.mu.Lock()
+	queue := c.responses[r.Method]
+
+	var recorded *protocol.Combined
+	if len(queue) > 0 {
+		recorded, c.responses[r.Method] = queue[0], queue[1:]
+	}
+	c.mu.Unlock()
+
+	switch {
+	case recorded == nil:
+		r.Reply(ctx, nil, nil) // nolint: errcheck
+	case recorded.Error != nil:
+		r.Reply(ctx, nil, recorded.Error) // nolint: errcheck
+	default:
+		r.Reply(ctx, recorded.Result, nil) // nolint: errcheck
+	}
+
+	return true
+}
+
+// replay is the replay of a trace.
+type replay struct {
+	ctx    context.Context
+	server *server
+	conn   *jsonrpc2.Conn
+	w      io.Writer
+
+	// rewrite moves the paths of the recording to the replayed workspace.
+	rewrite *strings.Replacer
+	// responses are the responses of the server in the recording, by request ID.
+	responses map[string]*protocol.Combined
+	// cancelled are the IDs of the requests the client cancelled in the recording.
+	cancelled map[string]bool
+	// diagnostics are the diagnostics the server published last in the recording, by document.
+	diagnostics map[protocol.DocumentURI]json.RawMessage
+	// opened are the documents open in the replayed session.
+	opened      map[protocol.DocumentURI]bool
+	differences int
+}
+
+// Replay feeds the messages of the client in a trace, as written with rpc_trace: json, to a new server,
+// and writes the differences between its responses and the recorded ones to w, as unified diffs.
+// The diagnostics the server published last for the documents open at the end are compared as well.
+//
+// If root is not empty, the workspace of the recording is moved to this directory.
+// Replay returns the number of differences.
+func Replay(ctx context.Context, config *Config, trace io.Reader, root string, w io.Writer) (int, error) {
+	messages, err := readTrace(trace)
+	if err != nil {
+		return 0, err
+	}
+
+	r := &replay{
+		ctx:         ctx,
+		w:           w,
+		rewrite:     strings.NewReplacer(),
+		responses:   map[string]*protocol.Combined{},
+		cancelled:   map[string]bool{},
+		diagnostics: map[protocol.DocumentURI]json.RawMessage{},
+		opened:      map[protocol.DocumentURI]bool{},
+	}
+
+	if root != "" {
+		r.rewrite = rootRewrite(messages, root)
+	}
+
+	client := &replayClient{responses: map[string][]*protocol.Combined{}}
+
+	// The requests of the server, by ID, to find the methods of the responses of the client.
+	serverRequests := map[string]string{}
+
+	for i := range messages {
+		msg := &messages[i]
+		r.rewriteMessage(msg)
+
+		switch msg.Type {
+		case "receive-response":
+			r.responses[msg.Message.ID.String()] = &msg.Message
+		case "receive-request":
+			serverRequests[msg.Message.ID.String()] = msg.Message.Method
+		case "send-response":
+			method := serverRequests[msg.Message.ID.String()]
+			client.responses[method] = append(client.responses[method], &msg.Message)
+		case "receive-notification":
+			if msg.Message.Method == "textDocument/publishDiagnostics" && msg.Message.Params != nil {
+				var params struct {
+					URI         protocol.DocumentURI `json:"uri"`
+					Diagnostics json.RawMessage      `json:"diagnostics"`
+				}
+
+				if err := json.Unmarshal(*msg.Message.Params, &params); err == nil {
+					r.diagnostics[params.URI] = params.Diagnostics
+				}
+			}
+		case "send-notification":
+			if msg.Message.Method == "$/cancelRequest" && msg.Message.Params != nil {
+				var params struct {
+					ID jsonrpc2.ID `json:"id"`
+				}
+
+				if err := json.Unmarshal(*msg.Message.Params, &params); err == nil {
+					r.cancelled[params.ID.String()] = true
+				}
+			}
+		}
+	}
+
+	// The replayed server must not trace the replay.
+	replayConfig := *config
+	replayConfig.RPCTrace = ""
+
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	r.server = serverFromStream(jsonrpc2.NewHeaderStream(serverEnd, serverEnd), &replayConfig, &workspace{})
+	r.server.lifetime, r.server.exit = context.WithCancel(ctx)
+
+	defer r.server.exit()
+
+	go r.server.Conn.Run(r.server.lifetime) // nolint: errcheck
+
+	r.conn = jsonrpc2.NewConn(jsonrpc2.NewHeaderStream(clientEnd, clientEnd))
+	r.conn.AddHandler(client)
+
+	go r.conn.Run(ctx) // nolint: errcheck
+
+	comparedDiagnostics := false
+
+	for _, msg := range messages {
+		if ctx.Err() != nil {
+			return r.differences, ctx.Err()
+		}
+
+		switch msg.Type {
+		case "send-request":
+			// The diagnostics are compared while the server still runs.
+			if msg.Message.Method == "shutdown" && !comparedDiagnostics {
+				if err := r.compareDiagnostics(); err != nil {
+					return r.differences, err
+				}
+
+				comparedDiagnostics = true
+			}
+
+			if err := r.request(&msg.Message); err != nil {
+				return r.differences, err
+			}
+		case "send-notification":
+			if msg.Message.Method == "$/cancelRequest" || msg.Message.Method == "exit" {
+				continue
+			}
+
+			r.track(&msg.Message)
+
+			if err := r.conn.Notify(ctx, msg.Message.Method, msg.Message.Params); err != nil {
+				return r.differences, err
+			}
+		}
+	}
+
+	if !comparedDiagnostics {
+		if err := r.compareDiagnostics(); err != nil {
+			return r.differences, err
+		}
+	}
+
+	return r.differences, nil
+}
+
+// rootRewrite returns a replacer moving the workspace of the initialize request of a trace to a directory.
+func rootRewrite(messages []traceMessage, root string) *strings.Replacer {
+	for _, msg := range messages {
+		if msg.Type != "send-request" || msg.Message.Method != "initialize" || msg.Message.Params == nil {
+			continue
+		}
+
+		var params protocol.ParamInitialize
+		if err := json.Unmarshal(*msg.Message.Params, &params); err != nil {
+			break
+		}
+
+		recorded := params.RootURI
+		if len(params.WorkspaceFolders) > 0 {
+			recorded = protocol.DocumentURI(params.WorkspaceFolders[0].URI)
+		}
+
+		if recorded == "" {
+			break
+		}
+
+		replayed := cache.URIFromPath(root)
+
+		return strings.NewReplacer(
+			string(recorded), string(replayed),
+			cache.PathFromURI(recorded), cache.PathFromURI(replayed),
+		)
+	}
+
+	return strings.NewReplacer()
+}
+
+// rewriteMessage moves the paths in the params and results of a message to the replayed workspace.
+func (r *replay) rewriteMessage(msg *traceMessage) {
+	for _, raw := range []*json.RawMessage{msg.Message.Params, msg.Message.Result} {
+		if raw != nil {
+			*raw = json.RawMessage(r.rewrite.Replace(string(*raw)))
+		}
+	}
+}
+
+// track keeps track of the documents the client opens and closes.
+func (r *replay) track(msg *protocol.Combined) {
+	if msg.Params == nil {
+		return
+	}
+
+	var params struct {
+		TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	}
+
+	if err := json.Unmarshal(*msg.Params, &params); err != nil {
+		return
+	}
+
+	switch msg.Method {
+	case "textDocument/didOpen":
+		r.opened[params.TextDocument.URI] = true
+	case "textDocument/didClose":
+		delete(r.opened, params.TextDocument.URI)
+	}
+}
+
+// request sends a request of the recording and compares the response to the recorded one.
+func (r *replay) request(msg *protocol.Combined) error {
+	id := msg.ID.String()
+
+	ctx, cancel := context.WithTimeout(r.ctx, replayTimeout)
+	defer cancel()
+
+	var result json.RawMessage
+
+	err := r.conn.Call(ctx, msg.Method, msg.Params, &result)
+
+	if r.ctx.Err() != nil {
+		return r.ctx.Err()
+	}
+
+	title := fmt.Sprintf("%s (request %s)", msg.Method, id)
+
+	if ctx.Err() != nil {
+		r.report(title, fmt.Sprintf("no response within %v\n", replayTimeout))
+		return nil
+	}
+
+	recorded, ok := r.responses[id]
+	if !ok || r.cancelled[id] {
+		return nil
+	}
+
+	replayed := &protocol.Combined{}
+
+	switch e := err.(type) {
+	case nil:
+		replayed.Result = &result
+	case *jsonrpc2.Error:
+		replayed.Error = e
+	default:
+		return err
+	}
+
+	r.compare(title, responseJSON(recorded), responseJSON(replayed))
+
+	return nil
+}
+
+// compareDiagnostics compares the diagnostics of the open documents to the ones the server published
+// last in the recording.
+func (r *replay) compareDiagnostics() error {
+	ctx, cancel := context.WithTimeout(r.ctx, replayTimeout)
+	defer cancel()
+
+	if err := r.conn.Call(ctx, replaySync, struct{}{}, nil); r.ctx.Err() != nil {
+		return r.ctx.Err()
+	} else if _, ok := err.(*jsonrpc2.Error); err != nil && !ok {
+		return err
+	}
+
+	uris := make([]string, 0, len(r.opened))
+	for uri := range r.opened {
+		uris = append(uris, string(uri))
+	}
+
+	sort.Strings(uris)
+
+	for _, uri := range uris {
+		recorded := r.diagnostics[protocol.DocumentURI(uri)]
+		if recorded == nil {
+			recorded = json.RawMessage("[]")
+		}
+
+		var replayed []protocol.Diagnostic
+
+		if params, err := r.server.GetDiagnostics(protocol.DocumentURI(uri)); err == nil && params != nil {
+			replayed = params.Diagnostics
+		}
+
+		if replayed == nil {
+			replayed = []protocol.Diagnostic{}
+		}
+
+		b, err := json.Marshal(replayed)
+		if err != nil {
+			return err
+		}
+
+		r.compare(fmt.Sprintf("diagnostics of %s", uri), indentJSON(recorded), indentJSON(b))
+	}
+
+	return nil
+}
+
+// responseJSON returns the result or error of a response as indented JSON.
+func responseJSON(msg *protocol.Combined) string {
+	response := map[string]interface{}{}
+
+	if msg.Error != nil {
+		response["error"] = msg.Error
+	} else if msg.Result != nil {
+		response["result"] = msg.Result
+	} else {
+		response["result"] = nil
+	}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		return err.Error()
+	}
+
+	return indentJSON(b)
+}
+
+// indentJSON formats JSON with sorted object keys, one value per line, so it can be compared by lines.
+func indentJSON(raw []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw) + "\n"
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return string(raw) + "\n"
+	}
+
+	return string(b) + "\n"
+}
+
+// compare reports a difference if the recorded and replayed texts differ.
+func (r *replay) compare(title string, recorded string, replayed string) {
+	if recorded == replayed {
+		return
+	}
+
+	edits := myers.ComputeEdits(span.URIFromPath("recorded"), recorded, replayed)
+	r.report(title, fmt.Sprint(diff.ToUnified("recorded", "replayed", recorded, edits)))
+}
+
+// report writes a difference.
+func (r *replay) report(title string, text string) {
+	r.differences++
+
+	fmt.Fprintf(r.w, "%s differs:\n%s", title, text)
+}
@@ -0,0 +1,302 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rest provides a REST API for the language server, for clients that cannot speak LSP.
+//
+// Every endpoint accepts a POST request with a JSON encoded Request and answers with JSON:
+//
+//	/diagnostics  the diagnostics of the document, as a list of LSP diagnostics
+//	/completion   the completion items at Request.Position, as a list of LSP completion items
+//	/hover        the LSP hover at Request.Position, or null
+//	/format       the formatted document, as a FormatResponse
+//
+// Errors are reported as an ErrorResponse.
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cuelang.org/go/cue/internal/lsp"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// maxRequestSize limits the size of request bodies, including all overlay files.
+const maxRequestSize = 16 << 20
+
+// defaultFilename is used if a request does not name its document.
+const defaultFilename = "input.cue"
+
+// Request is the body of all requests.
+type Request struct {
+	// Filename is the path of the document, relative to the directory of the overlay files.
+	// It defaults to input.cue.
+	Filename string `json:"filename"`
+	// Content is the content of the document.
+	Content string `json:"content"`
+	// Overlay contains further files by their relative path, like other files of the package or cue.mod/module.cue.
+	Overlay map[string]string `json:"overlay"`
+	// Position is the position in the document that completion and hover requests refer to.
+	Position *protocol.Position `json:"position"`
+}
+
+// FormatResponse is the response of the /format endpoint.
+type FormatResponse struct {
+	Content string `json:"content"`
+}
+
+// ErrorResponse is returned if a request fails.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// requestError is an error caused by the client, which is reported with the given status.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// endpoint answers a request for the document with the given URI.
+type endpoint func(ctx context.Context, uri protocol.DocumentURI, req *Request) (interface{}, error)
+
+type handler struct {
+	server lsp.HeadlessServer
+}
+
+// CreateHandler creates an http.Handler serving the REST API.
+//
+// All requests are answered by the same headless language server, which lives as long as the context
+// and uses the cue settings of the configuration.
+func CreateHandler(ctx context.Context, config *lsp.Config) (http.Handler, error) {
+	s, err := lsp.CreateHeadlessServerWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &handler{server: s}
+
+	mux := http.NewServeMux()
+	mux.Handle("/diagnostics", h.serve(h.diagnostics))
+	mux.Handle("/completion", h.serve(h.completion))
+	mux.Handle("/hover", h.serve(h.hover))
+	mux.Handle("/format", h.serve(h.format))
+
+	return mux, nil
+}
+
+// serve decodes a request, opens its document in the language server and writes the response of the endpoint.
+func (h *handler) serve(e endpoint) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, &requestError{http.StatusMethodNotAllowed, "only POST requests are supported"})
+
+			return
+		}
+
+		var req Request
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+			writeError(w, badRequest("invalid request: %v", err))
+			return
+		}
+
+		resp, err := h.handle(r.Context(), &req, e)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// handle writes the files of a request to a temporary directory, so packages and modules are loaded as usual,
+// and calls the endpoint while the document is open.
+func (h *handler) handle(ctx context.Context, req *Request, e endpoint) (interface{}, error) {
+	if req.Filename == "" {
+		req.Filename = defaultFilename
+	}
+
+	files := map[string]string{}
+	for name, content := range req.Overlay {
+		files[name] = content
+	}
+
+	files[req.Filename] = req.Content
+
+	dir, err := ioutil.TempDir("", "cue-rest")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	for name, content := range files {
+		if !isLocalPath(name) {
+			return nil, badRequest("invalid path %q, must be relative and inside the overlay", name)
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil { // nolint: gosec
+			return nil, err
+		}
+	}
+
+	uri := cache.URIFromPath(filepath.Join(dir, filepath.FromSlash(req.Filename)))
+
+	err = h.server.DidOpen(ctx, &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{
+			URI:        uri,
+			LanguageID: "cue",
+			Text:       req.Content,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer h.server.DidClose(ctx, &protocol.DidCloseTextDocumentParams{ // nolint: errcheck
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+
+	return e(ctx, uri, req)
+}
+
+// isLocalPath reports whether a slash separated path stays inside the directory it is relative to.
+func isLocalPath(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || filepath.IsAbs(name) {
+		return false
+	}
+
+	clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(name)))
+
+	return clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+func (h *handler) diagnostics(_ context.Context, uri protocol.DocumentURI, _ *Request) (interface{}, error) {
+	diagnostics, err := h.server.GetDiagnostics(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if diagnostics.Diagnostics == nil {
+		return []protocol.Diagnostic{}, nil
+	}
+
+	return diagnostics.Diagnostics, nil
+}
+
+func (h *handler) completion(ctx context.Context, uri protocol.DocumentURI, req *Request) (interface{}, error) {
+	where, err := positionParams(uri, req)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := h.server.Completion(ctx, &protocol.CompletionParams{
+		TextDocumentPositionParams: where,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if list == nil || list.Items == nil {
+		return []protocol.CompletionItem{}, nil
+	}
+
+	// Unlike editors, API clients get complete items right away.
+	for i := range list.Items {
+		item, err := h.server.Resolve(ctx, &list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+
+		list.Items[i] = *item
+	}
+
+	return list.Items, nil
+}
+
+func (h *handler) hover(ctx context.Context, uri protocol.DocumentURI, req *Request) (interface{}, error) {
+	where, err := positionParams(uri, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.server.Hover(ctx, &protocol.HoverParams{
+		TextDocumentPositionParams: where,
+	})
+}
+
+func (h *handler) format(ctx context.Context, uri protocol.DocumentURI, req *Request) (interface{}, error) {
+	edits, err := h.server.Formatting(ctx, &protocol.DocumentFormattingParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+
+	// The formatter replaces the whole document, if anything changed.
+	content := req.Content
+	if len(edits) > 0 {
+		content = edits[0].NewText
+	}
+
+	return FormatResponse{Content: content}, nil
+}
+
+func positionParams(uri protocol.DocumentURI, req *Request) (protocol.TextDocumentPositionParams, error) {
+	if req.Position == nil {
+		return protocol.TextDocumentPositionParams{}, badRequest("missing position")
+	}
+
+	return protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Position:     *req.Position,
+	}, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if reqErr, ok := err.(*requestError); ok {
+		status = reqErr.status
+	}
+
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v) // nolint: errcheck
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/internal/lsp"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+func post(t *testing.T, h http.Handler, path string, req interface{}, resp interface{}) int {
+	t.Helper()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("%s: invalid response %q: %v", path, rec.Body.String(), err)
+	}
+
+	return rec.Code
+}
+
+func TestREST(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := CreateHandler(ctx, &lsp.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var diagnostics []protocol.Diagnostic
+	if code := post(t, h, "/diagnostics", Request{Content: "package a\n\na: {\n"}, &diagnostics); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+
+	if len(diagnostics) == 0 {
+		t.Error("expected diagnostics for a syntax error")
+	}
+
+	// The overlay provides the module, so the import resolves.
+	withImport := Request{
+		Filename: "a/a.cue",
+		Content:  "package a\n\nimport \"example.com/b\"\n\na: b.b\n",
+		Overlay: map[string]string{
+			"cue.mod/module.cue": `module: "example.com"`,
+			"b/b.cue":            "package b\n\nb: 1\n",
+		},
+	}
+
+	diagnostics = nil
+	if code := post(t, h, "/diagnostics", withImport, &diagnostics); code != http.StatusOK || len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics with the overlay, got %d %v", code, diagnostics)
+	}
+
+	var items []protocol.CompletionItem
+	if code := post(t, h, "/completion", Request{
+		Content:  "package a\n\nfoo: 1\nbar: f\n",
+		Position: &protocol.Position{Line: 3, Character: 6},
+	}, &items); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+
+	found := false
+	for _, item := range items {
+		found = found || item.Label == "foo"
+	}
+
+	if !found {
+		t.Errorf("expected foo in the completion items, got %v", items)
+	}
+
+	var hover protocol.Hover
+	if code := post(t, h, "/hover", Request{
+		Content:  "package a\n\nfoo: int\nbar: foo\n",
+		Position: &protocol.Position{Line: 3, Character: 6},
+	}, &hover); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+
+	if !strings.Contains(hover.Contents.Value, "foo") {
+		t.Errorf("expected foo in the hover, got %q", hover.Contents.Value)
+	}
+
+	var formatted FormatResponse
+	if code := post(t, h, "/format", Request{Content: "a:    1\n"}, &formatted); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+
+	if formatted.Content != "a: 1\n" {
+		t.Errorf("unexpected formatted content %q", formatted.Content)
+	}
+}
+
+func TestRESTErrors(t *testing.T) {
+	h, err := CreateHandler(context.Background(), &lsp.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		req    Request
+		status int
+		msg    string
+	}{
+		{path: "/hover", req: Request{Content: "a: 1\n"}, status: http.StatusBadRequest, msg: "missing position"},
+		{path: "/diagnostics", req: Request{Filename: "../a.cue"}, status: http.StatusBadRequest, msg: "invalid path"},
+		{path: "/diagnostics", req: Request{Overlay: map[string]string{"/etc/a.cue": ""}}, status: http.StatusBadRequest, msg: "invalid path"},
+		{path: "/format", req: Request{Content: "a: {\n"}, status: http.StatusBadRequest, msg: "cannot format"},
+	}
+
+	for _, tc := range tests {
+		var resp ErrorResponse
+		if code := post(t, h, tc.path, tc.req, &resp); code != tc.status || !strings.Contains(resp.Error, tc.msg) {
+			t.Errorf("%s %v: got %d %q, want %d %q", tc.path, tc.req, code, resp.Error, tc.status, tc.msg)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/diagnostics", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestRESTConfig(t *testing.T) {
+	config := &lsp.Config{CUE: lsp.Settings{Lint: map[string]string{"unused-hidden": "error"}}}
+
+	h, err := CreateHandler(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var diagnostics []protocol.Diagnostic
+	if code := post(t, h, "/diagnostics", Request{Content: "package a\n\n_unused: 1\n"}, &diagnostics); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+
+	if len(diagnostics) != 1 || diagnostics[0].Severity != protocol.SeverityError {
+		t.Errorf("expected the configured severity of unused-hidden, got %v", diagnostics)
+	}
+}
@@ -21,13 +21,14 @@ package lsp
 import (
 	"context"
 	"fmt"
-	"net"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
-	"cuelang.org/go/cue/internal/lsp/cache"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
 	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/metrics"
 )
 
 // Server wraps language server instance that can connect to exactly one client.
@@ -39,6 +40,7 @@ type Server struct {
 type HeadlessServer interface {
 	protocol.Server
 	GetDiagnostics(uri protocol.DocumentURI) (*protocol.PublishDiagnosticsParams, error)
+	GetAllDiagnostics(uri protocol.DocumentURI) ([]*protocol.PublishDiagnosticsParams, error)
 }
 
 // server is a language server instance that can connect to exactly one client
@@ -49,9 +51,27 @@ type server struct {
 	state   serverState
 	stateMu sync.Mutex
 
-	cache cache.DocumentCache
+	workspace *workspace
+	// The documents this connection opened and how often, so they can be closed when the connection ends.
+	opened   map[protocol.DocumentURI]int
+	openedMu sync.Mutex
 
 	config *Config
+	// Whether the client supports workspace/configuration.
+	configurationSupported bool
+	// Whether the client supports work done progress created by the server.
+	progressSupported bool
+	// Whether the client can create files with workspace edits.
+	createFilesSupported bool
+	// Whether the client resolves the edits of completion items, and not only their documentation and detail.
+	editResolveSupported bool
+	// The reported operations that have not ended yet, by their progress token.
+	progress   map[string]*workDone
+	progressMu sync.Mutex
+	// The resolvers of the items of recent completion lists, by the number of the list.
+	completionLists map[uint64]*completionList
+	completionSeq   uint64
+	completionMu    sync.Mutex
 
 	lifetime context.Context
 	exit     func()
@@ -90,6 +110,13 @@ func (s Server) Run() error {
 	return s.server.Conn.Run(s.server.lifetime)
 }
 
+// ServeDebug serves pages showing the state of the server on a TCP address, until the server exits.
+//
+// RPC messages are only collected if the configuration of the server sets DebugAddress.
+func (s Server) ServeDebug(addr string) error {
+	return startDebugServer(s.server.lifetime, addr, s.server.workspace)
+}
+
 func (s Server) Log(level protocol.MessageType, msg string, args ...interface{}) {
 	s.server.client.LogMessage(s.server.lifetime, &protocol.LogMessageParams{
 		Message: fmt.Sprintf(msg, args...),
@@ -101,10 +128,16 @@ func (s Server) Log(level protocol.MessageType, msg string, args ...interface{})
 //
 // "locked down" in this case means, that the instance cannot send or receive any JSONRPC communication. Logging messages that the instance tries to send over JSONRPC are redirected to stderr.
 func CreateHeadlessServer(ctx context.Context) (HeadlessServer, error) {
+	return CreateHeadlessServerWithConfig(ctx, &Config{})
+}
+
+// CreateHeadlessServerWithConfig creates a locked down server instance that uses the settings of a configuration.
+func CreateHeadlessServerWithConfig(ctx context.Context, config *Config) (HeadlessServer, error) {
 	s := &server{
-		client:   headlessClient{},
-		headless: true,
-		config:   &Config{},
+		client:    headlessClient{},
+		headless:  true,
+		config:    config,
+		workspace: &workspace{},
 	}
 
 	s.lifetime, s.exit = context.WithCancel(ctx)
@@ -122,7 +155,22 @@ func CreateHeadlessServer(ctx context.Context) (HeadlessServer, error) {
 
 // ServerFromStream generates a Server from a jsonrpc2.Stream.
 func ServerFromStream(ctx context.Context, stream jsonrpc2.Stream, config *Config) (context.Context, Server) {
-	s := &server{}
+	s := serverFromStream(stream, config, &workspace{})
+
+	s.lifetime, s.exit = context.WithCancel(ctx)
+
+	return ctx, Server{s}
+}
+
+func serverFromStream(stream jsonrpc2.Stream, config *Config, w *workspace) *server {
+	s := &server{
+		workspace: w,
+		config:    config,
+	}
+
+	if metrics.Enabled() {
+		stream = newMetricsStream(stream)
+	}
 
 	switch config.RPCTrace {
 	case "text":
@@ -131,36 +179,109 @@ func ServerFromStream(ctx context.Context, stream jsonrpc2.Stream, config *Confi
 		stream = jSONLogStream(stream, os.Stderr)
 	}
 
+	if config.DebugAddress != "" {
+		stream = jSONLogStream(stream, w.rpcTraces())
+	}
+
 	s.Conn = jsonrpc2.NewConn(stream)
 	s.client = protocol.ClientDispatcher(s.Conn)
 
 	s.Conn.AddHandler(protocol.ServerHandler(s))
+	s.Conn.AddHandler(protocol.Canceller{})
 
-	s.config = config
+	return s
+}
 
-	s.lifetime, s.exit = context.WithCancel(ctx)
+// sharedServer serves every connection with its own language server instance.
+//
+// All instances share one workspace, so documents and packages are only loaded once.
+type sharedServer struct {
+	lifetime  context.Context
+	config    *Config
+	workspace *workspace
+}
 
-	srv := Server{s}
+// NewSharedServer creates a jsonrpc2.StreamServer that lets several clients attach to the same cache.
+//
+// Documents are compiled for the lifetime of the context, independent of the connection that opened them.
+func NewSharedServer(ctx context.Context, config *Config) jsonrpc2.StreamServer {
+	return newSharedServer(ctx, config)
+}
 
-	return ctx, srv
+func newSharedServer(ctx context.Context, config *Config) *sharedServer {
+	return &sharedServer{
+		lifetime:  ctx,
+		config:    config,
+		workspace: &workspace{},
+	}
+}
+
+// ServeStream serves a single connection until it is closed or the client exits.
+func (ss *sharedServer) ServeStream(ctx context.Context, stream jsonrpc2.Stream) error {
+	s := serverFromStream(stream, ss.config, ss.workspace)
+
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.lifetime, s.exit = ss.lifetime, cancel
+
+	defer s.disconnect()
+
+	switch err := s.Conn.Run(connCtx); err {
+	case jsonrpc2.ErrDisconnected, context.Canceled:
+		// The client disconnected or exited.
+		return nil
+	default:
+		return err
+	}
 }
 
 // RunTCPServer generates a server listening on the provided TCP Address, creating a new language Server
 // instance using plain HTTP for every connection.
 func RunTCPServer(ctx context.Context, addr string, config *Config) error {
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
-	}
+	return ListenAndServe(ctx, "tcp", addr, config, 0)
+}
 
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
+// ListenAndServe listens on a TCP address or Unix socket and serves every connection with a language server instance.
+//
+// All instances share one cache. If idleTimeout is non-zero, ListenAndServe returns nil after there were no connections for this duration.
+func ListenAndServe(ctx context.Context, network string, addr string, config *Config, idleTimeout time.Duration) error {
+	ss := newSharedServer(ctx, config)
+
+	if config.DebugAddress != "" {
+		if err := startDebugServer(ctx, config.DebugAddress, ss.workspace); err != nil {
 			return err
 		}
+	}
+
+	err := jsonrpc2.ListenAndServe(ctx, network, addr, ss, idleTimeout)
+	if err == jsonrpc2.ErrIdleTimeout {
+		return nil
+	}
+
+	return err
+}
+
+// ParseListenAddress splits an address of the form tcp:ADDR or unix:PATH into network and address.
+func ParseListenAddress(listen string) (network string, addr string, err error) {
+	i := strings.Index(listen, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid listen address %q, expected tcp:ADDR or unix:PATH", listen)
+	}
+
+	network, addr = listen[:i], listen[i+1:]
 
-		go ServerFromStream(ctx, jsonrpc2.NewHeaderStream(conn, conn), config)
+	switch network {
+	case "tcp", "unix":
+	default:
+		return "", "", fmt.Errorf("unsupported network %q, expected tcp or unix", network)
 	}
+
+	if addr == "" {
+		return "", "", fmt.Errorf("missing address in %q", listen)
+	}
+
+	return network, addr, nil
 }
 
 // StdioServer generates a Server instance talking to stdio.
@@ -176,3 +297,19 @@ func (s *server) Info(format string, a ...interface{}) {
 		Message: fmt.Sprintf(format, a...),
 	})
 }
+
+// disconnect closes the documents opened over a connection that ended, and stops sending log messages to it.
+func (s *server) disconnect() {
+	s.openedMu.Lock()
+	opened := s.opened
+	s.opened = nil
+	s.openedMu.Unlock()
+
+	for uri, n := range opened {
+		for i := 0; i < n; i++ {
+			s.workspace.RemoveDocument(uri) // nolint: errcheck
+		}
+	}
+
+	s.workspace.unlisten(s)
+}
@@ -21,7 +21,7 @@ import (
 
 // // SignatureHelp is required by the protocol.Server interface
 // func (s *server) SignatureHelp(ctx context.Context, params *protocol.SignatureHelpParams) (*protocol.SignatureHelp, error) {
-// 	location, err := s.cache.Find(&params.TextDocumentPositionParams)
+// 	location, err := s.workspace.Find(&params.TextDocumentPositionParams)
 // 	if err != nil {
 // 		return nil, nil
 // 	}
@@ -0,0 +1,104 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/event"
+	"cuelang.org/go/cue/internal/lsp/metrics"
+)
+
+// StartTelemetry exports metrics and traces as configured, until the context is done.
+func StartTelemetry(ctx context.Context, config *Config) error {
+	return metrics.Start(ctx, metrics.Config{
+		MetricsAddress: config.MetricsAddress,
+		OCAgentAddress: config.OCAgentAddress,
+	})
+}
+
+// metricsStream records the latency and errors of the requests received over a stream.
+type metricsStream struct {
+	jsonrpc2.Stream
+
+	mu sync.Mutex
+	// The requests that have not been answered yet, by their ID.
+	pending map[string]pendingRequest
+}
+
+type pendingRequest struct {
+	method string
+	start  time.Time
+}
+
+// rpcMessage contains the fields of requests and responses that are needed for metrics.
+type rpcMessage struct {
+	ID     *jsonrpc2.ID    `json:"id"`
+	Method string          `json:"method"`
+	Error  *jsonrpc2.Error `json:"error"`
+}
+
+func newMetricsStream(stream jsonrpc2.Stream) jsonrpc2.Stream {
+	return &metricsStream{
+		Stream:  stream,
+		pending: make(map[string]pendingRequest),
+	}
+}
+
+func (s *metricsStream) Read(ctx context.Context) ([]byte, int64, error) {
+	data, count, err := s.Stream.Read(ctx)
+	if err != nil {
+		return data, count, err
+	}
+
+	var msg rpcMessage
+	if json.Unmarshal(data, &msg) == nil && msg.ID != nil && msg.Method != "" {
+		s.mu.Lock()
+		s.pending[msg.ID.String()] = pendingRequest{msg.Method, time.Now()}
+		s.mu.Unlock()
+	}
+
+	return data, count, err
+}
+
+func (s *metricsStream) Write(ctx context.Context, data []byte) (int64, error) {
+	var msg rpcMessage
+	if json.Unmarshal(data, &msg) == nil && msg.ID != nil && msg.Method == "" {
+		s.mu.Lock()
+		req, ok := s.pending[msg.ID.String()]
+		delete(s.pending, msg.ID.String())
+		s.mu.Unlock()
+
+		if ok {
+			recordRequest(ctx, req, msg.Error)
+		}
+	}
+
+	return s.Stream.Write(ctx, data)
+}
+
+func recordRequest(ctx context.Context, req pendingRequest, err *jsonrpc2.Error) {
+	latency := float64(time.Since(req.start)) / float64(time.Millisecond)
+
+	event.Record2(ctx, metrics.Method.Of(req.method), metrics.Latency.Of(latency))
+
+	if err != nil {
+		event.Record3(ctx, metrics.Method.Of(req.method), metrics.Code.Of(strconv.FormatInt(err.Code, 10)), metrics.Errors.Of(1))
+	}
+}
@@ -28,11 +28,18 @@ import (
 // DidOpen receives a call from the Client, telling that a files has been opened
 // required by the protocol.Server interface
 func (s *server) DidOpen(ctx context.Context, params *protocol.DidOpenTextDocumentParams) error {
-	_, err := s.cache.AddDocument(s.lifetime, &params.TextDocument)
+	_, err := s.workspace.AddDocument(s.lifetime, &params.TextDocument)
 	if err != nil {
 		return err
 	}
 
+	s.openedMu.Lock()
+	if s.opened == nil {
+		s.opened = make(map[protocol.DocumentURI]int)
+	}
+	s.opened[params.TextDocument.URI]++
+	s.openedMu.Unlock()
+
 	if !s.headless {
 		go s.diagnostics(params.TextDocument.URI)
 	}
@@ -43,8 +50,19 @@ func (s *server) DidOpen(ctx context.Context, params *protocol.DidOpenTextDocume
 // DidClose receives a call from the Client, telling that a files has been closed
 // required by the protocol.Server interface
 func (s *server) DidClose(_ context.Context, params *protocol.DidCloseTextDocumentParams) error {
-	s.clearDiagnostics(s.lifetime, params.TextDocument.URI, 0)
-	return s.cache.RemoveDocument(params.TextDocument.URI)
+	uri := params.TextDocument.URI
+
+	s.openedMu.Lock()
+	if s.opened[uri] > 1 {
+		s.opened[uri]--
+	} else {
+		delete(s.opened, uri)
+	}
+	s.openedMu.Unlock()
+
+	s.clearDiagnostics(s.lifetime, uri, 0)
+
+	return s.workspace.RemoveDocument(uri)
 }
 
 // DidChange receives a call from the Client, telling that a files has been changed
@@ -57,7 +75,7 @@ func (s *server) DidChange(ctx context.Context, params *protocol.DidChangeTextDo
 
 	uri := params.TextDocument.URI
 
-	doc, err := s.cache.GetDocument(uri)
+	doc, err := s.workspace.GetDocument(uri)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,247 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/tool"
+	"cuelang.org/go/cue/token"
+)
+
+// completeTaskFields offers the fields of the task, like exec.Run, that the struct of a completion belongs to.
+func (s *server) completeTaskFields(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location) {
+	if grammarContextAt(location)&structContext == 0 {
+		return
+	}
+
+	file := fileAt(location)
+	if file == nil {
+		return
+	}
+
+	fields, present := taskFieldsAt(file, location.Pos)
+	if len(fields) == 0 {
+		return
+	}
+
+	var replace *protocol.Range
+
+	if _, ok := location.Node.(*asg.Reference); ok {
+		if rng, err := getEditRange(location, ""); err == nil {
+			replace = &rng
+		}
+	}
+
+	for _, f := range fields {
+		if present[f.Name] {
+			continue
+		}
+
+		f := f
+		item := protocol.CompletionItem{
+			Label:  f.Name,
+			Kind:   protocol.FieldCompletion,
+			Detail: strings.Join(strings.Fields(f.Type), " "),
+		}
+
+		if replace != nil {
+			item.TextEdit = &protocol.TextEdit{Range: *replace, NewText: f.Name + ": "}
+		} else {
+			item.InsertText = f.Name + ": "
+		}
+
+		*completions = append(*completions, lazy(item, func(ctx context.Context, item *protocol.CompletionItem) {
+			item.Documentation = protocol.MarkupContent{
+				Kind:  protocol.Markdown,
+				Value: f.Markdown(),
+			}
+		}))
+	}
+}
+
+// taskFieldsAt returns the fields of the task a position is in, and the labels of the fields the struct
+// around the position already has.
+//
+// In the struct of a field of a task, like request of http.Do, the fields of that field are returned.
+func taskFieldsAt(file *ast.File, pos token.Pos) ([]tool.Field, map[string]bool) {
+	var stack []ast.Node
+
+	var enclosing []ast.Node
+
+	// The whole file is walked, since the implicit structs of labels like a: b: c have no position.
+	ast.Walk(file, func(n ast.Node) bool {
+		stack = append(stack, n)
+
+		if s, ok := n.(*ast.StructLit); ok && s.Lbrace.IsValid() && s.Rbrace.IsValid() &&
+			s.Lbrace.Offset() < pos.Offset() && pos.Offset() <= s.Rbrace.Offset() {
+			enclosing = append([]ast.Node{}, stack...)
+		}
+
+		return true
+	}, func(n ast.Node) {
+		stack = stack[:len(stack)-1]
+	})
+
+	if len(enclosing) == 0 {
+		return nil, nil
+	}
+
+	imports := toolImports(file)
+	present := map[string]bool{}
+
+	for _, d := range enclosing[len(enclosing)-1].(*ast.StructLit).Elts {
+		if f, ok := d.(*ast.Field); ok {
+			if name, _, err := ast.LabelName(f.Label); err == nil {
+				present[name] = true
+			}
+		}
+	}
+
+	// Walk up from the struct to the task, remembering the fields in between.
+	var labels []string
+
+	for i := len(enclosing) - 1; i >= 0; i-- {
+		switch n := enclosing[i].(type) {
+		case *ast.StructLit:
+			var parent ast.Node
+			if i > 0 {
+				parent = enclosing[i-1]
+			}
+
+			t := taskOf(n, parent, imports)
+			if t == nil {
+				continue
+			}
+
+			var f *tool.Field
+
+			for j := len(labels) - 1; j >= 0; j-- {
+				if f == nil {
+					f = t.Field(labels[j])
+				} else {
+					f = f.Field(labels[j])
+				}
+
+				if f == nil {
+					return nil, nil
+				}
+			}
+
+			if f != nil {
+				return f.Fields, present
+			}
+
+			return t.Fields, present
+		case *ast.Field:
+			name, _, err := ast.LabelName(n.Label)
+			if err != nil {
+				return nil, nil
+			}
+
+			labels = append(labels, name)
+		}
+	}
+
+	return nil, nil
+}
+
+// toolImports returns the import paths of the tool packages a file imports, by the name they are imported as.
+func toolImports(file *ast.File) map[string]string {
+	ret := map[string]string{}
+
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || !tool.IsPackage(importPath) {
+			continue
+		}
+
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+
+		ret[name] = importPath
+	}
+
+	return ret
+}
+
+// taskOf returns the task a struct is a task of, or nil.
+//
+// A struct is a task if it sets $id or kind, embeds a task like exec.Run, or is unified with one,
+// as in exec.Run & {...}.
+func taskOf(s *ast.StructLit, parent ast.Node, imports map[string]string) *tool.Task {
+	for _, d := range s.Elts {
+		switch d := d.(type) {
+		case *ast.Field:
+			name, _, _ := ast.LabelName(d.Label)
+			if lit, ok := d.Value.(*ast.BasicLit); ok && (name == "$id" || name == "kind") {
+				if id, err := strconv.Unquote(lit.Value); err == nil {
+					if t := tool.Lookup(id); t != nil {
+						return t
+					}
+				}
+			}
+		case *ast.EmbedDecl:
+			if t := taskOfExpr(d.Expr, imports); t != nil {
+				return t
+			}
+		}
+	}
+
+	switch p := parent.(type) {
+	case *ast.BinaryExpr:
+		if p.Op != token.AND {
+			return nil
+		}
+
+		if t := taskOfExpr(p.X, imports); t != nil {
+			return t
+		}
+
+		return taskOfExpr(p.Y, imports)
+	case *ast.CallExpr:
+		return taskOfExpr(p.Fun, imports)
+	}
+
+	return nil
+}
+
+// taskOfExpr returns the task a selector like exec.Run refers to, or nil.
+func taskOfExpr(expr ast.Expr, imports map[string]string) *tool.Task {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+
+	x, ok := sel.X.(*ast.Ident)
+	if !ok || imports[x.Name] == "" {
+		return nil
+	}
+
+	name, _, err := ast.LabelName(sel.Sel)
+	if err != nil {
+		return nil
+	}
+
+	return tool.Find(imports[x.Name], name)
+}
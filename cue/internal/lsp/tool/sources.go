@@ -0,0 +1,200 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tool
+
+// sources are the schemas of the tool packages, as in pkg/tool/*/*.cue.
+//
+// The builtin packages of the cue package do not keep their comments, so the schemas are
+// repeated here for their documentation. TestSources checks that they declare the same tasks.
+var sources = map[string]string{
+	"tool/cli": `package cli
+
+// Print sends text to the stdout of the current process.
+Print: {
+	$id: *"tool/cli.Print" | "print" // for backwards compatibility
+
+	// text is the text to be printed.
+	text: string
+}
+`,
+	"tool/exec": `package exec
+
+// Run executes the given shell command.
+Run: {
+	$id: *"tool/exec.Run" | "exec" // exec for backwards compatibility
+
+	// cmd is the command to run.
+	cmd: string | [string, ...string]
+
+	// env defines the environment variables to use for this system.
+	// If the value is a list, the entries mus be of the form key=value,
+	// where the last value takes precendence in the case of multiple
+	// occurrances of the same key.
+	env: [string]: string | [...=~"="]
+
+	// stdout captures the output from stdout if it is of type bytes or string.
+	// The default value of null indicates it is redirected to the stdout of the
+	// current process.
+	stdout: *null | string | bytes
+
+	// stderr is like stdout, but for errors.
+	stderr: *null | string | bytes
+
+	// stdin specifies the input for the process. If stdin is null, the stdin
+	// of the current process is redirected to this command (the default).
+	// If it is of typ bytes or string, that input will be used instead.
+	stdin: *null | string | bytes
+
+	// success is set to true when the process terminates with with a zero exit
+	// code or false otherwise. The user can explicitly specify the value
+	// force a fatal error if the desired success code is not reached.
+	success: bool
+}
+`,
+	"tool/file": `package file
+
+// Read reads the contents of a file.
+Read: {
+	$id: "tool/file.Read"
+
+	// filename names the file to read.
+	//
+	// Relative names are taken relative to the current working directory.
+	// Slashes are converted to the native OS path separator.
+	filename: !=""
+
+	// contents is the read contents. If the contents are constraint to bytes
+	// (the default), the file is read as is. If it is constraint to a string,
+	// the contents are checked to be valid UTF-8.
+	contents: *bytes | string
+}
+
+// Append writes contents to the given file.
+Append: {
+	$id: "tool/file.Append"
+
+	// filename names the file to append.
+	//
+	// Relative names are taken relative to the current working directory.
+	// Slashes are converted to the native OS path separator.
+	filename: !=""
+
+	// permissions defines the permissions to use if the file does not yet exist.
+	permissions: int | *0o644
+
+	// contents specifies the bytes to be written.
+	contents: bytes | string
+}
+
+// Create writes contents to the given file.
+Create: {
+	$id: "tool/file.Create"
+
+	// filename names the file to write.
+	//
+	// Relative names are taken relative to the current working directory.
+	// Slashes are converted to the native OS path separator.
+	filename: !=""
+
+	// permissions defines the permissions to use if the file does not yet exist.
+	permissions: int | *0o644
+
+	// contents specifies the bytes to be written.
+	contents: bytes | string
+}
+
+// Glob returns a list of files.
+Glob: {
+	$id: "tool/file.Glob"
+
+	// glob specifies the pattern to match files with.
+	//
+	// A relative pattern is taken relative to the current working directory.
+	// Slashes are converted to the native OS path separator.
+	glob: !=""
+	files: [...string]
+}
+`,
+	"tool/http": `package http
+
+Get:    Do & {method: "GET"}
+Post:   Do & {method: "POST"}
+Put:    Do & {method: "PUT"}
+Delete: Do & {method: "DELETE"}
+
+Do: {
+	$id: *"tool/http.Do" | "http" // http for backwards compatibility
+
+	method: string
+	url:    string // TODO: make url.URL type
+
+	request: {
+		body: *bytes | string
+		header: [string]:  string | [...string]
+		trailer: [string]: string | [...string]
+	}
+	response: {
+		status:     string
+		statusCode: int
+
+		body: *bytes | string
+		header: [string]:  string | [...string]
+		trailer: [string]: string | [...string]
+	}
+}
+`,
+	"tool/os": `package os
+
+// A Value are all possible values allowed in flags.
+// A null value unsets an environment variable.
+Value :: bool | number | *string | null
+
+// Name indicates a valid flag name.
+Name :: !="" & !~"^[$]"
+
+// Setenv defines a set of command line flags, the values of which will be set
+// at run time. The doc comment of the flag is presented to the user in help.
+//
+// To define a shorthand, define the shorthand as a new flag referring to
+// the flag of which it is a shorthand.
+Setenv: {
+	$id: "tool/os.Setenv"
+
+	{[Name]: Value}
+}
+
+// Getenv gets and parses the specific command line variables.
+Getenv: {
+	$id: "tool/os.Getenv"
+
+	{[Name]: Value}
+}
+
+// Environ populates a struct with all environment variables.
+Environ: {
+	$id: "tool/os.Environ"
+
+	// A map of all populated values.
+	// Individual entries may be specified ahead of time to enable
+	// validation and parsing. Values that are marked as required
+	// will fail the task if they are not found.
+	{[Name]: Value}
+}
+
+// Clearenv clears all environment variables.
+Clearenv: {
+	$id: "tool/os.Clearenv"
+}
+`,
+}
@@ -0,0 +1,303 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tool describes the tasks of the tool/* packages, like exec.Run, that the commands of
+// _tool.cue files are made of, so the language server can document, complete and check them.
+package tool
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+)
+
+// Task is a task of a tool package, like exec.Run.
+type Task struct {
+	// ID is the value of the $id field that selects the task, like tool/exec.Run.
+	ID string
+	// Package is the import path of the package defining the task, like tool/exec.
+	Package string
+	// Name is the name of the task in its package, like Run.
+	Name string
+	// Kinds are the older names of the task, which may be used as $id or kind.
+	Kinds []string
+	Doc   string
+	// Fields are the fields of the task, without $id.
+	Fields []Field
+}
+
+// Field is a field of a task.
+type Field struct {
+	Name string
+	// Type is the constraint of the field in CUE syntax, like string | [string, ...string].
+	Type string
+	Doc  string
+	// Fields are the fields of a struct valued field.
+	Fields []Field
+}
+
+// Markdown returns the documentation of a task.
+func (t *Task) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "```cue\n%s.%s: {$id: %q}\n```\n", path(t.Package), t.Name, t.ID)
+
+	if t.Doc != "" {
+		b.WriteString(t.Doc)
+	}
+
+	return b.String()
+}
+
+// Markdown returns the documentation of a field.
+func (f *Field) Markdown() string {
+	ret := fmt.Sprintf("```cue\n%s: %s\n```\n", f.Name, f.Type)
+
+	return ret + f.Doc
+}
+
+// Field returns the field with the given name, or nil.
+func (t *Task) Field(name string) *Field {
+	return field(t.Fields, name)
+}
+
+// Field returns the field of a struct valued field with the given name, or nil.
+func (f *Field) Field(name string) *Field {
+	return field(f.Fields, name)
+}
+
+func field(fields []Field, name string) *Field {
+	for i := range fields {
+		if fields[i].Name == name {
+			return &fields[i]
+		}
+	}
+
+	return nil
+}
+
+// path returns the package name of an import path.
+func path(importPath string) string {
+	return importPath[strings.LastIndexByte(importPath, '/')+1:]
+}
+
+// Tasks returns all tasks, sorted by their package and name.
+func Tasks() []*Task {
+	return tasks
+}
+
+// Lookup returns the task with the given $id, or nil if there is none.
+//
+// The older names of tasks, like exec, are accepted as well.
+func Lookup(id string) *Task {
+	for _, t := range tasks {
+		if t.ID != id {
+			found := false
+
+			for _, k := range t.Kinds {
+				found = found || k == id
+			}
+
+			if !found {
+				continue
+			}
+		}
+
+		// Aliases like http.Get share the $id of the task they are based on.
+		if strings.HasSuffix(t.ID, "."+t.Name) {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Find returns the task with the given name in the package with the given import path, or nil.
+func Find(importPath string, name string) *Task {
+	for _, t := range tasks {
+		if t.Package == importPath && t.Name == name {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// IsPackage reports whether an import path is the path of a tool package.
+func IsPackage(importPath string) bool {
+	_, ok := sources[importPath]
+
+	return ok
+}
+
+var tasks = parseTasks()
+
+// parseTasks returns the tasks declared in the sources.
+func parseTasks() []*Task {
+	var ret []*Task
+
+	for pkg, src := range sources {
+		file, err := parser.ParseFile(pkg, src, parser.ParseComments)
+		if err != nil {
+			panic(fmt.Sprintf("invalid schema of package %s: %v", pkg, err))
+		}
+
+		byName := map[string]*Task{}
+
+		// Aliases like Get: Do & {method: "GET"} may precede the task they are based on.
+		for _, aliases := range []bool{false, true} {
+			for _, d := range file.Decls {
+				f, ok := d.(*ast.Field)
+				if !ok || isDefinition(f) {
+					continue
+				}
+
+				if _, ok := f.Value.(*ast.BinaryExpr); ok != aliases {
+					continue
+				}
+
+				if t := newTask(pkg, f, byName); t != nil {
+					byName[t.Name] = t
+					ret = append(ret, t)
+				}
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Package != ret[j].Package {
+			return ret[i].Package < ret[j].Package
+		}
+
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+// newTask returns the task declared by a field of a tool package, or nil if it is not a task.
+//
+// byName holds the tasks of the package declared so far.
+func newTask(pkg string, f *ast.Field, byName map[string]*Task) *Task {
+	name, _, err := ast.LabelName(f.Label)
+	if err != nil {
+		return nil
+	}
+
+	t := &Task{Package: pkg, Name: name, Doc: docOf(f)}
+
+	switch v := f.Value.(type) {
+	case *ast.StructLit:
+		for _, d := range v.Elts {
+			sf, ok := d.(*ast.Field)
+			if !ok {
+				continue
+			}
+
+			label, _, _ := ast.LabelName(sf.Label)
+			if label == "$id" {
+				t.ID, t.Kinds = ids(sf.Value)
+				continue
+			}
+
+			t.Fields = append(t.Fields, newField(label, sf))
+		}
+	case *ast.BinaryExpr:
+		// Aliases have the fields of the task they are based on.
+		base, ok := v.X.(*ast.Ident)
+		if !ok || byName[base.Name] == nil {
+			return nil
+		}
+
+		b := byName[base.Name]
+		t.ID, t.Kinds, t.Fields = b.ID, b.Kinds, b.Fields
+
+		if with, err := format.Node(v.Y); t.Doc == "" && err == nil {
+			t.Doc = fmt.Sprintf("%s is %s.%s with %s.", name, path(pkg), base.Name, with)
+		}
+	}
+
+	if t.ID == "" {
+		return nil
+	}
+
+	return t
+}
+
+// isDefinition reports whether a field declares a definition, like Name :: string, rather than a task.
+func isDefinition(f *ast.Field) bool {
+	name, _, _ := ast.LabelName(f.Label)
+
+	return f.Token == token.ISA || strings.HasPrefix(name, "#")
+}
+
+// newField returns the field described by a field declaration.
+func newField(name string, f *ast.Field) Field {
+	ret := Field{Name: name, Doc: docOf(f)}
+
+	b, _ := format.Node(f.Value)
+	ret.Type = string(b)
+
+	if s, ok := f.Value.(*ast.StructLit); ok {
+		for _, d := range s.Elts {
+			if sf, ok := d.(*ast.Field); ok {
+				label, _, _ := ast.LabelName(sf.Label)
+				ret.Fields = append(ret.Fields, newField(label, sf))
+			}
+		}
+	}
+
+	return ret
+}
+
+// ids returns the $id and the older names of a task from the value of its $id field,
+// like *"tool/exec.Run" | "exec".
+func ids(v ast.Expr) (id string, kinds []string) {
+	switch v := v.(type) {
+	case *ast.BasicLit:
+		s, err := strconv.Unquote(v.Value)
+		if err != nil {
+			return "", nil
+		}
+
+		return s, nil
+	case *ast.UnaryExpr:
+		return ids(v.X)
+	case *ast.BinaryExpr:
+		id, kinds = ids(v.X)
+		other, more := ids(v.Y)
+
+		return id, append(append(kinds, other), more...)
+	}
+
+	return "", nil
+}
+
+// docOf returns the doc comment of a node.
+func docOf(n ast.Node) string {
+	var docs []string
+
+	for _, c := range n.Comments() {
+		if c.Doc {
+			docs = append(docs, c.Text())
+		}
+	}
+
+	return strings.Join(docs, "\n")
+}
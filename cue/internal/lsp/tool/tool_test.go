@@ -0,0 +1,109 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tool
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"cuelang.org/go/cue"
+)
+
+// TestSources checks that the sources declare the tasks and fields of the builtin tool packages.
+func TestSources(t *testing.T) {
+	var r cue.Runtime
+
+	for pkg := range sources {
+		inst, err := r.Compile(pkg, fmt.Sprintf("import %q\npkg: %s", pkg, path(pkg)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iter, err := inst.Lookup("pkg").Fields()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for iter.Next() {
+			id := iter.Value().Lookup("$id")
+			if iter.Value().Kind() != cue.StructKind || !id.Exists() {
+				continue
+			}
+
+			task := Find(pkg, iter.Label())
+			if task == nil {
+				t.Errorf("missing task %s.%s", pkg, iter.Label())
+				continue
+			}
+
+			if s, err := id.String(); err == nil && s != task.ID {
+				t.Errorf("expected $id %s of task %s.%s, got %s", s, pkg, task.Name, task.ID)
+			}
+
+			var expected, got []string
+
+			fields, _ := iter.Value().Fields()
+			for fields.Next() {
+				if fields.Label() != "$id" {
+					expected = append(expected, fields.Label())
+				}
+			}
+
+			for _, f := range task.Fields {
+				got = append(got, f.Name)
+			}
+
+			sort.Strings(expected)
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(expected, got) {
+				t.Errorf("expected fields %q of task %s.%s, got %q", expected, pkg, task.Name, got)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected string
+	}{
+		{"tool/exec.Run", "tool/exec.Run"},
+		{"exec", "tool/exec.Run"},
+		{"tool/http.Do", "tool/http.Do"},
+		{"print", "tool/cli.Print"},
+		{"tool/file.Create", "tool/file.Create"},
+		{"tool/exec.Start", ""},
+	}
+
+	for _, test := range tests {
+		task := Lookup(test.id)
+
+		got := ""
+		if task != nil {
+			got = task.Package + "." + task.Name
+		}
+
+		if got != test.expected {
+			t.Errorf("%s: expected task %q, got %q", test.id, test.expected, got)
+		}
+	}
+
+	get := Find("tool/http", "Get")
+	if get == nil || get.ID != "tool/http.Do" || get.Field("request").Field("body") == nil {
+		t.Errorf("expected http.Get to have the fields of http.Do, got %+v", get)
+	}
+}
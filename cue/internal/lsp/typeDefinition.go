@@ -0,0 +1,102 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+
+	"cuelang.org/go/cue/internal/lsp/asg"
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+)
+
+// TypeDefinition is required by the protocol.Server interface
+func (s *server) TypeDefinition(ctx context.Context, params *protocol.TypeDefinitionParams) ([]protocol.Location, error) {
+	location, err := s.workspace.Find(ctx, &params.TextDocumentPositionParams)
+	if err != nil {
+		return nil, cancelled(ctx)
+	}
+
+	decl := enclosingDecl(location.Node)
+	if decl == nil {
+		return nil, nil
+	}
+
+	types := asg.TypeDecls(decl)
+	if len(types) == 0 && decl.IsDefinition() {
+		// Most likely a reference to a definition, so that is our type.
+		types = []*asg.Decl{decl}
+	}
+
+	ret := []protocol.Location{}
+
+	for _, typ := range types {
+		locs, err := declLocations(location.Doc, typ)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, locs...)
+	}
+
+	return ret, nil
+}
+
+// enclosingDecl returns the Decl a node belongs to.
+// For references, the referenced Decl is returned instead.
+func enclosingDecl(node asg.Node) *asg.Decl {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *asg.Decl:
+		return n
+	case *asg.Reference:
+		decl, _ := n.Referenced.(*asg.Decl)
+		return decl
+	}
+
+	return asg.ParentDecl(node)
+}
+
+// nodeLocation returns the protocol.Location of an asg.Node.
+//
+// The node does not need to be part of the given document.
+func nodeLocation(doc *cache.DocumentHandle, node asg.Node) (loc protocol.Location, err error) {
+	loc.URI = cache.URIFromPath(node.Pos().Filename())
+
+	loc.Range.Start, err = doc.PosToProtocolPosition(node.Pos())
+	if err != nil {
+		return
+	}
+
+	loc.Range.End, err = doc.PosToProtocolPosition(node.End())
+
+	return //nolint: nakedret
+}
+
+// declLocations returns the locations of all labels of a Decl.
+func declLocations(doc *cache.DocumentHandle, decl *asg.Decl) ([]protocol.Location, error) {
+	ret := []protocol.Location{}
+
+	for _, label := range decl.Labels {
+		loc, err := nodeLocation(doc, label)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, loc)
+	}
+
+	return ret, nil
+}
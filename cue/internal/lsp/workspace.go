@@ -0,0 +1,441 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue/internal/lsp/cache"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/jsonrpc2"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/lsp/protocol"
+	"cuelang.org/go/cue/internal/lsp/internal/vendored/go-tools/telemetry/event"
+	"cuelang.org/go/cue/internal/lsp/metrics"
+)
+
+// workspace holds a cache for every workspace folder and routes documents to the folder containing them.
+//
+// A workspace can be shared by the servers of several connections. Documents stay open until all connections that opened them closed them.
+//
+// The zero value has no folders and rejects all documents.
+type workspace struct {
+	mu sync.RWMutex
+	// Sorted by descending path length, so nested folders take precedence over their parents.
+	folders []*folder
+	// fallback holds the documents that are not inside any workspace folder.
+	fallback *folder
+	// All caches send their log messages to this channel.
+	logging chan protocol.LogMessageParams
+	// The servers the log messages are forwarded to.
+	listeners map[*server]struct{}
+	// How often every document has been opened.
+	opened map[protocol.DocumentURI]int
+	// Runs the compiles of all folders, so only one of them runs at a time in the whole workspace.
+	scheduler *cache.Scheduler
+	// The recent RPC messages of all connections, if the debug server is enabled.
+	traces *traceBuffer
+}
+
+// folder is a workspace folder with its own cache and settings.
+type folder struct {
+	uri   protocol.DocumentURI
+	cache *cache.DocumentCache
+	// Guarded by workspace.mu.
+	settings Settings
+}
+
+// init prepares the workspace for adding folders, unless that happened before.
+//
+// It reports whether the workspace was prepared by this call.
+func (w *workspace) init() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fallback != nil {
+		return false
+	}
+
+	w.logging = make(chan protocol.LogMessageParams, 100)
+	w.listeners = make(map[*server]struct{})
+	w.opened = make(map[protocol.DocumentURI]int)
+	w.scheduler = cache.NewScheduler()
+	w.fallback = w.newFolder("")
+
+	go w.forwardLogs()
+
+	return true
+}
+
+// forwardLogs sends the log messages of the caches to all listening servers.
+func (w *workspace) forwardLogs() {
+	for msg := range w.logging {
+		for _, s := range w.getListeners() {
+			msg := msg
+			s.client.LogMessage(s.lifetime, &msg) // nolint: errcheck
+		}
+	}
+}
+
+// getListeners returns the servers using the workspace.
+func (w *workspace) getListeners() []*server {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	listeners := make([]*server, 0, len(w.listeners))
+	for s := range w.listeners {
+		listeners = append(listeners, s)
+	}
+
+	return listeners
+}
+
+// listen starts forwarding log messages to a server.
+func (w *workspace) listen(s *server) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.listeners[s] = struct{}{}
+}
+
+// unlisten stops forwarding log messages to a server.
+func (w *workspace) unlisten(s *server) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.listeners, s)
+}
+
+func (w *workspace) newFolder(uri protocol.DocumentURI) *folder {
+	f := &folder{
+		uri:   uri,
+		cache: &cache.DocumentCache{Scheduler: w.scheduler},
+	}
+
+	f.cache.Init()
+	f.cache.LoadRootFolder(uri)
+	f.cache.Logging = w.logging
+	f.cache.Progress = w.startProgress
+
+	return f
+}
+
+// addFolder adds a workspace folder, unless it already exists.
+//
+// The new folder starts out with the given settings.
+func (w *workspace) addFolder(uri protocol.DocumentURI, settings Settings) *folder {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, f := range w.folders {
+		if f.uri == uri {
+			return f
+		}
+	}
+
+	f := w.newFolder(uri)
+	f.settings = settings
+	f.cache.SetOptions(context.Background(), settings.cacheOptions())
+
+	w.folders = append(w.folders, f)
+
+	sort.SliceStable(w.folders, func(i, j int) bool {
+		return len(cache.PathFromURI(w.folders[i].uri)) > len(cache.PathFromURI(w.folders[j].uri))
+	})
+
+	return f
+}
+
+// removeFolder removes a workspace folder and returns it, or nil if there is no such folder.
+func (w *workspace) removeFolder(uri protocol.DocumentURI) *folder {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, f := range w.folders {
+		if f.uri == uri {
+			w.folders = append(w.folders[:i], w.folders[i+1:]...)
+			return f
+		}
+	}
+
+	return nil
+}
+
+// getFolders returns all workspace folders, including the fallback folder if it is requested.
+func (w *workspace) getFolders(withFallback bool) []*folder {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	ret := append([]*folder{}, w.folders...)
+	if withFallback && w.fallback != nil {
+		ret = append(ret, w.fallback)
+	}
+
+	return ret
+}
+
+// folderFor returns the innermost folder containing the document, or the fallback folder.
+func (w *workspace) folderFor(uri protocol.DocumentURI) *folder {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	path := cache.PathFromURI(uri)
+
+	for _, f := range w.folders {
+		root := cache.PathFromURI(f.uri)
+		if root != "" && (path == root || strings.HasPrefix(path, root+string(filepath.Separator))) {
+			return f
+		}
+	}
+
+	return w.fallback
+}
+
+func (w *workspace) cacheFor(uri protocol.DocumentURI) (*cache.DocumentCache, error) {
+	f := w.folderFor(uri)
+	if f == nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInternalError, "no workspace folder for %v", uri)
+	}
+
+	return f.cache, nil
+}
+
+// settingsFor returns the settings of the folder containing the document.
+func (w *workspace) settingsFor(uri protocol.DocumentURI) Settings {
+	f := w.folderFor(uri)
+	if f == nil {
+		return Settings{}
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return f.settings
+}
+
+// setSettings changes the settings of a folder and returns the handles of its recompiled documents.
+func (w *workspace) setSettings(serverLifetime context.Context, f *folder, settings Settings) []*cache.DocumentHandle {
+	w.mu.Lock()
+	f.settings = settings
+	w.mu.Unlock()
+
+	return f.cache.SetOptions(serverLifetime, settings.cacheOptions())
+}
+
+// rehome moves documents whose responsible folder changed after folders have been added or removed.
+//
+// It returns the handles of the moved documents.
+func (w *workspace) rehome(serverLifetime context.Context) ([]*cache.DocumentHandle, error) {
+	var moved []*cache.DocumentHandle
+
+	for _, f := range w.getFolders(true) {
+		for _, doc := range f.cache.GetDocuments() {
+			target := w.folderFor(doc.GetURI())
+			if target == f {
+				continue
+			}
+
+			handle, err := moveDocument(serverLifetime, doc, f.cache, target.cache)
+			if err != nil {
+				return moved, err
+			}
+
+			moved = append(moved, handle)
+		}
+	}
+
+	return moved, nil
+}
+
+func moveDocument(serverLifetime context.Context, doc *cache.DocumentHandle, from *cache.DocumentCache, to *cache.DocumentCache) (*cache.DocumentHandle, error) {
+	content, err := doc.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := doc.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := from.RemoveDocument(doc.GetURI()); err != nil {
+		return nil, err
+	}
+
+	return to.AddDocument(serverLifetime, &protocol.TextDocumentItem{
+		URI:        doc.GetURI(),
+		LanguageID: doc.GetLanguageID(),
+		Version:    version,
+		Text:       content,
+	})
+}
+
+// AddDocument adds a document to the cache of the folder containing it.
+//
+// If another connection opened the document already, its content is replaced instead.
+func (w *workspace) AddDocument(serverLifetime context.Context, doc *protocol.TextDocumentItem) (*cache.DocumentHandle, error) {
+	c, err := w.cacheFor(doc.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Versions of different clients are unrelated, so a document opened by another connection is replaced by the new one.
+	if w.opened[doc.URI] > 0 {
+		if err := c.RemoveDocument(doc.URI); err != nil {
+			return nil, err
+		}
+	}
+
+	handle, err := c.AddDocument(serverLifetime, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	w.opened[doc.URI]++
+	event.Record1(serverLifetime, metrics.Documents.Of(int64(len(w.opened))))
+
+	return handle, nil
+}
+
+// GetDocument retrieves a document from the cache of the folder containing it.
+func (w *workspace) GetDocument(uri protocol.DocumentURI) (*cache.DocumentHandle, error) {
+	c, err := w.cacheFor(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetDocument(uri)
+}
+
+// GetDocuments returns the documents of all folders.
+func (w *workspace) GetDocuments() []*cache.DocumentHandle {
+	var ret []*cache.DocumentHandle
+
+	for _, f := range w.getFolders(true) {
+		ret = append(ret, f.cache.GetDocuments()...)
+	}
+
+	return ret
+}
+
+// RemoveDocument removes a document from the cache of the folder containing it, once every connection that opened it closed it.
+func (w *workspace) RemoveDocument(uri protocol.DocumentURI) error {
+	c, err := w.cacheFor(uri)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.opened[uri] > 1 {
+		w.opened[uri]--
+		return nil
+	}
+
+	delete(w.opened, uri)
+	event.Record1(context.Background(), metrics.Documents.Of(int64(len(w.opened))))
+
+	return c.RemoveDocument(uri)
+}
+
+// Find looks up the node at a position in a document.
+//
+// It gives up waiting for the document to compile once the context is done.
+func (w *workspace) Find(ctx context.Context, where *protocol.TextDocumentPositionParams) (*cache.Location, error) {
+	c, err := w.cacheFor(where.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Find(ctx, where)
+}
+
+// ReadFile returns the content of a file, preferring the content of open documents.
+func (w *workspace) ReadFile(path string) (string, error) {
+	c, err := w.cacheFor(cache.URIFromPath(path))
+	if err != nil {
+		return "", err
+	}
+
+	return c.ReadFile(path)
+}
+
+// DidChangeWorkspaceFolders is required by the protocol.Server interface
+//
+// Removed folders have their diagnostics cleared; their open documents move to the folder containing them now.
+func (s *server) DidChangeWorkspaceFolders(ctx context.Context, params *protocol.DidChangeWorkspaceFoldersParams) error {
+	var removed []*folder
+
+	for _, folder := range params.Event.Removed {
+		if f := s.workspace.removeFolder(protocol.DocumentURI(folder.URI)); f != nil {
+			s.clearFolderDiagnostics(f)
+			removed = append(removed, f)
+		}
+	}
+
+	var added []*folder
+	for _, folder := range params.Event.Added {
+		added = append(added, s.workspace.addFolder(protocol.DocumentURI(folder.URI), s.config.CUE))
+	}
+
+	var moved []*cache.DocumentHandle
+
+	for _, f := range removed {
+		for _, doc := range f.cache.GetDocuments() {
+			target := s.workspace.folderFor(doc.GetURI())
+
+			handle, err := moveDocument(s.lifetime, doc, f.cache, target.cache)
+			if err != nil {
+				return err
+			}
+
+			moved = append(moved, handle)
+		}
+	}
+
+	rehomed, err := s.workspace.rehome(s.lifetime)
+	if err != nil {
+		return err
+	}
+
+	s.publishAll("Indexing workspace folders", append(moved, rehomed...))
+
+	if s.configurationSupported && len(added) > 0 {
+		go s.pullSettings(s.lifetime, added)
+	}
+
+	return nil
+}
+
+// clearFolderDiagnostics clears all diagnostics published for the documents of a folder.
+func (s *server) clearFolderDiagnostics(f *folder) {
+	for _, doc := range f.cache.GetDocuments() {
+		s.clearDiagnostics(s.lifetime, doc.GetURI(), 0)
+
+		diagnostics, err := doc.GetDiagnostics()
+		if err != nil {
+			continue
+		}
+
+		for uri := range diagnostics {
+			s.clearDiagnostics(s.lifetime, uri, 0)
+		}
+	}
+}
@@ -16,12 +16,21 @@ package lsp
 import (
 	c "context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"time"
 
+	"cuelang.org/go/cue/build"
 	"cuelang.org/go/cue/internal/lsp"
+	"cuelang.org/go/cue/internal/lsp/check"
+	"cuelang.org/go/cue/internal/lsp/rest"
 )
 
-func StartLSPServer(configFilePath string) {
+// StartLSPServer serves a single client over stdio.
+//
+// If debugAddress is not empty, it overrides the debug_address of the configuration file.
+func StartLSPServer(configFilePath string, debugAddress string) {
 
 	config, err := lsp.ParseConfigFile(configFilePath)
 	if err != nil {
@@ -29,6 +38,152 @@ func StartLSPServer(configFilePath string) {
 		os.Exit(1)
 	}
 
+	if debugAddress != "" {
+		config.DebugAddress = debugAddress
+	}
+
+	if err := lsp.StartTelemetry(c.Background(), config); err != nil {
+		fmt.Fprintln(os.Stderr, "Error starting telemetry:", err.Error())
+		os.Exit(1)
+	}
+
+	if config.RESTAPIPort != 0 {
+		fmt.Fprintln(os.Stderr, "REST API: Listening on port", config.RESTAPIPort)
+
+		handler, err := rest.CreateHandler(c.Background(), config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating REST API:", err.Error())
+			os.Exit(1)
+		}
+
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", config.RESTAPIPort), handler); err != nil {
+			fmt.Fprintln(os.Stderr, "Error serving REST API:", err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	_, s := lsp.StdioServer(c.Background(), config)
+
+	if config.DebugAddress != "" {
+		if err := s.ServeDebug(config.DebugAddress); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
 	s.Run()
 }
+
+// ListenLSPServer serves clients connecting to a TCP address or Unix socket, given as tcp:ADDR or unix:PATH.
+//
+// All clients share one cache. If idleTimeout is non-zero, the server stops after there were no clients for this duration.
+// If debugAddress is not empty, it overrides the debug_address of the configuration file.
+func ListenLSPServer(configFilePath string, listen string, idleTimeout time.Duration, debugAddress string) error {
+	network, addr, err := lsp.ParseListenAddress(listen)
+	if err != nil {
+		return err
+	}
+
+	config, err := lsp.ParseConfigFile(configFilePath)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	if debugAddress != "" {
+		config.DebugAddress = debugAddress
+	}
+
+	if err := lsp.StartTelemetry(c.Background(), config); err != nil {
+		return fmt.Errorf("error starting telemetry: %v", err)
+	}
+
+	return lsp.ListenAndServe(c.Background(), network, addr, config, idleTimeout)
+}
+
+// Check writes the diagnostics of all CUE files matching the patterns to w, in the text, json or sarif format.
+//
+// The configuration file is optional. It returns the number of errors found.
+func Check(w io.Writer, configFilePath string, format string, patterns []string) (int, error) {
+	if err := check.ValidateFormat(format); err != nil {
+		return 0, err
+	}
+
+	result, err := runCheck(configFilePath, patterns)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := check.Write(w, format, result); err != nil {
+		return 0, err
+	}
+
+	return result.Errors(), nil
+}
+
+// Lint writes the problems the lint rules find in loaded packages to w, in the text format.
+//
+// The configuration file is optional. It sets the severity of the rules.
+// It returns the number of problems with the error severity.
+func Lint(w io.Writer, configFilePath string, insts []*build.Instance) (int, error) {
+	config, err := optionalConfig(configFilePath)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := check.Lint(config, insts)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := check.Write(w, check.TextFormat, result); err != nil {
+		return 0, err
+	}
+
+	return result.Errors(), nil
+}
+
+// Replay feeds the messages of the client in a trace, recorded with rpc_trace: json, to a new server and writes
+// the differences between its responses and the recorded ones to w.
+//
+// The configuration file is optional. If root is not empty, the workspace of the recording is moved to this directory.
+// It returns the number of differences.
+func Replay(w io.Writer, configFilePath string, tracePath string, root string) (int, error) {
+	config, err := optionalConfig(configFilePath)
+	if err != nil {
+		return 0, err
+	}
+
+	trace, err := os.Open(tracePath)
+	if err != nil {
+		return 0, err
+	}
+	defer trace.Close()
+
+	return lsp.Replay(c.Background(), config, trace, root, w)
+}
+
+// runCheck collects the diagnostics of all CUE files matching the patterns, using an optional configuration file.
+func runCheck(configFilePath string, patterns []string) (*check.Result, error) {
+	config, err := optionalConfig(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return check.Run(c.Background(), config, patterns)
+}
+
+// optionalConfig reads a configuration file, or returns the default configuration if the path is empty.
+func optionalConfig(configFilePath string) (*lsp.Config, error) {
+	if configFilePath == "" {
+		return &lsp.Config{}, nil
+	}
+
+	config, err := lsp.ParseConfigFile(configFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	return config, nil
+}
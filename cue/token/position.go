@@ -247,6 +247,22 @@ func (f *File) LineCount() int {
 	return n
 }
 
+// LineStart returns the offset of the first character of the given line.
+// Unlike Position.Line, line is 0-based. LineStart panics if line is out of
+// range.
+//
+func (f *File) LineStart(line int) int {
+	if line < 0 {
+		panic("illegal line number")
+	}
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if line >= len(f.lines) {
+		panic("illegal line number")
+	}
+	return int(f.lines[line])
+}
+
 // AddLine adds the line offset for a new line.
 // The line offset must be larger than the offset for the previous line
 // and smaller than the file size; otherwise the line offset is ignored.
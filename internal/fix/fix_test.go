@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package cmd
+package fix
 
 import (
 	"testing"
@@ -161,7 +161,7 @@ c: {
 			if err != nil {
 				t.Fatal(err)
 			}
-			n := fix(f)
+			n := File(f)
 			b, err := format.Node(n)
 			if err != nil {
 				t.Fatal(err)